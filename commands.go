@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/jaxmef/datapipe/config"
+	"github.com/jaxmef/datapipe/engine"
+	"github.com/jaxmef/datapipe/pipetest"
+
+	"github.com/rs/zerolog"
+)
+
+func runCmd(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	configFilePath := configFlag(fs)
+	logLevel := fs.String("log-level", "", "override the log level from the config file")
+	once := fs.Bool("once", false, "run a single job and exit, the exit code reflects the job result")
+	dryRun := fs.Bool("dry-run", false, "log the requests of side-effecting handlers instead of sending them")
+	if err := fs.Parse(args); err != nil {
+		return flagErrorExitCode(err)
+	}
+
+	cfg, err := loadConfig(*configFilePath)
+	if err != nil {
+		log.Print(err)
+		return exitCodeError
+	}
+
+	if *logLevel != "" {
+		level, err := config.ParseLogLevel(*logLevel)
+		if err != nil {
+			log.Printf("invalid '-log-level' flag: %s", err)
+			return exitCodeUsage
+		}
+		cfg.Engine.Log.Level = level
+	}
+
+	if *dryRun {
+		cfg.Engine.DryRun = true
+	}
+
+	logger := zerolog.New(os.Stderr).Level(cfg.Engine.Log.Level.ToZerolog())
+	for k, v := range cfg.Engine.Log.StaticFields {
+		logger = logger.With().Str(k, v).Logger()
+	}
+
+	dp, err := engine.NewDataPipe(*cfg, logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to create data pipe")
+		return exitCodeError
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		logger.Info().Msg("shutting down")
+		cancel()
+	}()
+
+	if *once {
+		if err := dp.RunOnce(ctx); err != nil {
+			return exitCodeError
+		}
+		return exitCodeOK
+	}
+
+	dp.Run(ctx)
+	return exitCodeOK
+}
+
+func validateCmd(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configFilePath := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagErrorExitCode(err)
+	}
+
+	if _, err := loadConfig(*configFilePath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	fmt.Printf("config %s is valid\n", *configFilePath)
+	return exitCodeOK
+}
+
+func testCmd(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFilePath := configFlag(fs)
+	specFilePath := fs.String("spec", "", "path to the test spec file")
+	fs.StringVar(specFilePath, "s", "", "shorthand for -spec")
+	if err := fs.Parse(args); err != nil {
+		return flagErrorExitCode(err)
+	}
+	if *specFilePath == "" {
+		fmt.Fprintln(os.Stderr, "'-spec' flag is required")
+		return exitCodeUsage
+	}
+
+	cfg, err := loadConfig(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	spec := pipetest.Spec{}
+	if err := spec.ParseFromYamlFile(*specFilePath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	results, err := pipetest.Run(context.Background(), *cfg, spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Passed() {
+			fmt.Printf("PASS %s\n", result.Name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s\n", result.Name)
+		for _, mismatch := range result.Mismatches {
+			fmt.Printf("    %s\n", strings.ReplaceAll(mismatch, "\n", "\n    "))
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return exitCodeError
+	}
+	return exitCodeOK
+}
+
+func graphCmd(args []string) int {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	configFilePath := configFlag(fs)
+	format := fs.String("format", string(graphFormatDOT), "output format: dot or mermaid")
+	if err := fs.Parse(args); err != nil {
+		return flagErrorExitCode(err)
+	}
+
+	cfg, err := loadConfig(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	graph, err := renderGraph(*cfg.Handlers, graphFormat(*format))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeUsage
+	}
+
+	fmt.Print(graph)
+	return exitCodeOK
+}
+
+// configFlag registers the config file path flag, which defaults to the CONFIG_FILE_PATH env variable.
+func configFlag(fs *flag.FlagSet) *string {
+	defaultPath := os.Getenv(ConfigFilePathEnvVar)
+	if defaultPath == "" {
+		defaultPath = DefaultConfigFilePath
+	}
+
+	path := fs.String("config", defaultPath, "path to the config file")
+	fs.StringVar(path, "c", defaultPath, "shorthand for -config")
+	return path
+}
+
+// loadConfig parses the config file and runs all config checks.
+func loadConfig(filePath string) (*config.Config, error) {
+	cfg := config.NewConfig()
+	err := cfg.ParseFromYamlFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("config validation failed: %s", err)
+	}
+
+	return cfg, nil
+}
+
+func flagErrorExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitCodeOK
+	}
+	return exitCodeUsage
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCommandConfig = `
+engine:
+  interval: 1h
+  log:
+    level: error
+
+handlers:
+  data-source:
+    type: http
+    http:
+      url: %s
+      method: GET
+
+  filter:
+    type: filter
+    filter:
+      expression: '{{ %s.status }} == "new"'
+`
+
+// writeTestConfig writes a config whose source fetches the URL and whose filter references the handler.
+func writeTestConfig(t *testing.T, url, filterHandler string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(testCommandConfig, url, filterHandler)), 0o600))
+	return path
+}
+
+func TestRunCommand_ExitCodes(t *testing.T) {
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[{"status":"new"}]}`))
+	}))
+	defer okServer.Close()
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failingServer.Close()
+
+	validConfig := writeTestConfig(t, okServer.URL, "data-source")
+	failingConfig := writeTestConfig(t, failingServer.URL, "data-source")
+	invalidConfig := writeTestConfig(t, okServer.URL, "data-sorce")
+	missingConfig := filepath.Join(t.TempDir(), "missing.yaml")
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{name: "ValidateValid", args: []string{"validate", "-c", validConfig}, expected: exitCodeOK},
+		{name: "ValidateInvalid", args: []string{"validate", "-c", invalidConfig}, expected: exitCodeError},
+		{name: "ValidateMissingFile", args: []string{"validate", "-c", missingConfig}, expected: exitCodeError},
+		{name: "ValidateUnknownFlag", args: []string{"validate", "-unknown"}, expected: exitCodeUsage},
+		{name: "ValidateHelp", args: []string{"validate", "-h"}, expected: exitCodeOK},
+		{name: "RunOnce", args: []string{"run", "-once", "-c", validConfig}, expected: exitCodeOK},
+		{name: "RunOnceDefaultCommand", args: []string{"-once", "-c", validConfig}, expected: exitCodeOK},
+		{name: "RunOnceFailingJob", args: []string{"run", "-once", "-c", failingConfig}, expected: exitCodeError},
+		{name: "RunOnceInvalidConfig", args: []string{"run", "-once", "-c", invalidConfig}, expected: exitCodeError},
+		{
+			name:     "RunOnceInvalidLogLevel",
+			args:     []string{"run", "-once", "-c", validConfig, "-log-level", "loud"},
+			expected: exitCodeUsage,
+		},
+		{name: "GraphDOT", args: []string{"graph", "-c", validConfig}, expected: exitCodeOK},
+		{name: "GraphMermaid", args: []string{"graph", "-c", validConfig, "-format", "mermaid"}, expected: exitCodeOK},
+		{name: "GraphUnknownFormat", args: []string{"graph", "-c", validConfig, "-format", "svg"}, expected: exitCodeUsage},
+		{name: "GraphInvalidConfig", args: []string{"graph", "-c", invalidConfig}, expected: exitCodeError},
+		{name: "Version", args: []string{"version"}, expected: exitCodeOK},
+		{name: "UnknownCommand", args: []string{"deploy"}, expected: exitCodeUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, runCommand(tt.args))
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type AuthType string
+
+const (
+	AuthTypeBasic  AuthType = "basic"
+	AuthTypeBearer AuthType = "bearer"
+	AuthTypeOAuth2 AuthType = "oauth2"
+	AuthTypeHMAC   AuthType = "hmac"
+)
+
+// Auth describes how an HTTP handler authenticates its requests.
+type Auth struct {
+	Type AuthType `yaml:"type"`
+
+	Basic  BasicAuth  `yaml:"basic"`
+	Bearer BearerAuth `yaml:"bearer"`
+	OAuth2 OAuth2Auth `yaml:"oauth2"`
+	HMAC   HMACAuth   `yaml:"hmac"`
+}
+
+func (a Auth) Validate() error {
+	switch a.Type {
+	case "":
+		return nil
+	case AuthTypeBasic:
+		return a.Basic.Validate()
+	case AuthTypeBearer:
+		return a.Bearer.Validate()
+	case AuthTypeOAuth2:
+		return a.OAuth2.Validate()
+	case AuthTypeHMAC:
+		return a.HMAC.Validate()
+	default:
+		return fmt.Errorf("invalid 'type' value: %s", a.Type)
+	}
+}
+
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+}
+
+func (a BasicAuth) Validate() error {
+	if a.Username == "" {
+		return fmt.Errorf("'basic.username' is required")
+	}
+	if err := a.Password.Validate(); err != nil {
+		return fmt.Errorf("invalid 'basic.password': %s", err)
+	}
+	return nil
+}
+
+type BearerAuth struct {
+	Token Secret `yaml:"token"`
+}
+
+func (a BearerAuth) Validate() error {
+	if err := a.Token.Validate(); err != nil {
+		return fmt.Errorf("invalid 'bearer.token': %s", err)
+	}
+	return nil
+}
+
+type OAuth2AuthStyle string
+
+const (
+	// OAuth2AuthStyleHeader sends the client credentials in the Authorization header.
+	OAuth2AuthStyleHeader OAuth2AuthStyle = "header"
+	// OAuth2AuthStyleParams sends the client credentials in the request body.
+	OAuth2AuthStyleParams OAuth2AuthStyle = "params"
+)
+
+// OAuth2Auth gets tokens with the OAuth2 client credentials grant.
+type OAuth2Auth struct {
+	TokenURL     string          `yaml:"token_url"`
+	ClientID     string          `yaml:"client_id"`
+	ClientSecret Secret          `yaml:"client_secret"`
+	Scopes       []string        `yaml:"scopes"`
+	AuthStyle    OAuth2AuthStyle `yaml:"auth_style"`
+	// RefreshBefore is how long before the expiry a cached token is refreshed.
+	RefreshBefore time.Duration `yaml:"refresh_before"`
+}
+
+func (a OAuth2Auth) Validate() error {
+	if a.TokenURL == "" {
+		return fmt.Errorf("'oauth2.token_url' is required")
+	}
+	if a.ClientID == "" {
+		return fmt.Errorf("'oauth2.client_id' is required")
+	}
+	if err := a.ClientSecret.Validate(); err != nil {
+		return fmt.Errorf("invalid 'oauth2.client_secret': %s", err)
+	}
+	switch a.AuthStyle {
+	case "", OAuth2AuthStyleHeader, OAuth2AuthStyleParams:
+	default:
+		return fmt.Errorf("invalid 'oauth2.auth_style' value: %s", a.AuthStyle)
+	}
+	if a.RefreshBefore < 0 {
+		return fmt.Errorf("'oauth2.refresh_before' must not be negative")
+	}
+	return nil
+}
+
+// HMACAuth signs each request with an HMAC of its method, path with query and body.
+type HMACAuth struct {
+	Secret Secret `yaml:"secret"`
+	// Algorithm is sha256 or sha512.
+	Algorithm string `yaml:"algorithm"`
+	// Header is the header the signature is sent in.
+	Header string `yaml:"header"`
+	// TimestampHeader is the header the signing unix timestamp is sent in, the timestamp is signed if it is set.
+	TimestampHeader string `yaml:"timestamp_header"`
+	// Encoding is the signature encoding: hex or base64.
+	Encoding string `yaml:"encoding"`
+}
+
+func (a HMACAuth) Validate() error {
+	if err := a.Secret.Validate(); err != nil {
+		return fmt.Errorf("invalid 'hmac.secret': %s", err)
+	}
+	switch a.Algorithm {
+	case "", "sha256", "sha512":
+	default:
+		return fmt.Errorf("invalid 'hmac.algorithm' value: %s", a.Algorithm)
+	}
+	switch a.Encoding {
+	case "", "hex", "base64":
+	default:
+		return fmt.Errorf("invalid 'hmac.encoding' value: %s", a.Encoding)
+	}
+	return nil
+}
+
+// Secret is a sensitive value that is set in the config, read from an env variable or read from a file.
+// Files are read on each use, so rotated secrets are picked up without a restart.
+type Secret struct {
+	Value string `yaml:"value"`
+	Env   string `yaml:"env"`
+	File  string `yaml:"file"`
+}
+
+func (s Secret) Validate() error {
+	sources := 0
+	for _, source := range []string{s.Value, s.Env, s.File} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of 'value', 'env' or 'file' must be set")
+	}
+	return nil
+}
+
+// Read returns the secret value. Trailing newlines are trimmed from files.
+func (s Secret) Read() (string, error) {
+	switch {
+	case s.Env != "":
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("env variable '%s' is not set", s.Env)
+		}
+		return value, nil
+	case s.File != "":
+		value, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %s", err)
+		}
+		return strings.TrimRight(string(value), "\r\n"), nil
+	default:
+		return s.Value, nil
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const DefaultCircuitBreakerWindowSize = 20
+
+// CircuitBreaker stops sending requests of an HTTP handler after too many failures.
+// It is enabled if 'consecutive_failures' or 'failure_ratio' is set.
+type CircuitBreaker struct {
+	ConsecutiveFailures int     `yaml:"consecutive_failures"`
+	FailureRatio        float64 `yaml:"failure_ratio"`
+	// WindowSize is the number of the latest requests the failure ratio is computed over,
+	// DefaultCircuitBreakerWindowSize by default.
+	WindowSize int `yaml:"window_size"`
+	// MinRequests is the number of requests in the window required before the failure ratio is checked.
+	MinRequests      int           `yaml:"min_requests"`
+	OpenDuration     time.Duration `yaml:"open_duration"`
+	HalfOpenRequests int           `yaml:"half_open_requests"`
+}
+
+func (c CircuitBreaker) Enabled() bool {
+	return c.ConsecutiveFailures > 0 || c.FailureRatio > 0
+}
+
+func (c CircuitBreaker) Validate() error {
+	if c.ConsecutiveFailures < 0 {
+		return fmt.Errorf("'consecutive_failures' must not be negative")
+	}
+	if c.FailureRatio < 0 || c.FailureRatio > 1 {
+		return fmt.Errorf("'failure_ratio' must be between 0 and 1")
+	}
+	if c.WindowSize < 0 || c.MinRequests < 0 || c.HalfOpenRequests < 0 {
+		return fmt.Errorf("'window_size', 'min_requests' and 'half_open_requests' must not be negative")
+	}
+	windowSize := c.WindowSize
+	if windowSize == 0 {
+		windowSize = DefaultCircuitBreakerWindowSize
+	}
+	if c.MinRequests > windowSize {
+		return fmt.Errorf("'min_requests' must not be greater than 'window_size' (%d)", windowSize)
+	}
+	if c.OpenDuration < 0 {
+		return fmt.Errorf("'open_duration' must not be negative")
+	}
+	return nil
+}
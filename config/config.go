@@ -20,8 +20,15 @@ func (c *Config) Validate() error {
 	if err := c.Engine.Validate(); err != nil {
 		return fmt.Errorf("invalid engine config: %s", err)
 	}
+	if c.Engine.Mode == EngineModeStream && !(*c.Handlers)[0].Handler.IsStreamSource() {
+		return fmt.Errorf("stream mode requires a source handler that consumes messages, got '%s' handler",
+			(*c.Handlers)[0].Name)
+	}
 	handlerNames := make(map[string]struct{})
-	for _, handlerItem := range *c.Handlers {
+	for i, handlerItem := range *c.Handlers {
+		if isReservedHandlerName(handlerItem.Name) {
+			return fmt.Errorf("handler name '%s' is reserved", handlerItem.Name)
+		}
 		if _, ok := handlerNames[handlerItem.Name]; ok {
 			return fmt.Errorf("duplicate handler name: '%s'", handlerItem.Name)
 		}
@@ -30,10 +37,30 @@ func (c *Config) Validate() error {
 		if err := handlerItem.Handler.Validate(); err != nil {
 			return fmt.Errorf("config for '%s' handler is invalid: %s", handlerItem.Name, err)
 		}
+		if handlerItem.Handler.Type == HandlerTypeWebhook && i > 0 {
+			return fmt.Errorf("'%s' webhook handler must be the first handler of the chain", handlerItem.Name)
+		}
+		// the marker of listed objects is shared by all runs, so objects are listed once per run
+		if handlerItem.Handler.Type == HandlerTypeS3 && handlerItem.Handler.S3Handler.Mode == S3ModeList && i > 0 {
+			return fmt.Errorf("'%s' s3 handler in list mode must be the first handler of the chain", handlerItem.Name)
+		}
+		if group := handlerItem.Handler.Limits.Group; group != "" {
+			if _, ok := c.Engine.LimiterGroups[group]; !ok {
+				return fmt.Errorf("'%s' handler uses unknown limiter group: '%s'", handlerItem.Name, group)
+			}
+		}
+	}
+	if err := c.validatePlaceholders(); err != nil {
+		return fmt.Errorf("invalid placeholder: %s", err)
 	}
 	return nil
 }
 
+// isReservedHandlerName reports whether the name is used by built-in placeholders.
+func isReservedHandlerName(name string) bool {
+	return name == PipePlaceholderNamespace
+}
+
 func NewConfig() *Config {
 	return &Config{}
 }
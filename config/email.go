@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"time"
+)
+
+type EmailSecurity string
+
+const (
+	// EmailSecurityStartTLS upgrades the connection with STARTTLS, the server must support it. The default.
+	EmailSecurityStartTLS EmailSecurity = "starttls"
+	// EmailSecurityTLS connects with implicit TLS, usually on port 465.
+	EmailSecurityTLS EmailSecurity = "tls"
+	// EmailSecurityNone sends emails over a plain connection, e.g. to a local relay.
+	EmailSecurityNone EmailSecurity = "none"
+)
+
+// EmailHandler sends an email per record over SMTP, or a digest of the records of a run.
+type EmailHandler struct {
+	Host string `yaml:"host"`
+	// Port of the SMTP server, 587 by default, or 465 with implicit TLS.
+	Port     int           `yaml:"port"`
+	Security EmailSecurity `yaml:"security"`
+	TLS      *TLS          `yaml:"tls"`
+	// Username and Password authenticate with PLAIN auth, emails are sent without auth if Username is not set.
+	Username string  `yaml:"username"`
+	Password *Secret `yaml:"password"`
+
+	From string `yaml:"from"`
+	// To, Cc and Bcc are recipient addresses, an entry may render to a comma separated list or a JSON array
+	// of addresses. Placeholders are supported.
+	To  []string `yaml:"to"`
+	Cc  []string `yaml:"cc"`
+	Bcc []string `yaml:"bcc"`
+	// Subject, Text and HTML are rendered per record, at least one of Text and HTML is required.
+	// Placeholders are supported.
+	Subject string `yaml:"subject"`
+	Text    string `yaml:"text"`
+	HTML    string `yaml:"html"`
+
+	// Digest sends the records of a run in a single email per recipients and subject, instead of an email per record.
+	Digest *EmailDigest `yaml:"digest"`
+
+	// Timeout of sending an email, 30s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// EmailDigest wraps the text and the HTML of the records of a digest.
+type EmailDigest struct {
+	TextHeader string `yaml:"text_header"`
+	TextFooter string `yaml:"text_footer"`
+	HTMLHeader string `yaml:"html_header"`
+	HTMLFooter string `yaml:"html_footer"`
+}
+
+func (h EmailHandler) Validate() error {
+	if h.Host == "" {
+		return fmt.Errorf("'host' is required")
+	}
+	if h.Port < 0 || h.Timeout < 0 {
+		return fmt.Errorf("'port' and 'timeout' must not be negative")
+	}
+	switch h.Security {
+	case "", EmailSecurityStartTLS, EmailSecurityTLS, EmailSecurityNone:
+	default:
+		return fmt.Errorf("invalid 'security' value: %s", h.Security)
+	}
+	if h.TLS != nil {
+		if err := h.TLS.Validate(); err != nil {
+			return fmt.Errorf("invalid 'tls' config: %s", err)
+		}
+	}
+	if (h.Username == "") != (h.Password == nil) {
+		return fmt.Errorf("'username' and 'password' must be set together")
+	}
+	if h.Password != nil {
+		if err := h.Password.Validate(); err != nil {
+			return fmt.Errorf("invalid 'password' config: %s", err)
+		}
+	}
+
+	if _, err := mail.ParseAddress(h.From); err != nil {
+		return fmt.Errorf("invalid 'from' address: %s", err)
+	}
+	if len(h.To)+len(h.Cc)+len(h.Bcc) == 0 {
+		return fmt.Errorf("at least one of 'to', 'cc' or 'bcc' is required")
+	}
+	if h.Text == "" && h.HTML == "" {
+		return fmt.Errorf("at least one of 'text' or 'html' is required")
+	}
+	return nil
+}
+
+func (h EmailHandler) placeholders(path string) []placeholderRef {
+	refs := listPlaceholders(path+".to", h.To)
+	refs = append(refs, listPlaceholders(path+".cc", h.Cc)...)
+	refs = append(refs, listPlaceholders(path+".bcc", h.Bcc)...)
+	refs = append(refs, findPlaceholders(path+".subject", h.Subject)...)
+	refs = append(refs, findPlaceholders(path+".text", h.Text)...)
+	refs = append(refs, findPlaceholders(path+".html", h.HTML)...)
+	return refs
+}
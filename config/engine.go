@@ -7,17 +7,55 @@ import (
 	"github.com/rs/zerolog"
 )
 
+type EngineMode string
+
+const (
+	// EngineModeSchedule runs the chain as a batch job on schedule, the default.
+	EngineModeSchedule EngineMode = "schedule"
+	// EngineModeStream runs the source handler continuously and passes each record down the chain as it arrives.
+	EngineModeStream EngineMode = "stream"
+)
+
 type Engine struct {
+	Mode              EngineMode    `yaml:"mode"`
 	DisableRunOnStart bool          `yaml:"disable_run_on_start"`
 	Interval          time.Duration `yaml:"interval"`
 	RunAt             string        `yaml:"run_at"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	DryRun            bool          `yaml:"dry_run"`
+	// MaxInFlight limits the number of records of the source handler that are processed at once, unlimited if 0.
+	MaxInFlight int `yaml:"max_in_flight"`
+	// RestartDelay is the delay before the source handler is restarted after it failed in stream mode, 5s by default.
+	RestartDelay time.Duration `yaml:"restart_delay"`
+
+	LimiterGroups map[string]LimiterGroup `yaml:"limiter_groups"`
 
 	Log Log `yaml:"log"`
 }
 
 func (e Engine) Validate() error {
-	if e.Interval <= 0 {
-		return fmt.Errorf("'interval' must be greater than 0")
+	switch e.Mode {
+	case "", EngineModeSchedule:
+		if e.Interval <= 0 {
+			return fmt.Errorf("'interval' must be greater than 0")
+		}
+	case EngineModeStream:
+		if e.RunAt != "" {
+			return fmt.Errorf("'run_at' is not supported in stream mode")
+		}
+	default:
+		return fmt.Errorf("invalid 'mode' value: %s", e.Mode)
+	}
+	if e.ShutdownTimeout < 0 {
+		return fmt.Errorf("'shutdown_timeout' must not be negative")
+	}
+	if e.MaxInFlight < 0 || e.RestartDelay < 0 {
+		return fmt.Errorf("'max_in_flight' and 'restart_delay' must not be negative")
+	}
+	for name, group := range e.LimiterGroups {
+		if err := group.Validate(); err != nil {
+			return fmt.Errorf("invalid '%s' limiter group: %s", name, err)
+		}
 	}
 	return nil
 }
@@ -37,6 +75,16 @@ const (
 	LogLevelDisabled LogLevel = "disabled"
 )
 
+// ParseLogLevel converts s into a LogLevel, returning an error for unknown levels.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch l := LogLevel(s); l {
+	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError, LogLevelDisabled:
+		return l, nil
+	default:
+		return "", fmt.Errorf("unknown log level: '%s'", s)
+	}
+}
+
 func (l LogLevel) ToZerolog() zerolog.Level {
 	switch l {
 	case LogLevelDebug:
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const DefaultGraphQLCursorVariable = "after"
+
+// GraphQLHandler sends a GraphQL query per record and emits the objects found at the results path.
+type GraphQLHandler struct {
+	URL string `yaml:"url"`
+	// Headers of the request. Placeholders are supported.
+	Headers map[string]string `yaml:"headers"`
+	// Query is the GraphQL document, OperationName selects the operation if the document has several of them.
+	Query         string `yaml:"query"`
+	OperationName string `yaml:"operation_name"`
+	// Variables of the query. Values are JSON, or strings if they are not valid JSON. Placeholders are supported.
+	Variables map[string]string `yaml:"variables"`
+	// ResultsPath is the dot-separated path to the results in the 'data' of the response, e.g. 'orders.nodes'.
+	// An array is a result per element, an object is a single result.
+	ResultsPath string             `yaml:"results_path"`
+	Pagination  *GraphQLPagination `yaml:"pagination"`
+	Auth        Auth               `yaml:"auth"`
+	TLS         TLS                `yaml:"tls"`
+	// Timeout of a request, 15s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GraphQLPagination follows the cursor of a connection, as long as its 'pageInfo { endCursor hasNextPage }'
+// reports more pages.
+type GraphQLPagination struct {
+	// PageInfoPath is the dot-separated path to the 'pageInfo' object in the 'data' of the response,
+	// e.g. 'orders.pageInfo'.
+	PageInfoPath string `yaml:"page_info_path"`
+	// CursorVariable is the variable the end cursor is passed in, 'after' by default.
+	CursorVariable string `yaml:"cursor_variable"`
+	// MaxPages stops the pagination after this number of pages, DefaultMaxPages by default.
+	MaxPages int `yaml:"max_pages"`
+}
+
+func (h GraphQLHandler) Validate() error {
+	if h.URL == "" {
+		return fmt.Errorf("'url' is required")
+	}
+	if _, err := url.Parse(h.URL); err != nil {
+		return fmt.Errorf("invalid 'url': %s", err)
+	}
+	if h.Query == "" {
+		return fmt.Errorf("'query' is required")
+	}
+	if err := validateJSONPath(h.ResultsPath); err != nil {
+		return fmt.Errorf("invalid 'results_path': %s", err)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("'timeout' must not be negative")
+	}
+	if err := h.Auth.Validate(); err != nil {
+		return fmt.Errorf("invalid 'auth' config: %s", err)
+	}
+	if err := h.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid 'tls' config: %s", err)
+	}
+
+	if p := h.Pagination; p != nil {
+		if err := validateJSONPath(p.PageInfoPath); err != nil {
+			return fmt.Errorf("invalid 'pagination' config: invalid 'page_info_path': %s", err)
+		}
+		if p.MaxPages < 0 {
+			return fmt.Errorf("invalid 'pagination' config: 'max_pages' must not be negative")
+		}
+		variable := p.CursorVariable
+		if variable == "" {
+			variable = DefaultGraphQLCursorVariable
+		}
+		if _, ok := h.Variables[variable]; ok {
+			return fmt.Errorf("'%s' variable is set by the pagination and must not be in 'variables'", variable)
+		}
+		if !regexp.MustCompile(`\$` + regexp.QuoteMeta(variable) + `\b`).MatchString(h.Query) {
+			return fmt.Errorf("'query' must declare the '$%s' variable of the pagination", variable)
+		}
+	}
+	return nil
+}
+
+// validateJSONPath checks that a required dot-separated path has no empty keys.
+func validateJSONPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("path is required")
+	}
+	for _, key := range JSONPathKeys(path) {
+		if key == "" {
+			return fmt.Errorf("invalid path: %s", path)
+		}
+	}
+	return nil
+}
+
+func (h GraphQLHandler) placeholders(path string) []placeholderRef {
+	refs := mapPlaceholders(path+".headers", h.Headers)
+	refs = append(refs, mapPlaceholders(path+".variables", h.Variables)...)
+	return refs
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GRPCHandler calls a unary gRPC method. The request message is built from the templated JSON body,
+// and the response message is converted into JSON.
+type GRPCHandler struct {
+	// Target is the address of the server, e.g. 'localhost:50051'.
+	Target string `yaml:"target"`
+	// Method is the full name of the method, e.g. 'users.v1.UserService/GetUser'.
+	Method string `yaml:"method"`
+	// DescriptorSetFile is a file descriptor set of the service (protoc --include_imports --descriptor_set_out).
+	// If it's not set, the service is resolved with server reflection.
+	DescriptorSetFile string            `yaml:"descriptor_set_file"`
+	Body              string            `yaml:"body"`
+	Metadata          map[string]string `yaml:"metadata"`
+	// ResultsField is the dot-separated path to a repeated field of the response whose elements are the results.
+	// If it's not set, the whole response is a single result.
+	ResultsField string        `yaml:"results_field"`
+	Timeout      time.Duration `yaml:"timeout"`
+	// Plaintext disables TLS.
+	Plaintext bool `yaml:"plaintext"`
+	TLS       TLS  `yaml:"tls"`
+
+	Retries       int           `yaml:"retries"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	// RetryPolicy only uses the backoff options, retryable status codes are set by RetryableCodes.
+	RetryPolicy RetryPolicy `yaml:"retry_policy"`
+	// RetryableCodes lists the gRPC status codes that are retried, e.g. 'UNAVAILABLE'. By default UNAVAILABLE,
+	// DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED and ABORTED are retried.
+	RetryableCodes []string `yaml:"retryable_codes"`
+}
+
+// GRPCCodes lists the gRPC status code names.
+// nolint: gochecknoglobals
+var GRPCCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+	"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func (h GRPCHandler) Validate() error {
+	if h.Target == "" {
+		return fmt.Errorf("'target' is required")
+	}
+	if _, _, err := h.ServiceAndMethod(); err != nil {
+		return err
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("'timeout' must not be negative")
+	}
+	if h.Retries < 0 || h.RetryInterval < 0 {
+		return fmt.Errorf("'retries' and 'retry_interval' must not be negative")
+	}
+	if h.ResultsField != "" {
+		for _, key := range JSONPathKeys(h.ResultsField) {
+			if key == "" {
+				return fmt.Errorf("invalid 'results_field': %s", h.ResultsField)
+			}
+		}
+	}
+	if h.Plaintext && (h.TLS != TLS{}) {
+		return fmt.Errorf("'plaintext' and 'tls' are mutually exclusive")
+	}
+	if err := h.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid 'tls' config: %s", err)
+	}
+	if len(h.RetryPolicy.RetryableStatusCodes) > 0 || len(h.RetryPolicy.RetryableErrors) > 0 {
+		return fmt.Errorf("'retry_policy' retryable status codes and errors are not supported, use 'retryable_codes'")
+	}
+	if err := h.RetryPolicy.Validate(); err != nil {
+		return fmt.Errorf("invalid 'retry_policy' config: %s", err)
+	}
+	for _, code := range h.RetryableCodes {
+		if !contains(GRPCCodes, code) {
+			return fmt.Errorf("invalid retryable code: %s", code)
+		}
+	}
+	return nil
+}
+
+// ServiceAndMethod splits Method into the full service name and the method name.
+func (h GRPCHandler) ServiceAndMethod() (string, string, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(h.Method, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", fmt.Errorf("'method' must be in the format 'package.Service/Method'")
+	}
+	return service, method, nil
+}
+
+func (h GRPCHandler) placeholders(path string) []placeholderRef {
+	refs := findPlaceholders(path+".body", h.Body)
+	refs = append(refs, mapPlaceholders(path+".metadata", h.Metadata)...)
+	return refs
+}
@@ -13,28 +13,60 @@ import (
 type HandlerType string
 
 const (
-	HandlerTypeHTTP   HandlerType = "http"
-	HandlerTypeFilter HandlerType = "filter"
+	HandlerTypeHTTP    HandlerType = "http"
+	HandlerTypeFilter  HandlerType = "filter"
+	HandlerTypeGRPC    HandlerType = "grpc"
+	HandlerTypeKafka   HandlerType = "kafka"
+	HandlerTypeAMQP    HandlerType = "amqp"
+	HandlerTypeWebhook HandlerType = "webhook"
+	HandlerTypeSQL     HandlerType = "sql"
+	HandlerTypeRedis   HandlerType = "redis"
+	HandlerTypeS3      HandlerType = "s3"
+	HandlerTypeEmail   HandlerType = "email"
+	HandlerTypeGraphQL HandlerType = "graphql"
 )
 
 type Handler struct {
 	Type HandlerType `yaml:"type"`
+	// OutputSchema optionally lists the fields of the handler results, placeholders are validated against it.
+	OutputSchema []string `yaml:"output_schema"`
+	Limits       Limits   `yaml:"limits"`
 
-	HTTPHandler   HTTPHandler   `yaml:"http"`
-	FilterHandler FilterHandler `yaml:"filter"`
+	HTTPHandler    HTTPHandler    `yaml:"http"`
+	FilterHandler  FilterHandler  `yaml:"filter"`
+	GRPCHandler    GRPCHandler    `yaml:"grpc"`
+	KafkaHandler   KafkaHandler   `yaml:"kafka"`
+	AMQPHandler    AMQPHandler    `yaml:"amqp"`
+	WebhookHandler WebhookHandler `yaml:"webhook"`
+	SQLHandler     SQLHandler     `yaml:"sql"`
+	RedisHandler   RedisHandler   `yaml:"redis"`
+	S3Handler      S3Handler      `yaml:"s3"`
+	EmailHandler   EmailHandler   `yaml:"email"`
+	GraphQLHandler GraphQLHandler `yaml:"graphql"`
 }
 
 type HTTPHandler struct {
-	URL                  string            `yaml:"url"`
-	Method               string            `yaml:"method"`
-	Body                 string            `yaml:"body"`
-	Headers              map[string]string `yaml:"headers"`
-	QueryParams          map[string]string `yaml:"query_params"`
-	Timeout              time.Duration     `yaml:"timeout"`
-	ExpectedResponseCode int               `yaml:"expected_response_code"`
-	Retries              int               `yaml:"retries"`
-	RetryInterval        time.Duration     `yaml:"retry_interval"`
-	ParallelRun          bool              `yaml:"parallel_run"`
+	URL                   string                      `yaml:"url"`
+	Method                string                      `yaml:"method"`
+	Body                  string                      `yaml:"body"`
+	Headers               map[string]string           `yaml:"headers"`
+	QueryParams           map[string]string           `yaml:"query_params"`
+	Timeout               time.Duration               `yaml:"timeout"`
+	ExpectedResponseCode  int                         `yaml:"expected_response_code"`
+	ExpectedResponseCodes []int                       `yaml:"expected_response_codes"`
+	ResponseCodeOutcomes  map[int]ResponseCodeOutcome `yaml:"response_code_outcomes"`
+	Response              Response                    `yaml:"response"`
+	Pagination            Pagination                  `yaml:"pagination"`
+	Retries               int                         `yaml:"retries"`
+	RetryInterval         time.Duration               `yaml:"retry_interval"`
+	RetryPolicy           RetryPolicy                 `yaml:"retry_policy"`
+	CircuitBreaker        CircuitBreaker              `yaml:"circuit_breaker"`
+	ParallelRun           bool                        `yaml:"parallel_run"`
+	DryRun                DryRun                      `yaml:"dry_run"`
+	Auth                  Auth                        `yaml:"auth"`
+	TLS                   TLS                         `yaml:"tls"`
+	Proxy                 Proxy                       `yaml:"proxy"`
+	Transport             Transport                   `yaml:"transport"`
 }
 
 func (h HTTPHandler) Validate() error {
@@ -44,6 +76,68 @@ func (h HTTPHandler) Validate() error {
 	if h.URL == "" {
 		return fmt.Errorf("'url' is required")
 	}
+	if h.Retries < 0 || h.RetryInterval < 0 {
+		return fmt.Errorf("'retries' and 'retry_interval' must not be negative")
+	}
+	if err := h.validateResponseCodes(); err != nil {
+		return err
+	}
+	if err := h.Response.Validate(); err != nil {
+		return fmt.Errorf("invalid 'response' config: %s", err)
+	}
+	if err := h.Pagination.Validate(); err != nil {
+		return fmt.Errorf("invalid 'pagination' config: %s", err)
+	}
+	if err := h.RetryPolicy.Validate(); err != nil {
+		return fmt.Errorf("invalid 'retry_policy' config: %s", err)
+	}
+	if err := h.CircuitBreaker.Validate(); err != nil {
+		return fmt.Errorf("invalid 'circuit_breaker' config: %s", err)
+	}
+	if err := h.DryRun.Validate(); err != nil {
+		return fmt.Errorf("invalid 'dry_run' config: %s", err)
+	}
+	if err := h.Auth.Validate(); err != nil {
+		return fmt.Errorf("invalid 'auth' config: %s", err)
+	}
+	if err := h.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid 'tls' config: %s", err)
+	}
+	if err := h.Proxy.Validate(); err != nil {
+		return fmt.Errorf("invalid 'proxy' config: %s", err)
+	}
+	if err := h.Transport.Validate(); err != nil {
+		return fmt.Errorf("invalid 'transport' config: %s", err)
+	}
+	return nil
+}
+
+type DryRunMode string
+
+const (
+	// DryRunModeExecute sends the request as usual in dry-run mode.
+	DryRunModeExecute DryRunMode = "execute"
+	// DryRunModeRecord only logs the rendered request in dry-run mode and returns the canned response.
+	DryRunModeRecord DryRunMode = "record"
+)
+
+// DryRun describes how an HTTP handler behaves when the engine runs in dry-run mode.
+// By default, the first handler of the chain is executed and all other handlers are recorded.
+type DryRun struct {
+	Mode         DryRunMode `yaml:"mode"`
+	Response     string     `yaml:"response"`
+	ResponseFile string     `yaml:"response_file"`
+}
+
+func (d DryRun) Validate() error {
+	switch d.Mode {
+	case "", DryRunModeExecute, DryRunModeRecord:
+	default:
+		return fmt.Errorf("invalid 'mode' value: %s", d.Mode)
+	}
+	if d.Response != "" && d.ResponseFile != "" {
+		return fmt.Errorf("'response' and 'response_file' are mutually exclusive")
+	}
 	return nil
 }
 
@@ -67,7 +161,7 @@ func (h FilterHandler) Validate() error {
 }
 
 func replacePlaceholdersForValidation(e string) string {
-	re := regexp.MustCompile(`\{\{\s*([^\s}]+)\s*\}\}`)
+	re := regexp.MustCompile(placeholderPattern)
 
 	return re.ReplaceAllStringFunc(e, func(m string) string {
 		key := strings.TrimSpace(m[2 : len(m)-2])
@@ -76,16 +170,68 @@ func replacePlaceholdersForValidation(e string) string {
 }
 
 func (h Handler) Validate() error {
+	if err := h.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid 'limits' config: %s", err)
+	}
+	if h.Limits.IsSet() && !h.appliesLimits() {
+		return fmt.Errorf("'limits' is not supported by %s handlers", h.Type)
+	}
+
 	switch h.Type {
 	case HandlerTypeHTTP, "":
 		return h.HTTPHandler.Validate()
 	case HandlerTypeFilter:
 		return h.FilterHandler.Validate()
+	case HandlerTypeGRPC:
+		return h.GRPCHandler.Validate()
+	case HandlerTypeKafka:
+		return h.KafkaHandler.Validate()
+	case HandlerTypeAMQP:
+		return h.AMQPHandler.Validate()
+	case HandlerTypeWebhook:
+		return h.WebhookHandler.Validate()
+	case HandlerTypeSQL:
+		return h.SQLHandler.Validate()
+	case HandlerTypeRedis:
+		return h.RedisHandler.Validate()
+	case HandlerTypeS3:
+		return h.S3Handler.Validate()
+	case HandlerTypeEmail:
+		return h.EmailHandler.Validate()
+	case HandlerTypeGraphQL:
+		return h.GraphQLHandler.Validate()
 	default:
 		return fmt.Errorf("invalid 'type' value: %s", h.Type)
 	}
 }
 
+// appliesLimits reports whether the handler bounds its requests with 'limits'.
+func (h Handler) appliesLimits() bool {
+	switch h.Type {
+	case HandlerTypeHTTP, "", HandlerTypeGRPC, HandlerTypeSQL, HandlerTypeS3, HandlerTypeEmail, HandlerTypeGraphQL:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsStreamSource reports whether the handler consumes messages of a queue or receives pushed records,
+// so it can be the source of a stream.
+func (h Handler) IsStreamSource() bool {
+	switch h.Type {
+	case HandlerTypeWebhook:
+		return true
+	case HandlerTypeKafka:
+		return h.KafkaHandler.Mode == KafkaModeConsume
+	case HandlerTypeAMQP:
+		return h.AMQPHandler.Mode == AMQPModeConsume
+	case HandlerTypeRedis:
+		return h.RedisHandler.Command == RedisCommandXRead
+	default:
+		return false
+	}
+}
+
 // HandlerMap is a list of HandlerMapItem. It is used to guarantee the order of the handlers.
 type HandlerMap []HandlerMapItem
 
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type KafkaMode string
+
+const (
+	// KafkaModeConsume reads messages of a topic with a consumer group, one result per message.
+	KafkaModeConsume KafkaMode = "consume"
+	// KafkaModeProduce writes a message per record.
+	KafkaModeProduce KafkaMode = "produce"
+)
+
+type KafkaStartOffset string
+
+const (
+	KafkaStartOffsetFirst KafkaStartOffset = "first"
+	KafkaStartOffsetLast  KafkaStartOffset = "last"
+)
+
+// KafkaHandler consumes or produces Kafka messages.
+type KafkaHandler struct {
+	Mode    KafkaMode `yaml:"mode"`
+	Brokers []string  `yaml:"brokers"`
+	// Topic to consume from or to produce to. Placeholders are supported when producing.
+	Topic string `yaml:"topic"`
+	TLS   *TLS   `yaml:"tls"`
+
+	// GroupID is the consumer group, required to consume.
+	GroupID string `yaml:"group_id"`
+	// StartOffset is where a group without committed offsets starts consuming, 'first' by default.
+	StartOffset KafkaStartOffset `yaml:"start_offset"`
+	// MaxMessages is the number of messages consumed per run, 100 by default.
+	MaxMessages int `yaml:"max_messages"`
+	// WaitTime ends a run when no message arrives for this long, 10s by default.
+	WaitTime time.Duration `yaml:"wait_time"`
+
+	// Key, Value and Headers of the produced message. Placeholders are supported.
+	Key     string            `yaml:"key"`
+	Value   string            `yaml:"value"`
+	Headers map[string]string `yaml:"headers"`
+	// Timeout of producing a message, 15s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h KafkaHandler) Validate() error {
+	if len(h.Brokers) == 0 {
+		return fmt.Errorf("'brokers' is required")
+	}
+	if h.Topic == "" {
+		return fmt.Errorf("'topic' is required")
+	}
+	if h.TLS != nil {
+		if err := h.TLS.Validate(); err != nil {
+			return fmt.Errorf("invalid 'tls' config: %s", err)
+		}
+	}
+	if h.MaxMessages < 0 || h.WaitTime < 0 || h.Timeout < 0 {
+		return fmt.Errorf("'max_messages', 'wait_time' and 'timeout' must not be negative")
+	}
+
+	switch h.Mode {
+	case KafkaModeConsume:
+		if h.GroupID == "" {
+			return fmt.Errorf("'group_id' is required to consume")
+		}
+		if len(Placeholders(h.Topic)) > 0 {
+			return fmt.Errorf("placeholders in 'topic' are only supported to produce")
+		}
+		switch h.StartOffset {
+		case "", KafkaStartOffsetFirst, KafkaStartOffsetLast:
+		default:
+			return fmt.Errorf("invalid 'start_offset' value: %s", h.StartOffset)
+		}
+	case KafkaModeProduce:
+		if h.Value == "" {
+			return fmt.Errorf("'value' is required to produce")
+		}
+	default:
+		return fmt.Errorf("invalid 'mode' value: %s", h.Mode)
+	}
+	return nil
+}
+
+func (h KafkaHandler) placeholders(path string) []placeholderRef {
+	if h.Mode != KafkaModeProduce {
+		return nil
+	}
+	refs := findPlaceholders(path+".topic", h.Topic)
+	refs = append(refs, findPlaceholders(path+".key", h.Key)...)
+	refs = append(refs, findPlaceholders(path+".value", h.Value)...)
+	refs = append(refs, mapPlaceholders(path+".headers", h.Headers)...)
+	return refs
+}
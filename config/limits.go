@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of requests per time unit, e.g. "50/s", "100/m" or "1000/h".
+type Rate string
+
+// PerSecond returns the rate in requests per second, or 0 if the rate is not set.
+func (r Rate) PerSecond() (float64, error) {
+	if r == "" {
+		return 0, nil
+	}
+
+	count, unit, ok := strings.Cut(string(r), "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid rate '%s': expected format is '<count>/<unit>'", r)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s': count must be a positive number", r)
+	}
+
+	var period time.Duration
+	switch strings.TrimSpace(unit) {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return 0, fmt.Errorf("invalid rate '%s': unit must be 's', 'm' or 'h'", r)
+	}
+
+	return n / period.Seconds(), nil
+}
+
+// LimiterGroup bounds the concurrency and the rate of requests.
+type LimiterGroup struct {
+	MaxConcurrency int  `yaml:"max_concurrency"`
+	RateLimit      Rate `yaml:"rate_limit"`
+	Burst          int  `yaml:"burst"`
+}
+
+func (g LimiterGroup) Validate() error {
+	if g.MaxConcurrency < 0 {
+		return fmt.Errorf("'max_concurrency' must not be negative")
+	}
+	if _, err := g.RateLimit.PerSecond(); err != nil {
+		return fmt.Errorf("invalid 'rate_limit': %s", err)
+	}
+	if g.Burst < 0 {
+		return fmt.Errorf("'burst' must not be negative")
+	}
+	return nil
+}
+
+// Limits bound the requests of a single handler. Handlers with the same group
+// are also bound by the limits of the group defined in 'engine.limiter_groups'.
+type Limits struct {
+	LimiterGroup `yaml:",inline"`
+	Group        string `yaml:"group"`
+}
+
+// IsSet reports whether any limit is configured.
+func (l Limits) IsSet() bool {
+	return l != Limits{}
+}
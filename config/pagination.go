@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+)
+
+type PaginationType string
+
+const (
+	// PaginationTypePage requests pages by their number.
+	PaginationTypePage PaginationType = "page"
+	// PaginationTypeOffset requests pages by the offset of their first result.
+	PaginationTypeOffset PaginationType = "offset"
+	// PaginationTypeCursor requests pages by a cursor taken from the previous response.
+	PaginationTypeCursor PaginationType = "cursor"
+	// PaginationTypeLink follows the RFC 5988 'Link: <url>; rel="next"' response header.
+	PaginationTypeLink PaginationType = "link"
+)
+
+const DefaultMaxPages = 100
+
+// Pagination defines how the pages of a paginated HTTP source are requested.
+// Page, offset, limit and cursor values are sent as query params.
+type Pagination struct {
+	Type PaginationType `yaml:"type"`
+	// MaxPages stops the pagination after this number of pages, DefaultMaxPages by default.
+	MaxPages int `yaml:"max_pages"`
+
+	// PageParam is the query param of the page number, 'page' by default.
+	PageParam string `yaml:"page_param"`
+	// StartPage is the number of the first page, 1 by default.
+	StartPage *int `yaml:"start_page"`
+	// OffsetParam is the query param of the offset, 'offset' by default.
+	OffsetParam string `yaml:"offset_param"`
+	// LimitParam is the query param of the page size, 'limit' by default. It's sent only if PageSize is set.
+	LimitParam string `yaml:"limit_param"`
+	// PageSize is the number of results per page. A page with fewer results is the last one.
+	PageSize int `yaml:"page_size"`
+
+	// CursorParam is the query param of the cursor, 'cursor' by default.
+	CursorParam string `yaml:"cursor_param"`
+	// CursorPath is the dot-separated path to the next cursor in the JSON response body.
+	CursorPath string `yaml:"cursor_path"`
+}
+
+func (p Pagination) Enabled() bool {
+	return p.Type != ""
+}
+
+func (p Pagination) Validate() error {
+	switch p.Type {
+	case "", PaginationTypePage, PaginationTypeOffset, PaginationTypeLink:
+	case PaginationTypeCursor:
+		if p.CursorPath == "" {
+			return fmt.Errorf("'cursor_path' is required for 'cursor' pagination")
+		}
+		for _, key := range JSONPathKeys(p.CursorPath) {
+			if key == "" {
+				return fmt.Errorf("invalid 'cursor_path': %s", p.CursorPath)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid 'type' value: %s", p.Type)
+	}
+
+	if p.MaxPages < 0 {
+		return fmt.Errorf("'max_pages' must not be negative")
+	}
+	if p.PageSize < 0 {
+		return fmt.Errorf("'page_size' must not be negative")
+	}
+	return nil
+}
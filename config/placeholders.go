@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderPattern matches placeholders in the format {{ key }}.
+const placeholderPattern = `\{\{\s*([^\s}]+)\s*\}\}`
+
+// PipePlaceholderNamespace is the handler name of built-in placeholders, e.g. '{{ _pipe.retry_attempt }}',
+// so they don't collide with the placeholders of handlers.
+const PipePlaceholderNamespace = "_pipe"
+
+// Placeholders returns the keys of all placeholders found in s.
+func Placeholders(s string) []string {
+	re := regexp.MustCompile(placeholderPattern)
+
+	var keys []string
+	for _, m := range re.FindAllStringSubmatch(s, -1) {
+		keys = append(keys, m[1])
+	}
+	return keys
+}
+
+// placeholderRef is a placeholder found in the config, path points to the config option that contains it.
+type placeholderRef struct {
+	path string
+	key  string
+}
+
+// validatePlaceholders checks that every placeholder references a handler that appears earlier in the chain
+// and, if that handler declares an output schema, one of its fields.
+func (c *Config) validatePlaceholders() error {
+	previousHandlers := map[string]Handler{}
+	for _, handlerItem := range *c.Handlers {
+		for _, ref := range handlerItem.Handler.placeholders("handlers." + handlerItem.Name) {
+			if ref.key == RetryAttemptPlaceholder && handlerItem.Handler.hasRetries() {
+				continue
+			}
+			if ref.key == S3BatchIDPlaceholder && handlerItem.Handler.writesS3Objects() {
+				continue
+			}
+			if strings.HasPrefix(ref.key, PipePlaceholderNamespace+".") {
+				return fmt.Errorf("%s: built-in placeholder '{{ %s }}' is not available here", ref.path, ref.key)
+			}
+			handlerName, field, ok := splitPlaceholderKey(ref.key, previousHandlers)
+			if !ok {
+				return fmt.Errorf(
+					"%s: placeholder '{{ %s }}' does not reference any previous handler",
+					ref.path, ref.key,
+				)
+			}
+
+			schema := previousHandlers[handlerName].outputFields()
+			if len(schema) > 0 && !containsField(schema, field) {
+				return fmt.Errorf(
+					"%s: placeholder '{{ %s }}' references '%s' field, which is not in the 'output_schema' of '%s' handler",
+					ref.path, ref.key, field, handlerName,
+				)
+			}
+		}
+		previousHandlers[handlerItem.Name] = handlerItem.Handler
+	}
+	return nil
+}
+
+// splitPlaceholderKey splits a placeholder key into the name of the handler it references and the field name.
+func splitPlaceholderKey(key string, handlers map[string]Handler) (string, string, bool) {
+	// handler names may contain dots, so the longest matching name wins
+	handlerName := ""
+	for name := range handlers {
+		if strings.HasPrefix(key, name+".") && len(name) > len(handlerName) {
+			handlerName = name
+		}
+	}
+	if handlerName == "" {
+		return "", "", false
+	}
+	return handlerName, strings.TrimPrefix(key, handlerName+"."), true
+}
+
+// containsField reports whether the field, or the field that contains the dot-separated path, is in the schema.
+func containsField(schema []string, field string) bool {
+	for _, f := range schema {
+		if f == field || strings.HasPrefix(field, f+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Handler) isHTTP() bool {
+	return h.Type == HandlerTypeHTTP || h.Type == ""
+}
+
+// hasRetries reports whether the handler retries its requests, which exposes the retry attempt to placeholders.
+func (h Handler) hasRetries() bool {
+	return h.isHTTP() || h.Type == HandlerTypeGRPC
+}
+
+// writesS3Objects reports whether the handler writes S3 objects, which exposes the batch ID to placeholders.
+func (h Handler) writesS3Objects() bool {
+	return h.Type == HandlerTypeS3 && h.S3Handler.Mode == S3ModePut
+}
+
+func (h Handler) placeholders(path string) []placeholderRef {
+	switch h.Type {
+	case HandlerTypeHTTP, "":
+		return h.HTTPHandler.placeholders(path + ".http")
+	case HandlerTypeFilter:
+		return findPlaceholders(path+".filter.expression", h.FilterHandler.Expression)
+	case HandlerTypeGRPC:
+		return h.GRPCHandler.placeholders(path + ".grpc")
+	case HandlerTypeKafka:
+		return h.KafkaHandler.placeholders(path + ".kafka")
+	case HandlerTypeAMQP:
+		return h.AMQPHandler.placeholders(path + ".amqp")
+	case HandlerTypeSQL:
+		return h.SQLHandler.placeholders(path + ".sql")
+	case HandlerTypeRedis:
+		return h.RedisHandler.placeholders(path + ".redis")
+	case HandlerTypeS3:
+		return h.S3Handler.placeholders(path + ".s3")
+	case HandlerTypeEmail:
+		return h.EmailHandler.placeholders(path + ".email")
+	case HandlerTypeGraphQL:
+		return h.GraphQLHandler.placeholders(path + ".graphql")
+	default:
+		return nil
+	}
+}
+
+func (h HTTPHandler) placeholders(path string) []placeholderRef {
+	refs := findPlaceholders(path+".url", h.URL)
+	refs = append(refs, findPlaceholders(path+".body", h.Body)...)
+	refs = append(refs, mapPlaceholders(path+".headers", h.Headers)...)
+	refs = append(refs, mapPlaceholders(path+".query_params", h.QueryParams)...)
+	return refs
+}
+
+func findPlaceholders(path, s string) []placeholderRef {
+	keys := Placeholders(s)
+	refs := make([]placeholderRef, 0, len(keys))
+	for _, key := range keys {
+		refs = append(refs, placeholderRef{path: path, key: key})
+	}
+	return refs
+}
+
+func mapPlaceholders(path string, m map[string]string) []placeholderRef {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var refs []placeholderRef
+	for _, key := range keys {
+		refs = append(refs, findPlaceholders(path+"."+key, key)...)
+		refs = append(refs, findPlaceholders(path+"."+key, m[key])...)
+	}
+	return refs
+}
+
+func listPlaceholders(path string, values []string) []placeholderRef {
+	var refs []placeholderRef
+	for i, value := range values {
+		refs = append(refs, findPlaceholders(fmt.Sprintf("%s[%d]", path, i), value)...)
+	}
+	return refs
+}
+
+// outputFields returns the declared output schema along with the fields captured from the HTTP response.
+func (h Handler) outputFields() []string {
+	if len(h.OutputSchema) == 0 {
+		return nil
+	}
+	fields := append([]string{}, h.OutputSchema...)
+	if h.isHTTP() {
+		for field := range h.HTTPHandler.Response.Headers {
+			fields = append(fields, field)
+		}
+		if h.HTTPHandler.Response.StatusCodeField != "" {
+			fields = append(fields, h.HTTPHandler.Response.StatusCodeField)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type RedisCommand string
+
+const (
+	// RedisCommandGet looks up the value of a key.
+	RedisCommandGet RedisCommand = "get"
+	// RedisCommandHGetAll looks up the fields of a hash.
+	RedisCommandHGetAll RedisCommand = "hgetall"
+	// RedisCommandSet sets the value of a key per record, optionally with a TTL.
+	RedisCommandSet RedisCommand = "set"
+	// RedisCommandXRead consumes the entries of a stream, one result per entry.
+	RedisCommandXRead RedisCommand = "xread"
+	// RedisCommandXAdd adds an entry per record to a stream.
+	RedisCommandXAdd RedisCommand = "xadd"
+)
+
+// RedisStreamStartLatest starts consuming a stream with the entries added after the first run.
+const RedisStreamStartLatest = "$"
+
+// RedisHandler runs a Redis command per record.
+type RedisHandler struct {
+	Addr     string  `yaml:"addr"`
+	Username string  `yaml:"username"`
+	Password *Secret `yaml:"password"`
+	DB       int     `yaml:"db"`
+	TLS      *TLS    `yaml:"tls"`
+	// PoolSize is the maximum number of connections, 10 per CPU by default.
+	PoolSize     int `yaml:"pool_size"`
+	MinIdleConns int `yaml:"min_idle_conns"`
+
+	Command RedisCommand `yaml:"command"`
+	// Key of the command, the stream name for xread and xadd. Placeholders are supported, except for xread.
+	Key string `yaml:"key"`
+	// Value to set. Placeholders are supported.
+	Value string `yaml:"value"`
+	// TTL of the key set, no expiration by default.
+	TTL time.Duration `yaml:"ttl"`
+	// Fields of the stream entry to add. Placeholders are supported.
+	Fields map[string]string `yaml:"fields"`
+	// MaxLen approximately trims the stream to this length when adding entries, not trimmed by default.
+	MaxLen int64 `yaml:"max_len"`
+
+	// Group and Consumer read the stream with a consumer group, entries are acknowledged after the chain succeeded.
+	Group    string `yaml:"group"`
+	Consumer string `yaml:"consumer"`
+	// StartID is where the stream is consumed from, '$' (new entries) by default, or '0' for all entries.
+	StartID string `yaml:"start_id"`
+	// MaxMessages is the number of entries consumed per run, 100 by default.
+	MaxMessages int `yaml:"max_messages"`
+	// WaitTime ends a run when no entry arrives for this long, 10s by default.
+	WaitTime time.Duration `yaml:"wait_time"`
+
+	// Timeout of a command, 5s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h RedisHandler) Validate() error {
+	if h.Addr == "" {
+		return fmt.Errorf("'addr' is required")
+	}
+	if h.Password != nil {
+		if err := h.Password.Validate(); err != nil {
+			return fmt.Errorf("invalid 'password' config: %s", err)
+		}
+	}
+	if h.TLS != nil {
+		if err := h.TLS.Validate(); err != nil {
+			return fmt.Errorf("invalid 'tls' config: %s", err)
+		}
+	}
+	if h.Key == "" {
+		return fmt.Errorf("'key' is required")
+	}
+	if h.DB < 0 || h.PoolSize < 0 || h.MinIdleConns < 0 || h.TTL < 0 || h.MaxLen < 0 ||
+		h.MaxMessages < 0 || h.WaitTime < 0 || h.Timeout < 0 {
+		return fmt.Errorf("numeric options and durations must not be negative")
+	}
+
+	switch h.Command {
+	case RedisCommandGet, RedisCommandHGetAll:
+	case RedisCommandSet:
+		if h.Value == "" {
+			return fmt.Errorf("'value' is required for set")
+		}
+	case RedisCommandXAdd:
+		if len(h.Fields) == 0 {
+			return fmt.Errorf("'fields' is required for xadd")
+		}
+	case RedisCommandXRead:
+		if len(Placeholders(h.Key)) > 0 {
+			return fmt.Errorf("placeholders in 'key' are not supported for xread")
+		}
+		if (h.Group == "") != (h.Consumer == "") {
+			return fmt.Errorf("'group' and 'consumer' must be set together")
+		}
+	default:
+		return fmt.Errorf("invalid 'command' value: %s", h.Command)
+	}
+	return nil
+}
+
+func (h RedisHandler) placeholders(path string) []placeholderRef {
+	refs := findPlaceholders(path+".key", h.Key)
+	switch h.Command {
+	case RedisCommandSet:
+		refs = append(refs, findPlaceholders(path+".value", h.Value)...)
+	case RedisCommandXAdd:
+		refs = append(refs, mapPlaceholders(path+".fields", h.Fields)...)
+	}
+	return refs
+}
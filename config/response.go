@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResultsPathRoot is the results path of a response body that is the results itself.
+const ResultsPathRoot = "$"
+
+type ResponseFormat string
+
+const (
+	ResponseFormatJSON   ResponseFormat = "json"
+	ResponseFormatNDJSON ResponseFormat = "ndjson"
+	ResponseFormatCSV    ResponseFormat = "csv"
+	ResponseFormatXML    ResponseFormat = "xml"
+	ResponseFormatText   ResponseFormat = "text"
+)
+
+type EmptyBodyAction string
+
+const (
+	// EmptyBodyActionError fails the request.
+	EmptyBodyActionError EmptyBodyAction = "error"
+	// EmptyBodyActionPassThrough returns a single result, so the chain continues with the data it already has.
+	// It's the default, e.g. for '204 No Content' responses.
+	EmptyBodyActionPassThrough EmptyBodyAction = "pass_through"
+	// EmptyBodyActionDrop returns no results, so the chain stops for the record.
+	EmptyBodyActionDrop EmptyBodyAction = "drop"
+)
+
+// Response defines how the results of a handler are mapped from an HTTP response.
+type Response struct {
+	// Format of the response body. If not set, it is chosen by the Content-Type header, JSON by default.
+	Format ResponseFormat `yaml:"format"`
+	CSV    CSVFormat      `yaml:"csv"`
+	XML    XMLFormat      `yaml:"xml"`
+	Text   TextFormat     `yaml:"text"`
+
+	// ResultsPath is the dot-separated path to the results array in the response body, e.g. 'data.items'.
+	// Array elements are selected by their index, e.g. 'pages.0.items'. '$' is the root. Default is 'results'.
+	ResultsPath string `yaml:"results_path"`
+	// SingleObject treats an object found at ResultsPath as a single result.
+	SingleObject bool `yaml:"single_object"`
+	// Headers maps result fields to the response headers captured into them.
+	Headers map[string]string `yaml:"headers"`
+	// StatusCodeField is the result field the response status code is captured into.
+	StatusCodeField string          `yaml:"status_code_field"`
+	EmptyBody       EmptyBodyAction `yaml:"empty_body"`
+}
+
+func (r Response) Validate() error {
+	switch r.Format {
+	case "", ResponseFormatJSON, ResponseFormatNDJSON, ResponseFormatCSV, ResponseFormatXML, ResponseFormatText:
+	default:
+		return fmt.Errorf("invalid 'format' value: %s", r.Format)
+	}
+	if err := r.CSV.Validate(); err != nil {
+		return fmt.Errorf("invalid 'csv' config: %s", err)
+	}
+	if err := r.XML.Validate(); err != nil {
+		return fmt.Errorf("invalid 'xml' config: %s", err)
+	}
+
+	if r.ResultsPath != "" && r.ResultsPath != ResultsPathRoot {
+		for _, key := range r.ResultsPathKeys() {
+			if key == "" {
+				return fmt.Errorf("invalid 'results_path': %s", r.ResultsPath)
+			}
+		}
+	}
+
+	for field, header := range r.Headers {
+		if field == "" || header == "" {
+			return fmt.Errorf("'headers' must map non-empty fields to non-empty header names")
+		}
+		if field == r.StatusCodeField {
+			return fmt.Errorf("'%s' field is used for both a header and the status code", field)
+		}
+	}
+
+	switch r.EmptyBody {
+	case "", EmptyBodyActionError, EmptyBodyActionPassThrough, EmptyBodyActionDrop:
+	default:
+		return fmt.Errorf("invalid 'empty_body' value: %s", r.EmptyBody)
+	}
+
+	return nil
+}
+
+// ResultsPathKeys returns the keys of the results path, or nil for the root.
+func (r Response) ResultsPathKeys() []string {
+	path := r.ResultsPath
+	if path == "" {
+		path = "results"
+	}
+	return JSONPathKeys(path)
+}
+
+// JSONPathKeys returns the keys of a dot-separated JSON path, or nil for the root ('$').
+func JSONPathKeys(path string) []string {
+	if path == ResultsPathRoot {
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(path, ResultsPathRoot+"."), ".")
+}
+
+// CSVFormat defines how CSV rows are mapped into results, one result per row.
+type CSVFormat struct {
+	// Delimiter of the fields, ',' by default.
+	Delimiter string `yaml:"delimiter"`
+	// Header lists the column names if the body has no header row.
+	Header []string `yaml:"header"`
+	// Columns maps column names to result fields. Columns that are not mapped keep their names.
+	Columns map[string]string `yaml:"columns"`
+}
+
+func (c CSVFormat) Validate() error {
+	if c.Delimiter != "" && len([]rune(c.Delimiter)) != 1 {
+		return fmt.Errorf("'delimiter' must be a single character")
+	}
+	for _, column := range c.Header {
+		if column == "" {
+			return fmt.Errorf("'header' must not contain empty column names")
+		}
+	}
+	for column, field := range c.Columns {
+		if column == "" || field == "" {
+			return fmt.Errorf("'columns' must map non-empty columns to non-empty fields")
+		}
+	}
+	return nil
+}
+
+// XMLFormat defines how XML elements are mapped into results.
+type XMLFormat struct {
+	// ElementPath is the slash-separated path of the elements that are the results, starting with the root
+	// element, e.g. 'response/items/item'.
+	ElementPath string `yaml:"element_path"`
+}
+
+func (x XMLFormat) Validate() error {
+	if x.ElementPath == "" {
+		return nil
+	}
+	for _, name := range x.ElementPathNames() {
+		if name == "" {
+			return fmt.Errorf("invalid 'element_path': %s", x.ElementPath)
+		}
+	}
+	return nil
+}
+
+// ElementPathNames returns the element names of the element path.
+func (x XMLFormat) ElementPathNames() []string {
+	return strings.Split(strings.Trim(x.ElementPath, "/"), "/")
+}
+
+// TextFormat defines how a plain text body is mapped into a single result.
+type TextFormat struct {
+	// Field the body is stored into, 'text' by default.
+	Field string `yaml:"field"`
+}
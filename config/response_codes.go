@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+type ResponseCodeOutcome string
+
+const (
+	// ResponseCodeOutcomeSkipRecord returns no results, so the chain stops for the record without an error.
+	ResponseCodeOutcomeSkipRecord ResponseCodeOutcome = "skip_record"
+	// ResponseCodeOutcomeTreatAsSuccess returns a single result, so the chain continues with the data it already has.
+	ResponseCodeOutcomeTreatAsSuccess ResponseCodeOutcome = "treat_as_success"
+	// ResponseCodeOutcomeRetry retries the request, even if the code is not in the retryable status codes.
+	ResponseCodeOutcomeRetry ResponseCodeOutcome = "retry"
+)
+
+// ExpectedCodes returns the response codes of a successful request, 200 by default.
+func (h HTTPHandler) ExpectedCodes() []int {
+	if len(h.ExpectedResponseCodes) > 0 {
+		return h.ExpectedResponseCodes
+	}
+	if h.ExpectedResponseCode != 0 {
+		return []int{h.ExpectedResponseCode}
+	}
+	return []int{200}
+}
+
+func (h HTTPHandler) validateResponseCodes() error {
+	if h.ExpectedResponseCode != 0 && len(h.ExpectedResponseCodes) > 0 {
+		return fmt.Errorf("'expected_response_code' and 'expected_response_codes' are mutually exclusive")
+	}
+	for _, code := range h.ExpectedCodes() {
+		if !isValidResponseCode(code) {
+			return fmt.Errorf("invalid expected response code: %d", code)
+		}
+	}
+
+	codes := make([]int, 0, len(h.ResponseCodeOutcomes))
+	for code := range h.ResponseCodeOutcomes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		if !isValidResponseCode(code) {
+			return fmt.Errorf("invalid response code in 'response_code_outcomes': %d", code)
+		}
+		for _, expected := range h.ExpectedCodes() {
+			if code == expected {
+				return fmt.Errorf("response code %d is both expected and mapped to an outcome", code)
+			}
+		}
+		switch outcome := h.ResponseCodeOutcomes[code]; outcome {
+		case ResponseCodeOutcomeSkipRecord, ResponseCodeOutcomeTreatAsSuccess, ResponseCodeOutcomeRetry:
+		default:
+			return fmt.Errorf("invalid outcome for response code %d: %s", code, outcome)
+		}
+	}
+	return nil
+}
+
+func isValidResponseCode(code int) bool {
+	return code >= 100 && code <= 599
+}
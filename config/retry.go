@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetryAttemptPlaceholder is the placeholder key of the current attempt number, starting from 1.
+// It is available in HTTP handlers, e.g. to build idempotency keys.
+const RetryAttemptPlaceholder = PipePlaceholderNamespace + ".retry_attempt"
+
+type BackoffType string
+
+const (
+	BackoffConstant    BackoffType = "constant"
+	BackoffExponential BackoffType = "exponential"
+)
+
+type RetryableError string
+
+const (
+	RetryableErrorTimeout           RetryableError = "timeout"
+	RetryableErrorConnectionReset   RetryableError = "connection_reset"
+	RetryableErrorConnectionRefused RetryableError = "connection_refused"
+	RetryableErrorDNS               RetryableError = "dns"
+	RetryableErrorInvalidResponse   RetryableError = "invalid_response"
+)
+
+// RetryPolicy tunes how HTTP handlers retry failed requests. The number of retries and the initial interval
+// are set by 'retries' and 'retry_interval'. Without retryable status codes, 408, 429 and 5xx responses are retried,
+// and an empty list of retryable errors retries all of them.
+type RetryPolicy struct {
+	Backoff              BackoffType      `yaml:"backoff"`
+	Multiplier           float64          `yaml:"multiplier"`
+	MaxInterval          time.Duration    `yaml:"max_interval"`
+	Jitter               float64          `yaml:"jitter"`
+	RetryableStatusCodes []int            `yaml:"retryable_status_codes"`
+	RetryableErrors      []RetryableError `yaml:"retryable_errors"`
+	IgnoreRetryAfter     bool             `yaml:"ignore_retry_after"`
+	MaxElapsedTime       time.Duration    `yaml:"max_elapsed_time"`
+}
+
+func (p RetryPolicy) Validate() error {
+	switch p.Backoff {
+	case "", BackoffConstant, BackoffExponential:
+	default:
+		return fmt.Errorf("invalid 'backoff' value: %s", p.Backoff)
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return fmt.Errorf("'multiplier' must be at least 1")
+	}
+	if p.MaxInterval < 0 || p.MaxElapsedTime < 0 {
+		return fmt.Errorf("'max_interval' and 'max_elapsed_time' must not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("'jitter' must be between 0 and 1")
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retryable status code: %d", code)
+		}
+	}
+	for _, e := range p.RetryableErrors {
+		switch e {
+		case RetryableErrorTimeout, RetryableErrorConnectionReset, RetryableErrorConnectionRefused,
+			RetryableErrorDNS, RetryableErrorInvalidResponse:
+		default:
+			return fmt.Errorf("invalid retryable error: %s", e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type S3Mode string
+
+const (
+	// S3ModeList reads the objects under a prefix that were added since the previous run, a result per record.
+	S3ModeList S3Mode = "list"
+	// S3ModePut writes an object per record, or per batch of records.
+	S3ModePut S3Mode = "put"
+)
+
+type S3Format string
+
+const (
+	// S3FormatJSONL parses an object as a JSON object per line.
+	S3FormatJSONL S3Format = "jsonl"
+	// S3FormatCSV parses an object as CSV rows, the first row is the header unless 'csv.header' is set.
+	S3FormatCSV S3Format = "csv"
+)
+
+// S3BatchIDPlaceholder is the placeholder key of a unique ID of the object written by an S3 handler in put mode,
+// e.g. to avoid overwriting the objects of previous batches.
+const S3BatchIDPlaceholder = PipePlaceholderNamespace + ".batch_id"
+
+// S3Handler lists and reads objects of an S3 bucket, or writes records to it. Requests are signed with
+// AWS Signature Version 4, so any S3 compatible storage like MinIO works.
+type S3Handler struct {
+	// Endpoint of the storage, https://s3.<region>.amazonaws.com by default.
+	Endpoint string `yaml:"endpoint"`
+	// Region used to sign requests, us-east-1 by default.
+	Region string `yaml:"region"`
+	Bucket string `yaml:"bucket"`
+	// PathStyle puts the bucket in the path instead of the host name, required by MinIO.
+	PathStyle       bool    `yaml:"path_style"`
+	AccessKeyID     Secret  `yaml:"access_key_id"`
+	SecretAccessKey Secret  `yaml:"secret_access_key"`
+	SessionToken    *Secret `yaml:"session_token"`
+	TLS             *TLS    `yaml:"tls"`
+
+	Mode S3Mode `yaml:"mode"`
+
+	// Prefix of the listed objects.
+	Prefix string `yaml:"prefix"`
+	// StateFile persists the key of the last object that was processed, so objects are not read again
+	// after a restart. The marker is only kept in memory if it's not set.
+	StateFile string `yaml:"state_file"`
+	// StartAfter is the key the objects are listed after when no marker was saved yet. Keys are compared
+	// lexicographically, so objects with keys that sort before the marker are never listed.
+	StartAfter string `yaml:"start_after"`
+	// MaxObjects is the number of objects read per run, 100 by default.
+	MaxObjects int       `yaml:"max_objects"`
+	Format     S3Format  `yaml:"format"`
+	CSV        CSVFormat `yaml:"csv"`
+
+	// Key of the written object. Placeholders are supported.
+	Key string `yaml:"key"`
+	// Body written per record, records of a batch are written as a line each. Placeholders are supported.
+	Body string `yaml:"body"`
+	// ContentType of the written objects, application/json by default, or application/x-ndjson for batches.
+	ContentType string `yaml:"content_type"`
+	// BatchSize is the number of records written to a single object, 1 by default.
+	BatchSize int `yaml:"batch_size"`
+	// BatchWait is how long a batch waits for more records before it's written, 1s by default.
+	BatchWait time.Duration `yaml:"batch_wait"`
+
+	// Timeout of a request, 30s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h S3Handler) Validate() error {
+	if h.Bucket == "" {
+		return fmt.Errorf("'bucket' is required")
+	}
+	if err := h.AccessKeyID.Validate(); err != nil {
+		return fmt.Errorf("invalid 'access_key_id' config: %s", err)
+	}
+	if err := h.SecretAccessKey.Validate(); err != nil {
+		return fmt.Errorf("invalid 'secret_access_key' config: %s", err)
+	}
+	if h.SessionToken != nil {
+		if err := h.SessionToken.Validate(); err != nil {
+			return fmt.Errorf("invalid 'session_token' config: %s", err)
+		}
+	}
+	if h.TLS != nil {
+		if err := h.TLS.Validate(); err != nil {
+			return fmt.Errorf("invalid 'tls' config: %s", err)
+		}
+	}
+	if h.MaxObjects < 0 || h.BatchSize < 0 || h.BatchWait < 0 || h.Timeout < 0 {
+		return fmt.Errorf("'max_objects', 'batch_size', 'batch_wait' and 'timeout' must not be negative")
+	}
+
+	switch h.Mode {
+	case S3ModeList:
+		if len(Placeholders(h.Prefix)) > 0 {
+			return fmt.Errorf("placeholders in 'prefix' are not supported")
+		}
+		switch h.Format {
+		case S3FormatJSONL, S3FormatCSV:
+		default:
+			return fmt.Errorf("invalid 'format' value: %s", h.Format)
+		}
+	case S3ModePut:
+		if h.Key == "" {
+			return fmt.Errorf("'key' is required in put mode")
+		}
+		if h.Body == "" {
+			return fmt.Errorf("'body' is required in put mode")
+		}
+	default:
+		return fmt.Errorf("invalid 'mode' value: %s", h.Mode)
+	}
+	return nil
+}
+
+func (h S3Handler) placeholders(path string) []placeholderRef {
+	if h.Mode != S3ModePut {
+		return nil
+	}
+	refs := findPlaceholders(path+".key", h.Key)
+	refs = append(refs, findPlaceholders(path+".body", h.Body)...)
+	return refs
+}
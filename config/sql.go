@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+type SQLDriver string
+
+const (
+	SQLDriverPostgres SQLDriver = "postgres"
+	SQLDriverMySQL    SQLDriver = "mysql"
+	SQLDriverSQLite   SQLDriver = "sqlite"
+)
+
+type SQLMode string
+
+const (
+	// SQLModeQuery runs a query and emits a result per row, the default.
+	// Queries are run in dry-run mode too, so statements that write must use exec mode.
+	SQLModeQuery SQLMode = "query"
+	// SQLModeExec executes a statement per record, e.g. an insert or an upsert.
+	SQLModeExec SQLMode = "exec"
+)
+
+// SQLHandler runs a query or a statement on a SQL database. Placeholders in the query are bound as query arguments.
+type SQLHandler struct {
+	Driver SQLDriver `yaml:"driver"`
+	DSN    Secret    `yaml:"dsn"`
+	Mode   SQLMode   `yaml:"mode"`
+	Query  string    `yaml:"query"`
+	// BatchSize is the number of records whose statements are executed in a single transaction in exec mode,
+	// 1 by default.
+	BatchSize int `yaml:"batch_size"`
+	// BatchWait is how long a batch waits for more records before it's executed, 100ms by default.
+	BatchWait time.Duration `yaml:"batch_wait"`
+	// Timeout of a query or a batch, 15s by default.
+	Timeout      time.Duration `yaml:"timeout"`
+	MaxOpenConns int           `yaml:"max_open_conns"`
+	MaxIdleConns int           `yaml:"max_idle_conns"`
+}
+
+func (h SQLHandler) Validate() error {
+	switch h.Driver {
+	case SQLDriverPostgres, SQLDriverMySQL, SQLDriverSQLite:
+	default:
+		return fmt.Errorf("invalid 'driver' value: %s", h.Driver)
+	}
+	if err := h.DSN.Validate(); err != nil {
+		return fmt.Errorf("invalid 'dsn' config: %s", err)
+	}
+	switch h.Mode {
+	case "", SQLModeQuery, SQLModeExec:
+	default:
+		return fmt.Errorf("invalid 'mode' value: %s", h.Mode)
+	}
+	if h.Query == "" {
+		return fmt.Errorf("'query' is required")
+	}
+	if key, ok := h.quotedPlaceholder(); ok {
+		return fmt.Errorf("placeholder '%s' must not be quoted in 'query', placeholders are bound as query arguments", key)
+	}
+	if h.BatchSize < 0 || h.BatchWait < 0 || h.Timeout < 0 || h.MaxOpenConns < 0 || h.MaxIdleConns < 0 {
+		return fmt.Errorf("'batch_size', 'batch_wait', 'timeout', 'max_open_conns' and 'max_idle_conns' must not be negative")
+	}
+	if h.BatchSize > 1 && h.Mode != SQLModeExec {
+		return fmt.Errorf("'batch_size' is only supported in exec mode")
+	}
+	return nil
+}
+
+func (h SQLHandler) placeholders(path string) []placeholderRef {
+	return findPlaceholders(path+".query", h.Query)
+}
+
+// quotedPlaceholder returns the key of the first placeholder inside a quoted string or identifier of the query.
+// Bind variables are not replaced inside quotes, so such a placeholder would never get its value.
+func (h SQLHandler) quotedPlaceholder() (string, bool) {
+	re := regexp.MustCompile(placeholderPattern)
+	var quote byte
+	pos := 0
+	for _, m := range re.FindAllStringSubmatchIndex(h.Query, -1) {
+		for ; pos < m[0]; pos++ {
+			c := h.Query[pos]
+			switch {
+			case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+				quote = c
+			case quote != 0 && c == '\\' && h.Driver == SQLDriverMySQL:
+				// MySQL escapes quotes with a backslash, doubled quotes work for all drivers
+				pos++
+			case c == quote:
+				quote = 0
+			}
+		}
+		if quote != 0 {
+			return h.Query[m[2]:m[3]], true
+		}
+		pos = m[1]
+	}
+	return "", false
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TLS configures the TLS client of an HTTP handler.
+type TLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	MinVersion         string `yaml:"min_version"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (t TLS) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("'cert_file' and 'key_file' must be set together")
+	}
+	switch t.MinVersion {
+	case "", "1.0", "1.1", "1.2", "1.3":
+	default:
+		return fmt.Errorf("invalid 'min_version' value: %s", t.MinVersion)
+	}
+	return nil
+}
+
+// Proxy configures the proxy of an HTTP handler. By default, the proxy is taken from
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY env variables.
+type Proxy struct {
+	URL      string `yaml:"url"`
+	Disabled bool   `yaml:"disabled"`
+}
+
+func (p Proxy) Validate() error {
+	if p.URL == "" {
+		return nil
+	}
+	if p.Disabled {
+		return fmt.Errorf("'url' and 'disabled' are mutually exclusive")
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("invalid 'url': %s", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid 'url': scheme and host are required")
+	}
+	return nil
+}
+
+// Transport tunes the connections of an HTTP handler.
+// Handlers with the same TLS, proxy and transport configs share their connections.
+type Transport struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	KeepAlive           time.Duration `yaml:"keep_alive"`
+	DisableKeepAlives   bool          `yaml:"disable_keep_alives"`
+	DisableHTTP2        bool          `yaml:"disable_http2"`
+}
+
+func (t Transport) Validate() error {
+	if t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 {
+		return fmt.Errorf("connection limits must not be negative")
+	}
+	if t.IdleConnTimeout < 0 || t.KeepAlive < 0 {
+		return fmt.Errorf("'idle_conn_timeout' and 'keep_alive' must not be negative")
+	}
+	return nil
+}
@@ -59,6 +59,150 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			errContains: "invalid engine config",
 		},
+		{
+			name: "NegativeShutdownTimeout",
+			cfg: &Config{
+				Engine: Engine{
+					Interval:        time.Minute,
+					ShutdownTimeout: -time.Second,
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "POST",
+								URL:    "http://example.com",
+							},
+						},
+					},
+				},
+			},
+			errContains: "'shutdown_timeout' must not be negative",
+		},
+		{
+			name: "StreamMode",
+			cfg: &Config{
+				Engine: Engine{
+					Mode:        EngineModeStream,
+					MaxInFlight: 10,
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "orders",
+						Handler: Handler{
+							Type: HandlerTypeAMQP,
+							AMQPHandler: AMQPHandler{
+								Mode:  AMQPModeConsume,
+								URL:   Secret{Value: "amqp://localhost:5672"},
+								Queue: "orders",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "StreamModeWithoutSource",
+			cfg: &Config{
+				Engine: Engine{
+					Mode: EngineModeStream,
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "POST",
+								URL:    "http://example.com",
+							},
+						},
+					},
+				},
+			},
+			errContains: "stream mode requires a source handler that consumes messages, got 'handler1' handler",
+		},
+		{
+			name: "WebhookIsNotFirst",
+			cfg: &Config{
+				Engine: Engine{
+					Interval: time.Minute,
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "POST",
+								URL:    "http://example.com",
+							},
+						},
+					},
+					{
+						Name: "hook",
+						Handler: Handler{
+							Type:           HandlerTypeWebhook,
+							WebhookHandler: WebhookHandler{Listen: ":8090", Path: "/hooks"},
+						},
+					},
+				},
+			},
+			errContains: "'hook' webhook handler must be the first handler of the chain",
+		},
+		{
+			name: "S3ListIsNotFirst",
+			cfg: &Config{
+				Engine: Engine{
+					Interval: time.Minute,
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "POST",
+								URL:    "http://example.com",
+							},
+						},
+					},
+					{
+						Name: "files",
+						Handler: Handler{
+							Type: HandlerTypeS3,
+							S3Handler: S3Handler{
+								Bucket:          "exports",
+								AccessKeyID:     Secret{Value: "minio"},
+								SecretAccessKey: Secret{Value: "minio-secret"},
+								Mode:            S3ModeList,
+								Format:          S3FormatJSONL,
+							},
+						},
+					},
+				},
+			},
+			errContains: "'files' s3 handler in list mode must be the first handler of the chain",
+		},
+		{
+			name: "NegativeMaxInFlight",
+			cfg: &Config{
+				Engine: Engine{
+					Interval:    time.Minute,
+					MaxInFlight: -1,
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "POST",
+								URL:    "http://example.com",
+							},
+						},
+					},
+				},
+			},
+			errContains: "'max_in_flight' and 'restart_delay' must not be negative",
+		},
 		{
 			name: "InvalidHandler",
 			cfg: &Config{
@@ -107,6 +251,53 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			errContains: "duplicate handler name: 'handler1'",
 		},
+		{
+			name: "InvalidLimiterGroup",
+			cfg: &Config{
+				Engine: Engine{
+					Interval: time.Minute,
+					LimiterGroups: map[string]LimiterGroup{
+						"partner-api": {RateLimit: "50/d"},
+					},
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "POST",
+								URL:    "http://example.com",
+							},
+						},
+					},
+				},
+			},
+			errContains: "invalid 'partner-api' limiter group: invalid 'rate_limit'",
+		},
+		{
+			name: "UnknownLimiterGroup",
+			cfg: &Config{
+				Engine: Engine{
+					Interval: time.Minute,
+					LimiterGroups: map[string]LimiterGroup{
+						"partner-api": {MaxConcurrency: 4},
+					},
+				},
+				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "POST",
+								URL:    "http://example.com",
+							},
+							Limits: Limits{Group: "partner"},
+						},
+					},
+				},
+			},
+			errContains: "'handler1' handler uses unknown limiter group: 'partner'",
+		},
 		{
 			name: "EmptyFilterExpression",
 			cfg: &Config{
@@ -154,12 +345,21 @@ func TestConfig_Validate(t *testing.T) {
 					Interval: time.Minute,
 				},
 				Handlers: &HandlerMap{
+					{
+						Name: "handler1",
+						Handler: Handler{
+							HTTPHandler: HTTPHandler{
+								Method: "GET",
+								URL:    "http://example.com",
+							},
+						},
+					},
 					{
 						Name: "filter1",
 						Handler: Handler{
 							Type: HandlerTypeFilter,
 							FilterHandler: FilterHandler{
-								Expression: `{{ key }} == "value"`,
+								Expression: `{{ handler1.key }} == "value"`,
 							},
 						},
 					},
@@ -209,22 +409,1432 @@ func TestHandler_Validate(t *testing.T) {
 			},
 			errContains: "'method' is required",
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := tt.handler.Validate()
-			if tt.errContains != "" {
-				assert.Error(t, err)
-				assert.True(t, strings.Contains(err.Error(), tt.errContains))
-				return
-			}
-			assert.NoError(t, err)
-		})
-	}
-}
-
-func TestConfig_ParseFromYamlFile(t *testing.T) {
-	cfg := NewConfig()
-	err := cfg.ParseFromYamlFile("../config.example.yaml")
-	assert.NoError(t, err)
+		{
+			name: "ValidAuth",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Auth: Auth{
+						Type: AuthTypeOAuth2,
+						OAuth2: OAuth2Auth{
+							TokenURL:     "http://example.com/token",
+							ClientID:     "client",
+							ClientSecret: Secret{Env: "CLIENT_SECRET"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "InvalidAuthType",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Auth:   Auth{Type: "digest"},
+				},
+			},
+			errContains: "invalid 'auth' config: invalid 'type' value: digest",
+		},
+		{
+			name: "AuthSecretWithoutSource",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Auth:   Auth{Type: AuthTypeBearer},
+				},
+			},
+			errContains: "invalid 'bearer.token': exactly one of 'value', 'env' or 'file' must be set",
+		},
+		{
+			name: "AuthSecretWithSeveralSources",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Auth: Auth{
+						Type: AuthTypeBasic,
+						Basic: BasicAuth{
+							Username: "user",
+							Password: Secret{Value: "password", File: "/run/secrets/password"},
+						},
+					},
+				},
+			},
+			errContains: "invalid 'basic.password': exactly one of 'value', 'env' or 'file' must be set",
+		},
+		{
+			name: "InvalidHMACAlgorithm",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Auth: Auth{
+						Type: AuthTypeHMAC,
+						HMAC: HMACAuth{Secret: Secret{Value: "secret"}, Algorithm: "md5"},
+					},
+				},
+			},
+			errContains: "invalid 'hmac.algorithm' value: md5",
+		},
+		{
+			name: "CertFileWithoutKeyFile",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					TLS:    TLS{CertFile: "client.pem"},
+				},
+			},
+			errContains: "invalid 'tls' config: 'cert_file' and 'key_file' must be set together",
+		},
+		{
+			name: "InvalidTLSMinVersion",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					TLS:    TLS{MinVersion: "1.4"},
+				},
+			},
+			errContains: "invalid 'tls' config: invalid 'min_version' value: 1.4",
+		},
+		{
+			name: "InvalidProxyURL",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Proxy:  Proxy{URL: "proxy:3128"},
+				},
+			},
+			errContains: "invalid 'proxy' config: invalid 'url'",
+		},
+		{
+			name: "NegativeMaxIdleConns",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:    "POST",
+					URL:       "http://example.com",
+					Transport: Transport{MaxIdleConns: -1},
+				},
+			},
+			errContains: "invalid 'transport' config: connection limits must not be negative",
+		},
+		{
+			name: "InvalidBackoff",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:      "POST",
+					URL:         "http://example.com",
+					RetryPolicy: RetryPolicy{Backoff: "linear"},
+				},
+			},
+			errContains: "invalid 'retry_policy' config: invalid 'backoff' value: linear",
+		},
+		{
+			name: "InvalidJitter",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:      "POST",
+					URL:         "http://example.com",
+					RetryPolicy: RetryPolicy{Jitter: 1.5},
+				},
+			},
+			errContains: "'jitter' must be between 0 and 1",
+		},
+		{
+			name: "InvalidRetryableError",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:      "POST",
+					URL:         "http://example.com",
+					RetryPolicy: RetryPolicy{RetryableErrors: []RetryableError{"broken_pipe"}},
+				},
+			},
+			errContains: "invalid retryable error: broken_pipe",
+		},
+		{
+			name: "InvalidCircuitBreakerFailureRatio",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:         "POST",
+					URL:            "http://example.com",
+					CircuitBreaker: CircuitBreaker{FailureRatio: 2},
+				},
+			},
+			errContains: "invalid 'circuit_breaker' config: 'failure_ratio' must be between 0 and 1",
+		},
+		{
+			name: "CircuitBreakerMinRequestsAboveWindowSize",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:         "POST",
+					URL:            "http://example.com",
+					CircuitBreaker: CircuitBreaker{FailureRatio: 0.5, WindowSize: 10, MinRequests: 20},
+				},
+			},
+			errContains: "'min_requests' must not be greater than 'window_size' (10)",
+		},
+		{
+			name: "CircuitBreakerMinRequestsAboveDefaultWindowSize",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:         "POST",
+					URL:            "http://example.com",
+					CircuitBreaker: CircuitBreaker{FailureRatio: 0.5, MinRequests: 21},
+				},
+			},
+			errContains: "'min_requests' must not be greater than 'window_size' (20)",
+		},
+		{
+			name: "ValidLimits",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+				},
+				Limits: Limits{LimiterGroup: LimiterGroup{MaxConcurrency: 4, RateLimit: "0.5/s", Burst: 2}},
+			},
+		},
+		{
+			name: "NegativeMaxConcurrency",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+				},
+				Limits: Limits{LimiterGroup: LimiterGroup{MaxConcurrency: -1}},
+			},
+			errContains: "invalid 'limits' config: 'max_concurrency' must not be negative",
+		},
+		{
+			name: "InvalidRateLimit",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+				},
+				Limits: Limits{LimiterGroup: LimiterGroup{RateLimit: "50"}},
+			},
+			errContains: "invalid 'limits' config: invalid 'rate_limit': invalid rate '50'",
+		},
+		{
+			name: "LimitsOnFilter",
+			handler: Handler{
+				Type:          HandlerTypeFilter,
+				FilterHandler: FilterHandler{Expression: `"a" == "a"`},
+				Limits:        Limits{Group: "partner"},
+			},
+			errContains: "'limits' is not supported by filter handlers",
+		},
+		{
+			name: "ValidResponse",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Response: Response{
+						ResultsPath:     "$.data.items",
+						Headers:         map[string]string{"request_id": "X-Request-Id"},
+						StatusCodeField: "status",
+						EmptyBody:       EmptyBodyActionPassThrough,
+					},
+				},
+			},
+		},
+		{
+			name: "InvalidResultsPath",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:   "POST",
+					URL:      "http://example.com",
+					Response: Response{ResultsPath: "data..items"},
+				},
+			},
+			errContains: "invalid 'response' config: invalid 'results_path': data..items",
+		},
+		{
+			name: "ResponseHeaderAndStatusCodeField",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					Response: Response{
+						Headers:         map[string]string{"status": "X-Status"},
+						StatusCodeField: "status",
+					},
+				},
+			},
+			errContains: "'status' field is used for both a header and the status code",
+		},
+		{
+			name: "InvalidResponseFormat",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:   "POST",
+					URL:      "http://example.com",
+					Response: Response{Format: "yaml"},
+				},
+			},
+			errContains: "invalid 'response' config: invalid 'format' value: yaml",
+		},
+		{
+			name: "InvalidCSVDelimiter",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:   "POST",
+					URL:      "http://example.com",
+					Response: Response{Format: ResponseFormatCSV, CSV: CSVFormat{Delimiter: "||"}},
+				},
+			},
+			errContains: "invalid 'csv' config: 'delimiter' must be a single character",
+		},
+		{
+			name: "InvalidXMLElementPath",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:   "POST",
+					URL:      "http://example.com",
+					Response: Response{Format: ResponseFormatXML, XML: XMLFormat{ElementPath: "response//item"}},
+				},
+			},
+			errContains: "invalid 'xml' config: invalid 'element_path': response//item",
+		},
+		{
+			name: "InvalidEmptyBody",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:   "POST",
+					URL:      "http://example.com",
+					Response: Response{EmptyBody: "ignore"},
+				},
+			},
+			errContains: "invalid 'empty_body' value: ignore",
+		},
+		{
+			name: "ValidPagination",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:     "GET",
+					URL:        "http://example.com",
+					Pagination: Pagination{Type: PaginationTypeCursor, CursorPath: "meta.next_cursor", MaxPages: 10},
+				},
+			},
+		},
+		{
+			name: "InvalidPaginationType",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:     "GET",
+					URL:        "http://example.com",
+					Pagination: Pagination{Type: "token"},
+				},
+			},
+			errContains: "invalid 'pagination' config: invalid 'type' value: token",
+		},
+		{
+			name: "CursorPaginationWithoutCursorPath",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:     "GET",
+					URL:        "http://example.com",
+					Pagination: Pagination{Type: PaginationTypeCursor},
+				},
+			},
+			errContains: "'cursor_path' is required for 'cursor' pagination",
+		},
+		{
+			name: "NegativeMaxPages",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:     "GET",
+					URL:        "http://example.com",
+					Pagination: Pagination{Type: PaginationTypePage, MaxPages: -1},
+				},
+			},
+			errContains: "'max_pages' must not be negative",
+		},
+		{
+			name: "ValidResponseCodes",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:                "POST",
+					URL:                   "http://example.com",
+					ExpectedResponseCodes: []int{200, 201, 204},
+					ResponseCodeOutcomes: map[int]ResponseCodeOutcome{
+						404: ResponseCodeOutcomeSkipRecord,
+						409: ResponseCodeOutcomeTreatAsSuccess,
+						429: ResponseCodeOutcomeRetry,
+					},
+				},
+			},
+		},
+		{
+			name: "ExpectedResponseCodeAndCodes",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:                "POST",
+					URL:                   "http://example.com",
+					ExpectedResponseCode:  200,
+					ExpectedResponseCodes: []int{201},
+				},
+			},
+			errContains: "'expected_response_code' and 'expected_response_codes' are mutually exclusive",
+		},
+		{
+			name: "InvalidExpectedResponseCode",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:                "POST",
+					URL:                   "http://example.com",
+					ExpectedResponseCodes: []int{200, 2001},
+				},
+			},
+			errContains: "invalid expected response code: 2001",
+		},
+		{
+			name: "ExpectedResponseCodeWithOutcome",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:               "POST",
+					URL:                  "http://example.com",
+					ResponseCodeOutcomes: map[int]ResponseCodeOutcome{200: ResponseCodeOutcomeRetry},
+				},
+			},
+			errContains: "response code 200 is both expected and mapped to an outcome",
+		},
+		{
+			name: "InvalidResponseCodeOutcome",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method:               "POST",
+					URL:                  "http://example.com",
+					ResponseCodeOutcomes: map[int]ResponseCodeOutcome{404: "ignore"},
+				},
+			},
+			errContains: "invalid outcome for response code 404: ignore",
+		},
+		{
+			name: "ValidGRPC",
+			handler: Handler{
+				Type: HandlerTypeGRPC,
+				GRPCHandler: GRPCHandler{
+					Target:         "localhost:50051",
+					Method:         "users.v1.UserService/ListUsers",
+					ResultsField:   "users",
+					Plaintext:      true,
+					RetryableCodes: []string{"UNAVAILABLE", "DEADLINE_EXCEEDED"},
+				},
+			},
+		},
+		{
+			name: "GRPCWithoutTarget",
+			handler: Handler{
+				Type:        HandlerTypeGRPC,
+				GRPCHandler: GRPCHandler{Method: "users.v1.UserService/ListUsers"},
+			},
+			errContains: "'target' is required",
+		},
+		{
+			name: "InvalidGRPCMethod",
+			handler: Handler{
+				Type:        HandlerTypeGRPC,
+				GRPCHandler: GRPCHandler{Target: "localhost:50051", Method: "ListUsers"},
+			},
+			errContains: "'method' must be in the format 'package.Service/Method'",
+		},
+		{
+			name: "InvalidGRPCRetryableCode",
+			handler: Handler{
+				Type: HandlerTypeGRPC,
+				GRPCHandler: GRPCHandler{
+					Target:         "localhost:50051",
+					Method:         "users.v1.UserService/ListUsers",
+					RetryableCodes: []string{"Unavailable"},
+				},
+			},
+			errContains: "invalid retryable code: Unavailable",
+		},
+		{
+			name: "GRPCPlaintextWithTLS",
+			handler: Handler{
+				Type: HandlerTypeGRPC,
+				GRPCHandler: GRPCHandler{
+					Target:    "localhost:50051",
+					Method:    "users.v1.UserService/ListUsers",
+					Plaintext: true,
+					TLS:       TLS{CAFile: "ca.pem"},
+				},
+			},
+			errContains: "'plaintext' and 'tls' are mutually exclusive",
+		},
+		{
+			name: "ValidKafkaConsumer",
+			handler: Handler{
+				Type: HandlerTypeKafka,
+				KafkaHandler: KafkaHandler{
+					Mode:        KafkaModeConsume,
+					Brokers:     []string{"localhost:9092"},
+					Topic:       "orders",
+					GroupID:     "datapipe",
+					StartOffset: KafkaStartOffsetLast,
+				},
+			},
+		},
+		{
+			name: "KafkaConsumerWithoutGroup",
+			handler: Handler{
+				Type: HandlerTypeKafka,
+				KafkaHandler: KafkaHandler{
+					Mode:    KafkaModeConsume,
+					Brokers: []string{"localhost:9092"},
+					Topic:   "orders",
+				},
+			},
+			errContains: "'group_id' is required to consume",
+		},
+		{
+			name: "KafkaConsumerWithTemplatedTopic",
+			handler: Handler{
+				Type: HandlerTypeKafka,
+				KafkaHandler: KafkaHandler{
+					Mode:    KafkaModeConsume,
+					Brokers: []string{"localhost:9092"},
+					Topic:   "orders-{{ source.region }}",
+					GroupID: "datapipe",
+				},
+			},
+			errContains: "placeholders in 'topic' are only supported to produce",
+		},
+		{
+			name: "KafkaProducerWithoutValue",
+			handler: Handler{
+				Type: HandlerTypeKafka,
+				KafkaHandler: KafkaHandler{
+					Mode:    KafkaModeProduce,
+					Brokers: []string{"localhost:9092"},
+					Topic:   "orders",
+				},
+			},
+			errContains: "'value' is required to produce",
+		},
+		{
+			name: "InvalidKafkaMode",
+			handler: Handler{
+				Type: HandlerTypeKafka,
+				KafkaHandler: KafkaHandler{
+					Mode:    "stream",
+					Brokers: []string{"localhost:9092"},
+					Topic:   "orders",
+				},
+			},
+			errContains: "invalid 'mode' value: stream",
+		},
+		{
+			name: "ValidAMQPConsumer",
+			handler: Handler{
+				Type: HandlerTypeAMQP,
+				AMQPHandler: AMQPHandler{
+					Mode:      AMQPModeConsume,
+					URL:       Secret{Env: "AMQP_URL"},
+					Queue:     "orders",
+					OnFailure: AMQPFailureActionDeadLetter,
+				},
+			},
+		},
+		{
+			name: "AMQPConsumerWithoutQueue",
+			handler: Handler{
+				Type: HandlerTypeAMQP,
+				AMQPHandler: AMQPHandler{
+					Mode: AMQPModeConsume,
+					URL:  Secret{Value: "amqp://localhost:5672"},
+				},
+			},
+			errContains: "'queue' is required to consume",
+		},
+		{
+			name: "InvalidAMQPFailureAction",
+			handler: Handler{
+				Type: HandlerTypeAMQP,
+				AMQPHandler: AMQPHandler{
+					Mode:      AMQPModeConsume,
+					URL:       Secret{Value: "amqp://localhost:5672"},
+					Queue:     "orders",
+					OnFailure: "drop",
+				},
+			},
+			errContains: "invalid 'on_failure' value: drop",
+		},
+		{
+			name: "NegativeAMQPMaxRedeliveries",
+			handler: Handler{
+				Type: HandlerTypeAMQP,
+				AMQPHandler: AMQPHandler{
+					Mode:            AMQPModeConsume,
+					URL:             Secret{Value: "amqp://localhost:5672"},
+					Queue:           "orders",
+					MaxRedeliveries: -1,
+				},
+			},
+			errContains: "'max_redeliveries' must not be negative",
+		},
+		{
+			name: "AMQPPublisherWithoutRoutingKey",
+			handler: Handler{
+				Type: HandlerTypeAMQP,
+				AMQPHandler: AMQPHandler{
+					Mode: AMQPModePublish,
+					URL:  Secret{Value: "amqp://localhost:5672"},
+					Body: `{}`,
+				},
+			},
+			errContains: "'routing_key' is required to publish to the default exchange",
+		},
+		{
+			name: "AMQPWithoutURL",
+			handler: Handler{
+				Type: HandlerTypeAMQP,
+				AMQPHandler: AMQPHandler{
+					Mode:  AMQPModeConsume,
+					Queue: "orders",
+				},
+			},
+			errContains: "invalid 'url' config",
+		},
+		{
+			name: "ValidWebhook",
+			handler: Handler{
+				Type: HandlerTypeWebhook,
+				WebhookHandler: WebhookHandler{
+					Listen:   ":8090",
+					Path:     "/hooks/orders",
+					Response: WebhookResponseResult,
+					Signature: WebhookSignature{
+						Type:   WebhookSignatureHMACSHA256,
+						Header: "X-Signature",
+						Secret: Secret{Env: "WEBHOOK_SECRET"},
+					},
+				},
+			},
+		},
+		{
+			name: "WebhookWithRelativePath",
+			handler: Handler{
+				Type:           HandlerTypeWebhook,
+				WebhookHandler: WebhookHandler{Listen: ":8090", Path: "hooks"},
+			},
+			errContains: "'path' must start with '/'",
+		},
+		{
+			name: "WebhookSignatureWithoutHeader",
+			handler: Handler{
+				Type: HandlerTypeWebhook,
+				WebhookHandler: WebhookHandler{
+					Listen: ":8090",
+					Path:   "/hooks",
+					Signature: WebhookSignature{
+						Type:   WebhookSignatureSharedSecret,
+						Secret: Secret{Value: "secret"},
+					},
+				},
+			},
+			errContains: "invalid 'signature' config: 'header' is required",
+		},
+		{
+			name: "InvalidWebhookResponse",
+			handler: Handler{
+				Type:           HandlerTypeWebhook,
+				WebhookHandler: WebhookHandler{Listen: ":8090", Path: "/hooks", Response: "sync"},
+			},
+			errContains: "invalid 'response' value: sync",
+		},
+		{
+			name: "ValidSQLExec",
+			handler: Handler{
+				Type: HandlerTypeSQL,
+				SQLHandler: SQLHandler{
+					Driver:    SQLDriverPostgres,
+					DSN:       Secret{Env: "DATABASE_URL"},
+					Mode:      SQLModeExec,
+					Query:     "INSERT INTO orders (id) VALUES ({{ source.id }})",
+					BatchSize: 100,
+				},
+			},
+		},
+		{
+			name: "InvalidSQLDriver",
+			handler: Handler{
+				Type: HandlerTypeSQL,
+				SQLHandler: SQLHandler{
+					Driver: "oracle",
+					DSN:    Secret{Value: "dsn"},
+					Query:  "SELECT 1",
+				},
+			},
+			errContains: "invalid 'driver' value: oracle",
+		},
+		{
+			name: "SQLBatchInQueryMode",
+			handler: Handler{
+				Type: HandlerTypeSQL,
+				SQLHandler: SQLHandler{
+					Driver:    SQLDriverSQLite,
+					DSN:       Secret{Value: "file:test.db"},
+					Query:     "SELECT 1",
+					BatchSize: 10,
+				},
+			},
+			errContains: "'batch_size' is only supported in exec mode",
+		},
+		{
+			name: "SQLQuotedPlaceholder",
+			handler: Handler{
+				Type: HandlerTypeSQL,
+				SQLHandler: SQLHandler{
+					Driver: SQLDriverPostgres,
+					DSN:    Secret{Value: "dsn"},
+					Query:  "SELECT * FROM orders WHERE status = 'new' AND id = '{{ source.id }}'",
+				},
+			},
+			errContains: "placeholder 'source.id' must not be quoted in 'query'",
+		},
+		{
+			name: "SQLPlaceholderAfterEscapedQuotes",
+			handler: Handler{
+				Type: HandlerTypeSQL,
+				SQLHandler: SQLHandler{
+					Driver: SQLDriverMySQL,
+					DSN:    Secret{Value: "dsn"},
+					Query:  `SELECT * FROM ` + "`orders`" + ` WHERE note <> 'it''s \'new\'' AND id = {{ source.id }}`,
+				},
+			},
+		},
+		{
+			name: "ValidRedisSet",
+			handler: Handler{
+				Type: HandlerTypeRedis,
+				RedisHandler: RedisHandler{
+					Addr:     "localhost:6379",
+					Password: &Secret{Env: "REDIS_PASSWORD"},
+					Command:  RedisCommandSet,
+					Key:      "order:{{ source.id }}",
+					Value:    "{{ source.status }}",
+					TTL:      time.Hour,
+				},
+			},
+		},
+		{
+			name: "RedisXAddWithoutFields",
+			handler: Handler{
+				Type: HandlerTypeRedis,
+				RedisHandler: RedisHandler{
+					Addr:    "localhost:6379",
+					Command: RedisCommandXAdd,
+					Key:     "events",
+				},
+			},
+			errContains: "'fields' is required for xadd",
+		},
+		{
+			name: "RedisXReadWithTemplatedKey",
+			handler: Handler{
+				Type: HandlerTypeRedis,
+				RedisHandler: RedisHandler{
+					Addr:    "localhost:6379",
+					Command: RedisCommandXRead,
+					Key:     "events-{{ source.region }}",
+				},
+			},
+			errContains: "placeholders in 'key' are not supported for xread",
+		},
+		{
+			name: "RedisGroupWithoutConsumer",
+			handler: Handler{
+				Type: HandlerTypeRedis,
+				RedisHandler: RedisHandler{
+					Addr:    "localhost:6379",
+					Command: RedisCommandXRead,
+					Key:     "events",
+					Group:   "datapipe",
+				},
+			},
+			errContains: "'group' and 'consumer' must be set together",
+		},
+		{
+			name: "InvalidRedisCommand",
+			handler: Handler{
+				Type: HandlerTypeRedis,
+				RedisHandler: RedisHandler{
+					Addr:    "localhost:6379",
+					Command: "del",
+					Key:     "events",
+				},
+			},
+			errContains: "invalid 'command' value: del",
+		},
+		{
+			name: "ValidS3List",
+			handler: Handler{
+				Type: HandlerTypeS3,
+				S3Handler: S3Handler{
+					Endpoint:        "http://localhost:9000",
+					Bucket:          "exports",
+					PathStyle:       true,
+					AccessKeyID:     Secret{Value: "minio"},
+					SecretAccessKey: Secret{Env: "S3_SECRET_KEY"},
+					Mode:            S3ModeList,
+					Prefix:          "orders/",
+					Format:          S3FormatCSV,
+				},
+			},
+		},
+		{
+			name: "S3WithoutSecretKey",
+			handler: Handler{
+				Type: HandlerTypeS3,
+				S3Handler: S3Handler{
+					Bucket:      "exports",
+					AccessKeyID: Secret{Value: "minio"},
+					Mode:        S3ModePut,
+					Key:         "orders.json",
+					Body:        "{{ source.order }}",
+				},
+			},
+			errContains: "invalid 'secret_access_key' config",
+		},
+		{
+			name: "S3ListWithTemplatedPrefix",
+			handler: Handler{
+				Type: HandlerTypeS3,
+				S3Handler: S3Handler{
+					Bucket:          "exports",
+					AccessKeyID:     Secret{Value: "minio"},
+					SecretAccessKey: Secret{Value: "minio-secret"},
+					Mode:            S3ModeList,
+					Prefix:          "orders/{{ source.date }}/",
+					Format:          S3FormatJSONL,
+				},
+			},
+			errContains: "placeholders in 'prefix' are not supported",
+		},
+		{
+			name: "S3ListWithoutFormat",
+			handler: Handler{
+				Type: HandlerTypeS3,
+				S3Handler: S3Handler{
+					Bucket:          "exports",
+					AccessKeyID:     Secret{Value: "minio"},
+					SecretAccessKey: Secret{Value: "minio-secret"},
+					Mode:            S3ModeList,
+				},
+			},
+			errContains: "invalid 'format' value: ",
+		},
+		{
+			name: "S3PutWithoutBody",
+			handler: Handler{
+				Type: HandlerTypeS3,
+				S3Handler: S3Handler{
+					Bucket:          "exports",
+					AccessKeyID:     Secret{Value: "minio"},
+					SecretAccessKey: Secret{Value: "minio-secret"},
+					Mode:            S3ModePut,
+					Key:             "orders/{{ _pipe.batch_id }}.jsonl",
+				},
+			},
+			errContains: "'body' is required in put mode",
+		},
+		{
+			name: "ValidEmailDigest",
+			handler: Handler{
+				Type: HandlerTypeEmail,
+				EmailHandler: EmailHandler{
+					Host:     "smtp.example.com",
+					Username: "datapipe",
+					Password: &Secret{Env: "SMTP_PASSWORD"},
+					From:     "Datapipe <datapipe@example.com>",
+					To:       []string{"{{ source.manager }}"},
+					Subject:  "New orders",
+					HTML:     "<li>{{ source.id }}</li>",
+					Digest:   &EmailDigest{HTMLHeader: "<ul>", HTMLFooter: "</ul>"},
+				},
+			},
+		},
+		{
+			name: "EmailWithInvalidFrom",
+			handler: Handler{
+				Type: HandlerTypeEmail,
+				EmailHandler: EmailHandler{
+					Host: "smtp.example.com",
+					From: "datapipe",
+					To:   []string{"alice@example.com"},
+					Text: "Hello",
+				},
+			},
+			errContains: "invalid 'from' address",
+		},
+		{
+			name: "EmailWithoutRecipients",
+			handler: Handler{
+				Type: HandlerTypeEmail,
+				EmailHandler: EmailHandler{
+					Host: "smtp.example.com",
+					From: "datapipe@example.com",
+					Text: "Hello",
+				},
+			},
+			errContains: "at least one of 'to', 'cc' or 'bcc' is required",
+		},
+		{
+			name: "EmailWithoutBody",
+			handler: Handler{
+				Type: HandlerTypeEmail,
+				EmailHandler: EmailHandler{
+					Host: "smtp.example.com",
+					From: "datapipe@example.com",
+					To:   []string{"alice@example.com"},
+				},
+			},
+			errContains: "at least one of 'text' or 'html' is required",
+		},
+		{
+			name: "EmailUsernameWithoutPassword",
+			handler: Handler{
+				Type: HandlerTypeEmail,
+				EmailHandler: EmailHandler{
+					Host:     "smtp.example.com",
+					Username: "datapipe",
+					From:     "datapipe@example.com",
+					To:       []string{"alice@example.com"},
+					Text:     "Hello",
+				},
+			},
+			errContains: "'username' and 'password' must be set together",
+		},
+		{
+			name: "InvalidEmailSecurity",
+			handler: Handler{
+				Type: HandlerTypeEmail,
+				EmailHandler: EmailHandler{
+					Host:     "smtp.example.com",
+					Security: "ssl",
+					From:     "datapipe@example.com",
+					To:       []string{"alice@example.com"},
+					Text:     "Hello",
+				},
+			},
+			errContains: "invalid 'security' value: ssl",
+		},
+		{
+			name: "ValidGraphQLPagination",
+			handler: Handler{
+				Type: HandlerTypeGraphQL,
+				GraphQLHandler: GraphQLHandler{
+					URL:         "https://api.example.com/graphql",
+					Query:       "query($id: ID!, $after: String) { orders(customer: $id, after: $after) { id } }",
+					Variables:   map[string]string{"id": "{{ source.id }}"},
+					ResultsPath: "orders.nodes",
+					Pagination:  &GraphQLPagination{PageInfoPath: "orders.pageInfo"},
+				},
+			},
+		},
+		{
+			name: "GraphQLWithoutQuery",
+			handler: Handler{
+				Type:           HandlerTypeGraphQL,
+				GraphQLHandler: GraphQLHandler{URL: "https://api.example.com/graphql", ResultsPath: "orders"},
+			},
+			errContains: "'query' is required",
+		},
+		{
+			name: "GraphQLWithInvalidResultsPath",
+			handler: Handler{
+				Type: HandlerTypeGraphQL,
+				GraphQLHandler: GraphQLHandler{
+					URL:         "https://api.example.com/graphql",
+					Query:       "{ orders { id } }",
+					ResultsPath: "orders..nodes",
+				},
+			},
+			errContains: "invalid 'results_path': invalid path: orders..nodes",
+		},
+		{
+			name: "GraphQLCursorVariableNotDeclared",
+			handler: Handler{
+				Type: HandlerTypeGraphQL,
+				GraphQLHandler: GraphQLHandler{
+					URL:         "https://api.example.com/graphql",
+					Query:       "query($after: String) { orders(after: $after) { id } }",
+					ResultsPath: "orders.nodes",
+					Pagination:  &GraphQLPagination{PageInfoPath: "orders.pageInfo", CursorVariable: "cursor"},
+				},
+			},
+			errContains: "'query' must declare the '$cursor' variable of the pagination",
+		},
+		{
+			name: "GraphQLCursorVariableInVariables",
+			handler: Handler{
+				Type: HandlerTypeGraphQL,
+				GraphQLHandler: GraphQLHandler{
+					URL:         "https://api.example.com/graphql",
+					Query:       "query($after: String) { orders(after: $after) { id } }",
+					Variables:   map[string]string{"after": "{{ source.cursor }}"},
+					ResultsPath: "orders.nodes",
+					Pagination:  &GraphQLPagination{PageInfoPath: "orders.pageInfo"},
+				},
+			},
+			errContains: "'after' variable is set by the pagination and must not be in 'variables'",
+		},
+		{
+			name: "InvalidDryRunMode",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					DryRun: DryRun{Mode: "skip"},
+				},
+			},
+			errContains: "invalid 'dry_run' config: invalid 'mode' value: skip",
+		},
+		{
+			name: "DryRunResponseAndResponseFile",
+			handler: Handler{
+				HTTPHandler: HTTPHandler{
+					Method: "POST",
+					URL:    "http://example.com",
+					DryRun: DryRun{Response: `{"results":[]}`, ResponseFile: "response.json"},
+				},
+			},
+			errContains: "'response' and 'response_file' are mutually exclusive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Validate()
+			if tt.errContains != "" {
+				assert.Error(t, err)
+				assert.True(t, strings.Contains(err.Error(), tt.errContains))
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestConfig_ParseFromYamlFile(t *testing.T) {
+	cfg := NewConfig()
+	err := cfg.ParseFromYamlFile("../config.example.yaml")
+	assert.NoError(t, err)
+}
+
+func TestConfig_ValidatePlaceholders(t *testing.T) {
+	tests := []struct {
+		name        string
+		handlers    HandlerMap
+		errContains string
+	}{
+		{
+			name: "Valid",
+			handlers: HandlerMap{
+				{
+					Name: "data-source",
+					Handler: Handler{
+						OutputSchema: []string{"id", "title", "tenant", "status"},
+						HTTPHandler:  HTTPHandler{Method: "GET", URL: "http://example.com"},
+					},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method:      "POST",
+						URL:         "http://example.com/{{ data-source.id }}",
+						Body:        `{"title": {{ data-source.title }}}`,
+						Headers:     map[string]string{"X-Tenant-ID": "{{ data-source.tenant }}"},
+						QueryParams: map[string]string{"status": "{{ data-source.status }}"},
+					}},
+				},
+			},
+		},
+		{
+			name: "DottedHandlerName",
+			handlers: HandlerMap{
+				{
+					Name:    "data",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "data.source",
+					Handler: Handler{
+						OutputSchema: []string{"id"},
+						HTTPHandler:  HTTPHandler{Method: "GET", URL: "http://example.com"},
+					},
+				},
+				{
+					Name:    "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "POST", URL: "http://example.com/{{ data.source.id }}"}},
+				},
+			},
+		},
+		{
+			name: "UnknownHandler",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "filter",
+					Handler: Handler{
+						Type:          HandlerTypeFilter,
+						FilterHandler: FilterHandler{Expression: `{{ data-sorce.status }} == "new"`},
+					},
+				},
+			},
+			errContains: "invalid placeholder: handlers.filter.filter.expression: " +
+				"placeholder '{{ data-sorce.status }}' does not reference any previous handler",
+		},
+		{
+			name: "LaterHandler",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com/{{ data-sink.id }}"}},
+				},
+				{
+					Name:    "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "POST", URL: "http://example.com"}},
+				},
+			},
+			errContains: "handlers.data-source.http.url: placeholder '{{ data-sink.id }}'",
+		},
+		{
+			name: "SelfReference",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com/{{ data-source.id }}"}},
+				},
+			},
+			errContains: "handlers.data-source.http.url: placeholder '{{ data-source.id }}'",
+		},
+		{
+			name: "InvalidHeader",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method:  "POST",
+						URL:     "http://example.com",
+						Headers: map[string]string{"X-Tenant-ID": "{{ tenant }}"},
+					}},
+				},
+			},
+			errContains: "handlers.data-sink.http.headers.X-Tenant-ID: placeholder '{{ tenant }}'",
+		},
+		{
+			name: "InvalidQueryParamKey",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method:      "POST",
+						URL:         "http://example.com",
+						QueryParams: map[string]string{"{{ data-sink.key }}": "{{ data-source.value }}"},
+					}},
+				},
+			},
+			errContains: "handlers.data-sink.http.query_params.{{ data-sink.key }}: placeholder '{{ data-sink.key }}'",
+		},
+		{
+			name: "RetryAttempt",
+			handlers: HandlerMap{
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method:  "POST",
+						URL:     "http://example.com",
+						Headers: map[string]string{"Idempotency-Key": "{{ _pipe.retry_attempt }}"},
+					}},
+				},
+			},
+		},
+		{
+			name: "RetryAttemptInFilter",
+			handlers: HandlerMap{
+				{
+					Name: "filter",
+					Handler: Handler{
+						Type:          HandlerTypeFilter,
+						FilterHandler: FilterHandler{Expression: `{{ _pipe.retry_attempt }} == "1"`},
+					},
+				},
+			},
+			errContains: "handlers.filter.filter.expression: built-in placeholder '{{ _pipe.retry_attempt }}' " +
+				"is not available here",
+		},
+		{
+			name: "GRPCBodyAndMetadata",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{Type: HandlerTypeGRPC, GRPCHandler: GRPCHandler{
+						Target:   "localhost:50051",
+						Method:   "users.v1.UserService/SaveUser",
+						Body:     `{"id": {{ data-source.id }}, "attempt": {{ _pipe.retry_attempt }}}`,
+						Metadata: map[string]string{"x-tenant": "{{ data-sorce.tenant }}"},
+					}},
+				},
+			},
+			errContains: "handlers.data-sink.grpc.metadata.x-tenant: placeholder '{{ data-sorce.tenant }}' " +
+				"does not reference any previous handler",
+		},
+		{
+			name: "ReservedHandlerName",
+			handlers: HandlerMap{
+				{
+					Name:    "_pipe",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+			},
+			errContains: "handler name '_pipe' is reserved",
+		},
+		{
+			name: "HandlerNamedRetry",
+			handlers: HandlerMap{
+				{
+					Name:    "retry",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method:  "POST",
+						URL:     "http://example.com/{{ retry.id }}",
+						Retries: 1,
+						Headers: map[string]string{"Idempotency-Key": "{{ retry.id }}-{{ _pipe.retry_attempt }}"},
+					}},
+				},
+			},
+		},
+		{
+			name: "S3BatchID",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{Type: HandlerTypeS3, S3Handler: S3Handler{
+						Bucket:          "exports",
+						AccessKeyID:     Secret{Value: "minio"},
+						SecretAccessKey: Secret{Value: "minio-secret"},
+						Mode:            S3ModePut,
+						Key:             "orders/{{ data-source.date }}/{{ _pipe.batch_id }}.jsonl",
+						Body:            "{{ data-source.order }}",
+					}},
+				},
+			},
+		},
+		{
+			name: "S3BatchIDInOtherHandler",
+			handlers: HandlerMap{
+				{
+					Name: "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method: "GET",
+						URL:    "http://example.com/{{ _pipe.batch_id }}",
+					}},
+				},
+			},
+			errContains: "handlers.data-source.http.url: built-in placeholder '{{ _pipe.batch_id }}' is not available here",
+		},
+		{
+			name: "EmailRecipients",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "notify",
+					Handler: Handler{Type: HandlerTypeEmail, EmailHandler: EmailHandler{
+						Host:    "smtp.example.com",
+						From:    "datapipe@example.com",
+						To:      []string{"{{ data-source.email }}"},
+						Cc:      []string{"sales@example.com", "{{ data-sorce.manager }}"},
+						Subject: "Order {{ data-source.id }}",
+						Text:    "Order {{ data-source.id }}",
+					}},
+				},
+			},
+			errContains: "handlers.notify.email.cc[1]: placeholder '{{ data-sorce.manager }}' " +
+				"does not reference any previous handler",
+		},
+		{
+			name: "GraphQLVariables",
+			handlers: HandlerMap{
+				{
+					Name:    "data-source",
+					Handler: Handler{HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com"}},
+				},
+				{
+					Name: "orders",
+					Handler: Handler{Type: HandlerTypeGraphQL, GraphQLHandler: GraphQLHandler{
+						URL:         "https://api.example.com/graphql",
+						Headers:     map[string]string{"X-Tenant": "{{ data-source.tenant }}"},
+						Query:       "query($id: ID!) { orders(customer: $id) { id } }",
+						Variables:   map[string]string{"id": "{{ data-sorce.id }}"},
+						ResultsPath: "orders",
+					}},
+				},
+			},
+			errContains: "handlers.orders.graphql.variables.id: placeholder '{{ data-sorce.id }}' " +
+				"does not reference any previous handler",
+		},
+		{
+			name: "FieldNotInSchema",
+			handlers: HandlerMap{
+				{
+					Name: "data-source",
+					Handler: Handler{
+						OutputSchema: []string{"id", "title"},
+						HTTPHandler:  HTTPHandler{Method: "GET", URL: "http://example.com"},
+					},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method:      "POST",
+						URL:         "http://example.com",
+						QueryParams: map[string]string{"title": "{{ data-source.tilte }}"},
+					}},
+				},
+			},
+			errContains: "handlers.data-sink.http.query_params.title: placeholder '{{ data-source.tilte }}' " +
+				"references 'tilte' field, which is not in the 'output_schema' of 'data-source' handler",
+		},
+		{
+			name: "NestedFieldInSchema",
+			handlers: HandlerMap{
+				{
+					Name: "data-source",
+					Handler: Handler{
+						OutputSchema: []string{"id", "customer"},
+						HTTPHandler:  HTTPHandler{Method: "GET", URL: "http://example.com"},
+					},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method: "POST",
+						URL:    "http://example.com/{{ data-source.customer.id }}",
+					}},
+				},
+			},
+		},
+		{
+			name: "NestedFieldNotInSchema",
+			handlers: HandlerMap{
+				{
+					Name: "data-source",
+					Handler: Handler{
+						OutputSchema: []string{"id", "customer"},
+						HTTPHandler:  HTTPHandler{Method: "GET", URL: "http://example.com"},
+					},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method: "POST",
+						URL:    "http://example.com/{{ data-source.customers.id }}",
+					}},
+				},
+			},
+			errContains: "references 'customers.id' field, which is not in the 'output_schema' of 'data-source' handler",
+		},
+		{
+			name: "CapturedResponseField",
+			handlers: HandlerMap{
+				{
+					Name: "data-source",
+					Handler: Handler{
+						OutputSchema: []string{"id"},
+						HTTPHandler: HTTPHandler{Method: "GET", URL: "http://example.com", Response: Response{
+							Headers:         map[string]string{"request_id": "X-Request-Id"},
+							StatusCodeField: "status",
+						}},
+					},
+				},
+				{
+					Name: "data-sink",
+					Handler: Handler{HTTPHandler: HTTPHandler{
+						Method: "POST",
+						URL:    "http://example.com/{{ data-source.id }}",
+						Body:   `{"request_id":{{ data-source.request_id }},"status":{{ data-source.status }}}`,
+					}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Engine:   Engine{Interval: time.Minute},
+				Handlers: &tt.handlers,
+			}
+			err := cfg.Validate()
+			if tt.errContains != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRate_PerSecond(t *testing.T) {
+	tests := []struct {
+		rate        Rate
+		expected    float64
+		errContains string
+	}{
+		{rate: "", expected: 0},
+		{rate: "50/s", expected: 50},
+		{rate: "120/m", expected: 2},
+		{rate: "1800 / h", expected: 0.5},
+		{rate: "50", errContains: "expected format is '<count>/<unit>'"},
+		{rate: "0/s", errContains: "count must be a positive number"},
+		{rate: "many/s", errContains: "count must be a positive number"},
+		{rate: "50/d", errContains: "unit must be 's', 'm' or 'h'"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.rate), func(t *testing.T) {
+			perSecond, err := tt.rate.PerSecond()
+			if tt.errContains != "" {
+				assert.Error(t, err)
+				assert.True(t, strings.Contains(err.Error(), tt.errContains))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, perSecond)
+		})
+	}
 }
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type WebhookResponse string
+
+const (
+	// WebhookResponseAccepted responds with 202 as soon as the records of a request are passed down the chain, the default.
+	WebhookResponseAccepted WebhookResponse = "accepted"
+	// WebhookResponseResult responds once the chain completed for all records of a request, with its result.
+	WebhookResponseResult WebhookResponse = "result"
+)
+
+type WebhookSignatureType string
+
+const (
+	// WebhookSignatureSharedSecret expects the secret as is in the signature header.
+	WebhookSignatureSharedSecret WebhookSignatureType = "shared_secret"
+	// WebhookSignatureHMACSHA256 expects the hex encoded HMAC-SHA256 of the request body in the signature header.
+	WebhookSignatureHMACSHA256 WebhookSignatureType = "hmac_sha256"
+)
+
+// WebhookHandler receives records pushed to a route of an embedded HTTP server.
+type WebhookHandler struct {
+	// Listen is the address of the embedded server, e.g. ':8090'.
+	Listen string `yaml:"listen"`
+	Path   string `yaml:"path"`
+	// Method of the route, POST by default.
+	Method    string           `yaml:"method"`
+	Signature WebhookSignature `yaml:"signature"`
+	Response  WebhookResponse  `yaml:"response"`
+	// MaxBodySize is the maximum size of a request body in bytes, 1MiB by default.
+	MaxBodySize int64 `yaml:"max_body_size"`
+	// WaitTime ends a run when no request arrives for this long, 10s by default.
+	WaitTime time.Duration `yaml:"wait_time"`
+	// Timeout is how long a request waits for the pipeline to accept it and, with 'response: result',
+	// for the chain to complete, 30s by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h WebhookHandler) Validate() error {
+	if h.Listen == "" {
+		return fmt.Errorf("'listen' is required")
+	}
+	if !strings.HasPrefix(h.Path, "/") {
+		return fmt.Errorf("'path' must start with '/'")
+	}
+	if strings.ContainsAny(h.Method, " /{}") {
+		return fmt.Errorf("invalid 'method' value: %s", h.Method)
+	}
+	if err := h.Signature.Validate(); err != nil {
+		return fmt.Errorf("invalid 'signature' config: %s", err)
+	}
+	switch h.Response {
+	case "", WebhookResponseAccepted, WebhookResponseResult:
+	default:
+		return fmt.Errorf("invalid 'response' value: %s", h.Response)
+	}
+	if h.MaxBodySize < 0 || h.WaitTime < 0 || h.Timeout < 0 {
+		return fmt.Errorf("'max_body_size', 'wait_time' and 'timeout' must not be negative")
+	}
+	return nil
+}
+
+// WebhookSignature validates that requests are sent by a party that knows the secret.
+type WebhookSignature struct {
+	Type WebhookSignatureType `yaml:"type"`
+	// Header that contains the signature.
+	Header string `yaml:"header"`
+	Secret Secret `yaml:"secret"`
+	// Prefix of the signature in the header, e.g. 'sha256='.
+	Prefix string `yaml:"prefix"`
+}
+
+func (s WebhookSignature) Validate() error {
+	switch s.Type {
+	case "":
+		return nil
+	case WebhookSignatureSharedSecret, WebhookSignatureHMACSHA256:
+	default:
+		return fmt.Errorf("invalid 'type' value: %s", s.Type)
+	}
+	if s.Header == "" {
+		return fmt.Errorf("'header' is required")
+	}
+	if err := s.Secret.Validate(); err != nil {
+		return fmt.Errorf("invalid 'secret' config: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,548 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultAMQPPrefetch          = 10
+	defaultAMQPMaxMessages       = 100
+	defaultAMQPMaxRedeliveries   = 1
+	defaultAMQPWaitTime          = 10 * time.Second
+	defaultAMQPTimeout           = 15 * time.Second
+	defaultAMQPReconnectAttempts = 3
+	defaultAMQPReconnectInterval = 5 * time.Second
+	defaultAMQPContentType       = "application/json"
+)
+
+// amqpSession is a connection to the broker with a single channel.
+type amqpSession interface {
+	Consume(queue, consumer string, prefetch int) (<-chan amqp.Delivery, error)
+	// Cancel stops the consumer, its deliveries channel is closed once the buffered deliveries are received.
+	Cancel(consumer string) error
+	// Publish publishes the message and waits for the publisher confirm.
+	Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error
+	IsClosed() bool
+	Close() error
+}
+
+func newAMQPHandler(name string, cfg config.AMQPHandler, env *handlerEnv, logger zerolog.Logger) (Handler, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		tlsConfig, err = newTLSConfig(*cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	connector := newAMQPConnector(cfg, logger, func() (amqpSession, error) {
+		url, err := cfg.URL.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read url: %s", err)
+		}
+		return dialAMQP(url, tlsConfig, cfg.Mode == config.AMQPModePublish)
+	})
+
+	switch cfg.Mode {
+	case config.AMQPModeConsume:
+		return newAMQPConsumer(name, cfg, env.dryRun, logger, connector), nil
+	case config.AMQPModePublish:
+		return newAMQPPublisher(name, cfg, env.dryRun, logger, connector), nil
+	default:
+		return nil, fmt.Errorf("unknown amqp mode: %s", cfg.Mode)
+	}
+}
+
+type amqpChannelSession struct {
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+func dialAMQP(url string, tlsConfig *tls.Config, confirms bool) (*amqpChannelSession, error) {
+	conn, err := amqp.DialTLS(url, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %s", err)
+	}
+	if confirms {
+		if err := channel.Confirm(false); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to enable publisher confirms: %s", err)
+		}
+	}
+	return &amqpChannelSession{conn: conn, channel: channel}, nil
+}
+
+func (s *amqpChannelSession) Consume(queue, consumer string, prefetch int) (<-chan amqp.Delivery, error) {
+	if err := s.channel.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("failed to set prefetch: %s", err)
+	}
+	return s.channel.Consume(queue, consumer, false, false, false, false, nil)
+}
+
+func (s *amqpChannelSession) Cancel(consumer string) error {
+	return s.channel.Cancel(consumer, false)
+}
+
+func (s *amqpChannelSession) Publish(
+	ctx context.Context, exchange, routingKey string, msg amqp.Publishing,
+) error {
+	confirmation, err := s.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wait for publisher confirm: %s", err)
+	}
+	if !acked {
+		return fmt.Errorf("message was nacked by the broker")
+	}
+	return nil
+}
+
+func (s *amqpChannelSession) IsClosed() bool {
+	return s.conn.IsClosed() || s.channel.IsClosed()
+}
+
+func (s *amqpChannelSession) Close() error {
+	return s.conn.Close()
+}
+
+// amqpConnector keeps a session to the broker and reconnects once it's closed.
+type amqpConnector struct {
+	attempts int
+	interval time.Duration
+	logger   zerolog.Logger
+	dial     func() (amqpSession, error)
+
+	mux     sync.Mutex
+	current amqpSession
+}
+
+func newAMQPConnector(
+	cfg config.AMQPHandler, logger zerolog.Logger, dial func() (amqpSession, error),
+) *amqpConnector {
+	if cfg.ReconnectAttempts == 0 {
+		cfg.ReconnectAttempts = defaultAMQPReconnectAttempts
+	}
+	if cfg.ReconnectInterval == 0 {
+		cfg.ReconnectInterval = defaultAMQPReconnectInterval
+	}
+	return &amqpConnector{
+		attempts: cfg.ReconnectAttempts,
+		interval: cfg.ReconnectInterval,
+		logger:   logger,
+		dial:     dial,
+	}
+}
+
+// session returns the open session, connecting to the broker if there is none.
+// The second value reports whether a new session was created.
+func (c *amqpConnector) session(ctx context.Context) (amqpSession, bool, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.current != nil && !c.current.IsClosed() {
+		return c.current, false, nil
+	}
+	c.current = nil
+
+	var err error
+	for attempt := 1; attempt <= c.attempts; attempt++ {
+		var s amqpSession
+		s, err = c.dial()
+		if err == nil {
+			c.current = s
+			return s, true, nil
+		}
+		c.logger.Warn().Err(err).Int("attempt", attempt).Msg("failed to connect to AMQP broker")
+		if attempt == c.attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(c.interval):
+		}
+	}
+	return nil, false, fmt.Errorf("failed to connect to AMQP broker: %s", err)
+}
+
+// reset closes the session, so the next call of session reconnects.
+func (c *amqpConnector) reset(s amqpSession) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.current != s {
+		return
+	}
+	if err := s.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		c.logger.Error().Err(err).Msg("failed to close AMQP connection")
+	}
+	c.current = nil
+}
+
+// amqpConsumer emits a result per delivered message. A message is acknowledged after the rest of the chain
+// succeeded for it, and requeued or dead-lettered otherwise. The queue is only consumed during a run,
+// so no messages are held by the consumer between runs.
+type amqpConsumer struct {
+	name      string
+	cfg       config.AMQPHandler
+	dryRun    bool
+	logger    zerolog.Logger
+	connector *amqpConnector
+
+	mux       sync.Mutex
+	consumers int
+	consuming bool
+	pending   map[uint64]amqp.Delivery
+	// held are the messages of a dry run, they are requeued once the consumer is stopped,
+	// otherwise the broker would deliver them again in the same run
+	held []amqp.Delivery
+}
+
+func newAMQPConsumer(
+	name string, cfg config.AMQPHandler, dryRun bool, logger zerolog.Logger, connector *amqpConnector,
+) *amqpConsumer {
+	if cfg.Prefetch == 0 {
+		cfg.Prefetch = defaultAMQPPrefetch
+	}
+	if cfg.MaxMessages == 0 {
+		cfg.MaxMessages = defaultAMQPMaxMessages
+	}
+	if cfg.WaitTime == 0 {
+		cfg.WaitTime = defaultAMQPWaitTime
+	}
+	if cfg.OnFailure == "" {
+		cfg.OnFailure = config.AMQPFailureActionRequeue
+	}
+	if cfg.MaxRedeliveries == 0 {
+		cfg.MaxRedeliveries = defaultAMQPMaxRedeliveries
+	}
+	return &amqpConsumer{
+		name:      name,
+		cfg:       cfg,
+		dryRun:    dryRun,
+		logger:    logger,
+		connector: connector,
+		pending:   map[uint64]amqp.Delivery{},
+	}
+}
+
+func (h *amqpConsumer) Name() string {
+	return h.name
+}
+
+func (h *amqpConsumer) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	var results []HandlerResult
+	err := h.HandleStream(ctx, data, func(messages []HandlerResult) error {
+		results = append(results, messages...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HandleStream emits the delivered messages one by one, until max messages are consumed
+// or no message arrives for the wait time.
+func (h *amqpConsumer) HandleStream(
+	ctx context.Context, _ map[string]string, emit func(results []HandlerResult) error,
+) error {
+	session, consumer, deliveries, err := h.consume(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.stopConsuming(session, consumer, deliveries)
+
+	timer := time.NewTimer(h.cfg.WaitTime)
+	defer timer.Stop()
+	for i := 0; i < h.cfg.MaxMessages; i++ {
+		var delivery amqp.Delivery
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			// no new messages
+			return nil
+		case delivery, ok = <-deliveries:
+		}
+		if !ok {
+			// unacknowledged messages are requeued by the broker, the next run reconnects
+			h.connector.reset(session)
+			return fmt.Errorf("AMQP consumer was closed by the broker")
+		}
+		timer.Reset(h.cfg.WaitTime)
+
+		h.mux.Lock()
+		h.pending[delivery.DeliveryTag] = delivery
+		h.mux.Unlock()
+		if err := emit([]HandlerResult{amqpDeliveryResult(delivery)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consume starts a consumer of the queue for a run and returns its tag and deliveries.
+func (h *amqpConsumer) consume(ctx context.Context) (amqpSession, string, <-chan amqp.Delivery, error) {
+	session, connected, err := h.connector.session(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if connected {
+		// delivery tags are scoped to the channel, the messages of the previous one are redelivered by the broker
+		h.pending = map[uint64]amqp.Delivery{}
+		h.held = nil
+	}
+	h.consumers++
+	consumer := fmt.Sprintf("datapipe-%s-%d", h.name, h.consumers)
+	deliveries, err := session.Consume(h.cfg.Queue, consumer, h.cfg.Prefetch)
+	if err != nil {
+		h.connector.reset(session)
+		return nil, "", nil, fmt.Errorf("failed to consume AMQP queue: %s", err)
+	}
+	h.consuming = true
+	return session, consumer, deliveries, nil
+}
+
+// stopConsuming cancels the consumer at the end of a run and requeues the messages that were delivered
+// but not emitted, and the messages held in dry-run mode.
+func (h *amqpConsumer) stopConsuming(session amqpSession, consumer string, deliveries <-chan amqp.Delivery) {
+	h.mux.Lock()
+	h.consuming = false
+	held := h.held
+	h.held = nil
+	h.mux.Unlock()
+
+	if err := session.Cancel(consumer); err != nil {
+		if !session.IsClosed() {
+			h.logger.Error().Err(err).Msg("failed to cancel AMQP consumer, reconnecting")
+		}
+		// the unacknowledged messages of a closed channel are requeued by the broker
+		h.connector.reset(session)
+		return
+	}
+	for delivery := range deliveries {
+		if err := delivery.Nack(false, true); err != nil {
+			h.logger.Error().Err(err).Uint64("delivery_tag", delivery.DeliveryTag).Msg("failed to requeue AMQP message")
+		}
+	}
+	for _, delivery := range held {
+		h.requeueDryRun(delivery)
+	}
+}
+
+func (h *amqpConsumer) requeueDryRun(delivery amqp.Delivery) {
+	h.logger.Info().Uint64("delivery_tag", delivery.DeliveryTag).Msg("dry run: AMQP message requeued instead of acknowledged")
+	if err := delivery.Nack(false, true); err != nil {
+		h.logger.Error().Err(err).Uint64("delivery_tag", delivery.DeliveryTag).Msg("failed to requeue AMQP message")
+	}
+}
+
+// Ack acknowledges the message of the result once the rest of the chain succeeded for it,
+// or rejects it according to the 'on_failure' option.
+func (h *amqpConsumer) Ack(_ context.Context, result HandlerResult, chainErr error) {
+	tag, err := strconv.ParseUint(string(result["delivery_tag"]), 10, 64)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to acknowledge AMQP message: invalid delivery tag")
+		return
+	}
+
+	h.mux.Lock()
+	delivery, ok := h.pending[tag]
+	delete(h.pending, tag)
+	hold := ok && h.dryRun && h.consuming
+	if hold {
+		h.held = append(h.held, delivery)
+	}
+	h.mux.Unlock()
+	if !ok {
+		// the channel of the message was closed, so it is redelivered anyway
+		return
+	}
+	if hold {
+		return
+	}
+
+	switch {
+	case h.dryRun:
+		h.requeueDryRun(delivery)
+		return
+	case chainErr == nil:
+		err = delivery.Ack(false)
+	default:
+		requeue := h.cfg.OnFailure == config.AMQPFailureActionRequeue && h.canRequeue(delivery)
+		h.logger.Warn().
+			Uint64("delivery_tag", tag).
+			Bool("redelivered", delivery.Redelivered).
+			Bool("requeue", requeue).
+			Msg("AMQP message failed")
+		err = delivery.Nack(false, requeue)
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Uint64("delivery_tag", tag).Msg("failed to acknowledge AMQP message")
+	}
+}
+
+// canRequeue reports whether a failed message may be requeued again, so a message that keeps failing doesn't loop
+// between the queue and the consumer. Quorum queues count the deliveries in the 'x-delivery-count' header,
+// other queues only tell whether the message was redelivered, so it's not requeued a second time.
+func (h *amqpConsumer) canRequeue(delivery amqp.Delivery) bool {
+	switch count := delivery.Headers["x-delivery-count"].(type) {
+	case int64:
+		return count < int64(h.cfg.MaxRedeliveries)
+	case int32:
+		return int64(count) < int64(h.cfg.MaxRedeliveries)
+	case int:
+		return count < h.cfg.MaxRedeliveries
+	}
+	return !delivery.Redelivered
+}
+
+func amqpDeliveryResult(delivery amqp.Delivery) HandlerResult {
+	headers := make(map[string]any, len(delivery.Headers))
+	for key, value := range delivery.Headers {
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		headers[key] = value
+	}
+
+	result := HandlerResult{
+		"body":           jsonOrString(delivery.Body),
+		"exchange":       mustMarshal(delivery.Exchange),
+		"routing_key":    mustMarshal(delivery.RoutingKey),
+		"headers":        mustMarshal(headers),
+		"message_id":     mustMarshal(delivery.MessageId),
+		"correlation_id": mustMarshal(delivery.CorrelationId),
+		"content_type":   mustMarshal(delivery.ContentType),
+		"redelivered":    mustMarshal(delivery.Redelivered),
+		"delivery_tag":   mustMarshal(delivery.DeliveryTag),
+		"timestamp":      mustMarshal(nil),
+	}
+	if !delivery.Timestamp.IsZero() {
+		result["timestamp"] = mustMarshal(delivery.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	return result
+}
+
+// amqpPublisher publishes a message per record and waits for the publisher confirm.
+type amqpPublisher struct {
+	name      string
+	cfg       config.AMQPHandler
+	dryRun    bool
+	logger    zerolog.Logger
+	connector *amqpConnector
+}
+
+func newAMQPPublisher(
+	name string, cfg config.AMQPHandler, dryRun bool, logger zerolog.Logger, connector *amqpConnector,
+) *amqpPublisher {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultAMQPTimeout
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = defaultAMQPContentType
+	}
+	return &amqpPublisher{
+		name:      name,
+		cfg:       cfg,
+		dryRun:    dryRun,
+		logger:    logger,
+		connector: connector,
+	}
+}
+
+func (h *amqpPublisher) Name() string {
+	return h.name
+}
+
+func (h *amqpPublisher) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	routingKey, msg, err := h.createMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AMQP message: %s", err)
+	}
+
+	if h.dryRun {
+		h.logger.Info().
+			Str("exchange", h.cfg.Exchange).
+			Str("routing_key", routingKey).
+			Interface("headers", msg.Headers).
+			Str("body", string(msg.Body)).
+			Msg("dry run: message recorded")
+		return []HandlerResult{{}}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	// a message is published again on a new connection if the previous one was lost
+	for attempt := 0; ; attempt++ {
+		session, _, err := h.connector.session(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = session.Publish(ctx, h.cfg.Exchange, routingKey, msg)
+		if err == nil {
+			return []HandlerResult{{}}, nil
+		}
+		if !session.IsClosed() || attempt > 0 {
+			return nil, fmt.Errorf("failed to publish AMQP message: %s", err)
+		}
+		h.logger.Warn().Err(err).Msg("AMQP connection lost, reconnecting")
+		h.connector.reset(session)
+	}
+}
+
+func (h *amqpPublisher) createMessage(data map[string]string) (string, amqp.Publishing, error) {
+	routingKey, ok := replaceTextPlaceholders(h.cfg.RoutingKey, data)
+	if !ok {
+		return "", amqp.Publishing{}, fmt.Errorf("failed to replace placeholders in routing key: some data not found")
+	}
+	body, ok := replacePlaceholders(h.cfg.Body, data)
+	if !ok {
+		return "", amqp.Publishing{}, fmt.Errorf("failed to replace placeholders in body: some data not found")
+	}
+
+	msg := amqp.Publishing{
+		ContentType: h.cfg.ContentType,
+		Body:        []byte(body),
+		Timestamp:   time.Now(),
+	}
+	if h.cfg.Persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+	if len(h.cfg.Headers) > 0 {
+		msg.Headers = amqp.Table{}
+		for key, value := range h.cfg.Headers {
+			key, value, err := replaceKeyValuePlaceholders(key, value, data)
+			if err != nil {
+				return "", amqp.Publishing{}, fmt.Errorf("failed to replace placeholders in header: %s", err)
+			}
+			msg.Headers[key] = value
+		}
+	}
+	return routingKey, msg, nil
+}
@@ -0,0 +1,429 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAMQPSession struct {
+	mux        sync.Mutex
+	deliveries chan amqp.Delivery
+	// consumers are the deliveries channels of the started consumers, by consumer tag
+	consumers  map[string]chan amqp.Delivery
+	cancelled  []string
+	published  []amqp.Publishing
+	publishErr error
+	closed     bool
+	// loseConnection closes the session on publish
+	loseConnection bool
+}
+
+// Consume moves the queued deliveries to the channel of a new consumer.
+func (s *fakeAMQPSession) Consume(_, consumer string, _ int) (<-chan amqp.Delivery, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.consumers == nil {
+		s.consumers = map[string]chan amqp.Delivery{}
+	}
+	out := make(chan amqp.Delivery, cap(s.deliveries))
+	s.consumers[consumer] = out
+	for {
+		select {
+		case delivery, ok := <-s.deliveries:
+			if !ok {
+				delete(s.consumers, consumer)
+				close(out)
+				return out, nil
+			}
+			out <- delivery
+		default:
+			return out, nil
+		}
+	}
+}
+
+func (s *fakeAMQPSession) Cancel(consumer string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return amqp.ErrClosed
+	}
+	s.cancelled = append(s.cancelled, consumer)
+	if out, ok := s.consumers[consumer]; ok {
+		delete(s.consumers, consumer)
+		close(out)
+	}
+	return nil
+}
+
+func (s *fakeAMQPSession) Publish(_ context.Context, _, _ string, msg amqp.Publishing) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.loseConnection {
+		s.closed = true
+		return amqp.ErrClosed
+	}
+	if s.publishErr != nil {
+		return s.publishErr
+	}
+	s.published = append(s.published, msg)
+	return nil
+}
+
+func (s *fakeAMQPSession) IsClosed() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.closed
+}
+
+func (s *fakeAMQPSession) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	return nil
+}
+
+// fakeAcknowledger records the outcome of each delivery tag.
+type fakeAcknowledger struct {
+	mux      sync.Mutex
+	outcomes map[uint64]string
+}
+
+func (a *fakeAcknowledger) record(tag uint64, outcome string) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.outcomes == nil {
+		a.outcomes = map[uint64]string{}
+	}
+	a.outcomes[tag] = outcome
+	return nil
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, _ bool) error {
+	return a.record(tag, "ack")
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, _ bool, requeue bool) error {
+	if requeue {
+		return a.record(tag, "requeue")
+	}
+	return a.record(tag, "reject")
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func testAMQPDeliveries(acknowledger amqp.Acknowledger, tags ...uint64) chan amqp.Delivery {
+	deliveries := make(chan amqp.Delivery, len(tags))
+	for _, tag := range tags {
+		deliveries <- amqp.Delivery{
+			Acknowledger: acknowledger,
+			DeliveryTag:  tag,
+			RoutingKey:   "orders",
+			Body:         []byte(fmt.Sprintf(`{"id":%d}`, tag)),
+		}
+	}
+	return deliveries
+}
+
+func testAMQPConnector(sessions ...*fakeAMQPSession) (*amqpConnector, *int) {
+	dials := 0
+	connector := newAMQPConnector(config.AMQPHandler{ReconnectInterval: time.Millisecond}, zerolog.Nop(),
+		func() (amqpSession, error) {
+			if dials >= len(sessions) {
+				return nil, fmt.Errorf("connection refused")
+			}
+			dials++
+			return sessions[dials-1], nil
+		})
+	return connector, &dials
+}
+
+func TestAMQPConsumer_Handle(t *testing.T) {
+	deliveries := make(chan amqp.Delivery, 1)
+	deliveries <- amqp.Delivery{
+		Headers:       amqp.Table{"source": []byte("shop"), "attempt": int32(2)},
+		ContentType:   "text/plain",
+		CorrelationId: "corr-1",
+		MessageId:     "msg-1",
+		Timestamp:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		DeliveryTag:   3,
+		Redelivered:   true,
+		Exchange:      "events",
+		RoutingKey:    "orders.created",
+		Body:          []byte("not json"),
+	}
+	connector, _ := testAMQPConnector(&fakeAMQPSession{deliveries: deliveries})
+	h := newAMQPConsumer("orders", config.AMQPHandler{WaitTime: 10 * time.Millisecond}, false, zerolog.Nop(), connector)
+
+	results, err := h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{
+		"body":           json.RawMessage(`"not json"`),
+		"exchange":       json.RawMessage(`"events"`),
+		"routing_key":    json.RawMessage(`"orders.created"`),
+		"headers":        json.RawMessage(`{"attempt":2,"source":"shop"}`),
+		"message_id":     json.RawMessage(`"msg-1"`),
+		"correlation_id": json.RawMessage(`"corr-1"`),
+		"content_type":   json.RawMessage(`"text/plain"`),
+		"redelivered":    json.RawMessage(`true`),
+		"delivery_tag":   json.RawMessage(`3`),
+		"timestamp":      json.RawMessage(`"2024-01-02T03:04:05Z"`),
+	}}, results)
+}
+
+func TestAMQPConsumer_AcksAfterChain(t *testing.T) {
+	tests := []struct {
+		name      string
+		onFailure config.AMQPFailureAction
+		dryRun    bool
+		expected  map[uint64]string
+	}{
+		{
+			name:     "requeue by default",
+			expected: map[uint64]string{1: "ack", 2: "requeue", 3: "ack"},
+		},
+		{
+			name:      "dead letter",
+			onFailure: config.AMQPFailureActionDeadLetter,
+			expected:  map[uint64]string{1: "ack", 2: "reject", 3: "ack"},
+		},
+		{
+			name:     "dry run",
+			dryRun:   true,
+			expected: map[uint64]string{1: "requeue", 2: "requeue", 3: "requeue"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acknowledger := &fakeAcknowledger{}
+			connector, _ := testAMQPConnector(&fakeAMQPSession{deliveries: testAMQPDeliveries(acknowledger, 1, 2, 3)})
+			cfg := config.AMQPHandler{WaitTime: 10 * time.Millisecond, OnFailure: tt.onFailure}
+			h := newAMQPConsumer("orders", cfg, tt.dryRun, zerolog.Nop(), connector)
+
+			sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+				if data["orders.delivery_tag"] == "2" {
+					return nil, fmt.Errorf("sink is down")
+				}
+				return []HandlerResult{}, nil
+			}}
+
+			err := runHandlerPipe(context.Background(), nil, []Handler{h, sink}, nil)
+			assert.ErrorContains(t, err, "sink is down")
+			assert.Equal(t, tt.expected, acknowledger.outcomes)
+		})
+	}
+}
+
+func TestAMQPConsumer_DeadLettersRedeliveredMessages(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxRedeliveries int
+		delivery        amqp.Delivery
+		expected        string
+	}{
+		{name: "first delivery", expected: "requeue"},
+		{name: "redelivered", delivery: amqp.Delivery{Redelivered: true}, expected: "reject"},
+		{
+			name:            "redelivered without delivery count",
+			maxRedeliveries: 3,
+			delivery:        amqp.Delivery{Redelivered: true},
+			expected:        "reject",
+		},
+		{
+			name:            "below max redeliveries",
+			maxRedeliveries: 3,
+			delivery:        amqp.Delivery{Redelivered: true, Headers: amqp.Table{"x-delivery-count": int64(2)}},
+			expected:        "requeue",
+		},
+		{
+			name:            "max redeliveries",
+			maxRedeliveries: 3,
+			delivery:        amqp.Delivery{Redelivered: true, Headers: amqp.Table{"x-delivery-count": int64(3)}},
+			expected:        "reject",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acknowledger := &fakeAcknowledger{}
+			deliveries := make(chan amqp.Delivery, 1)
+			delivery := tt.delivery
+			delivery.Acknowledger = acknowledger
+			delivery.DeliveryTag = 1
+			deliveries <- delivery
+			connector, _ := testAMQPConnector(&fakeAMQPSession{deliveries: deliveries})
+			cfg := config.AMQPHandler{WaitTime: 10 * time.Millisecond, MaxRedeliveries: tt.maxRedeliveries}
+			h := newAMQPConsumer("orders", cfg, false, zerolog.Nop(), connector)
+
+			sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+				return nil, fmt.Errorf("invalid message")
+			}}
+
+			err := runHandlerPipe(context.Background(), nil, []Handler{h, sink}, nil)
+			assert.ErrorContains(t, err, "invalid message")
+			assert.Equal(t, map[uint64]string{1: tt.expected}, acknowledger.outcomes)
+		})
+	}
+}
+
+func TestAMQPConsumer_StopsConsumingAfterRun(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	session := &fakeAMQPSession{deliveries: testAMQPDeliveries(acknowledger, 1, 2, 3)}
+	connector, _ := testAMQPConnector(session)
+	cfg := config.AMQPHandler{WaitTime: 10 * time.Millisecond, MaxMessages: 2}
+	h := newAMQPConsumer("orders", cfg, false, zerolog.Nop(), connector)
+
+	results, err := h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	// the consumer is cancelled and the delivered message that was not emitted is requeued
+	assert.Equal(t, []string{"datapipe-orders-1"}, session.cancelled)
+	assert.Equal(t, map[uint64]string{3: "requeue"}, acknowledger.outcomes)
+
+	// emitted messages are still acknowledged after the run
+	h.Ack(context.Background(), results[0], nil)
+	assert.Equal(t, "ack", acknowledger.outcomes[1])
+}
+
+func TestAMQPConsumer_DryRunRequeuesAfterRun(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	connector, _ := testAMQPConnector(&fakeAMQPSession{deliveries: testAMQPDeliveries(acknowledger, 1, 2)})
+	h := newAMQPConsumer("orders", config.AMQPHandler{WaitTime: 10 * time.Millisecond}, true, zerolog.Nop(), connector)
+
+	err := h.HandleStream(context.Background(), nil, func(results []HandlerResult) error {
+		h.Ack(context.Background(), results[0], nil)
+		// while consuming, a requeued message would be delivered again in the same run
+		acknowledger.mux.Lock()
+		defer acknowledger.mux.Unlock()
+		assert.Empty(t, acknowledger.outcomes)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint64]string{1: "requeue", 2: "requeue"}, acknowledger.outcomes)
+}
+
+func TestAMQPConsumer_NestedBodyFields(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	connector, _ := testAMQPConnector(&fakeAMQPSession{deliveries: testAMQPDeliveries(acknowledger, 7)})
+	consumer := newAMQPConsumer("orders", config.AMQPHandler{WaitTime: 10 * time.Millisecond}, false, zerolog.Nop(),
+		connector)
+	session := &fakeAMQPSession{}
+	publisherConnector, _ := testAMQPConnector(session)
+	publisher := newAMQPPublisher("events", config.AMQPHandler{
+		RoutingKey: "orders.{{ orders.body.id }}",
+		Body:       `{"order": {{ orders.body.id }}}`,
+	}, false, zerolog.Nop(), publisherConnector)
+
+	require.NoError(t, runHandlerPipe(context.Background(), nil, []Handler{consumer, publisher}, nil))
+	require.Len(t, session.published, 1)
+	assert.Equal(t, `{"order": 7}`, string(session.published[0].Body))
+	assert.Equal(t, map[uint64]string{7: "ack"}, acknowledger.outcomes)
+}
+
+func TestAMQPConsumer_Reconnects(t *testing.T) {
+	acknowledger := &fakeAcknowledger{}
+	first := &fakeAMQPSession{deliveries: testAMQPDeliveries(acknowledger, 1)}
+	close(first.deliveries)
+	second := &fakeAMQPSession{deliveries: testAMQPDeliveries(acknowledger, 1, 2)}
+	connector, dials := testAMQPConnector(first, second)
+	h := newAMQPConsumer("orders", config.AMQPHandler{WaitTime: 10 * time.Millisecond}, false, zerolog.Nop(), connector)
+
+	_, err := h.Handle(context.Background(), nil)
+	assert.EqualError(t, err, "AMQP consumer was closed by the broker")
+	assert.True(t, first.closed)
+
+	results, err := h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, 2, *dials)
+
+	// the connection is kept between runs
+	_, err = h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, *dials)
+}
+
+func TestAMQPConnector_GivesUp(t *testing.T) {
+	connector, dials := testAMQPConnector()
+	_, _, err := connector.session(context.Background())
+	assert.EqualError(t, err, "failed to connect to AMQP broker: connection refused")
+	assert.Equal(t, 0, *dials)
+}
+
+func TestAMQPPublisher_Handle(t *testing.T) {
+	cfg := config.AMQPHandler{
+		Exchange:   "events",
+		RoutingKey: "orders.{{ source.region }}",
+		Body:       `{"id": {{ source.id }}, "status": "new"}`,
+		Headers:    map[string]string{"x-source": "{{ source.name }}"},
+		Persistent: true,
+	}
+	data := map[string]string{
+		"source.region": `"eu"`,
+		"source.id":     `42`,
+		"source.name":   `"shop"`,
+	}
+
+	session := &fakeAMQPSession{}
+	connector, _ := testAMQPConnector(session)
+	h := newAMQPPublisher("sink", cfg, false, zerolog.Nop(), connector)
+	results, err := h.Handle(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{}}, results)
+	require.Len(t, session.published, 1)
+	msg := session.published[0]
+	assert.Equal(t, `{"id": 42, "status": "new"}`, string(msg.Body))
+	assert.Equal(t, amqp.Table{"x-source": "shop"}, msg.Headers)
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.Equal(t, amqp.Persistent, msg.DeliveryMode)
+
+	routingKey, _, err := h.createMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, "orders.eu", routingKey)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.ErrorContains(t, err, "failed to replace placeholders in routing key")
+
+	session = &fakeAMQPSession{}
+	connector, _ = testAMQPConnector(session)
+	h = newAMQPPublisher("sink", cfg, true, zerolog.Nop(), connector)
+	_, err = h.Handle(context.Background(), data)
+	require.NoError(t, err)
+	assert.Empty(t, session.published)
+}
+
+func TestAMQPPublisher_RepublishesAfterReconnect(t *testing.T) {
+	cfg := config.AMQPHandler{RoutingKey: "orders", Body: `{}`}
+
+	lost := &fakeAMQPSession{loseConnection: true}
+	session := &fakeAMQPSession{}
+	connector, dials := testAMQPConnector(lost, session)
+	h := newAMQPPublisher("sink", cfg, false, zerolog.Nop(), connector)
+
+	_, err := h.Handle(context.Background(), map[string]string{})
+	require.NoError(t, err)
+	assert.Empty(t, lost.published)
+	assert.Len(t, session.published, 1)
+	assert.Equal(t, 2, *dials)
+
+	// errors of an open connection are not retried
+	session.publishErr = fmt.Errorf("message was nacked by the broker")
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.EqualError(t, err, "failed to publish AMQP message: message was nacked by the broker")
+	assert.Equal(t, 2, *dials)
+}
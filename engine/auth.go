@@ -0,0 +1,243 @@
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+const (
+	defaultOAuth2RefreshBefore = 30 * time.Second
+	defaultHMACHeader          = "X-Signature"
+)
+
+// authenticator adds credentials to a request right before it is sent.
+type authenticator interface {
+	authenticate(ctx context.Context, req *http.Request) error
+}
+
+// tokenAuthenticator is an authenticator with a cached token, which the server may reject before it expires,
+// e.g. because it was revoked.
+type tokenAuthenticator interface {
+	authenticator
+	// invalidate drops the cached token if the request was sent with it, so the next request gets a new one.
+	invalidate(req *http.Request)
+}
+
+// newAuthenticator returns nil if the handler does not authenticate its requests.
+func newAuthenticator(cfg config.Auth, httpClient *http.Client) authenticator {
+	switch cfg.Type {
+	case config.AuthTypeBasic:
+		return &basicAuthenticator{cfg: cfg.Basic}
+	case config.AuthTypeBearer:
+		return &bearerAuthenticator{cfg: cfg.Bearer}
+	case config.AuthTypeOAuth2:
+		return &oauth2Authenticator{cfg: cfg.OAuth2, httpClient: httpClient, now: time.Now}
+	case config.AuthTypeHMAC:
+		return &hmacAuthenticator{cfg: cfg.HMAC, now: time.Now}
+	default:
+		return nil
+	}
+}
+
+type basicAuthenticator struct {
+	cfg config.BasicAuth
+}
+
+func (a *basicAuthenticator) authenticate(_ context.Context, req *http.Request) error {
+	password, err := a.cfg.Password.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read password: %s", err)
+	}
+	req.SetBasicAuth(a.cfg.Username, password)
+	return nil
+}
+
+type bearerAuthenticator struct {
+	cfg config.BearerAuth
+}
+
+func (a *bearerAuthenticator) authenticate(_ context.Context, req *http.Request) error {
+	token, err := a.cfg.Token.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read token: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// oauth2Authenticator gets tokens with the client credentials grant.
+// The token is shared by all records of the handler and refreshed shortly before it expires.
+type oauth2Authenticator struct {
+	cfg        config.OAuth2Auth
+	httpClient *http.Client
+	now        func() time.Time
+
+	mux       sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (a *oauth2Authenticator) authenticate(ctx context.Context, req *http.Request) error {
+	token, err := a.getToken(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get OAuth2 token: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *oauth2Authenticator) getToken(ctx context.Context) (string, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	refreshBefore := defaultOAuth2RefreshBefore
+	if a.cfg.RefreshBefore != 0 {
+		refreshBefore = a.cfg.RefreshBefore
+	}
+	if a.token != "" && (a.expiresAt.IsZero() || a.now().Add(refreshBefore).Before(a.expiresAt)) {
+		return a.token, nil
+	}
+
+	resp, err := a.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	a.token = resp.AccessToken
+	a.expiresAt = time.Time{}
+	if resp.ExpiresIn > 0 {
+		a.expiresAt = a.now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return a.token, nil
+}
+
+func (a *oauth2Authenticator) invalidate(req *http.Request) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	// another request may have replaced the token already, a token is dropped anyway if the request is not known
+	if req == nil || req.Header.Get("Authorization") == "Bearer "+a.token {
+		a.token = ""
+	}
+}
+
+func (a *oauth2Authenticator) requestToken(ctx context.Context) (*oauth2TokenResponse, error) {
+	clientSecret, err := a.cfg.ClientSecret.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client secret: %s", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(a.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.cfg.Scopes, " "))
+	}
+	if a.cfg.AuthStyle == config.OAuth2AuthStyleParams {
+		form.Set("client_id", a.cfg.ClientID)
+		form.Set("client_secret", clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.cfg.AuthStyle != config.OAuth2AuthStyleParams {
+		req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send token request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected token response code: %d", resp.StatusCode)
+	}
+
+	tokenResp := &oauth2TokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(tokenResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %s", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	return tokenResp, nil
+}
+
+// hmacAuthenticator signs "<method>\n<path with query>\n<body>" with the shared secret.
+// If the timestamp header is set, the unix timestamp is prepended to the signed string as "<timestamp>\n".
+type hmacAuthenticator struct {
+	cfg config.HMACAuth
+	now func() time.Time
+}
+
+func (a *hmacAuthenticator) authenticate(_ context.Context, req *http.Request) error {
+	secret, err := a.cfg.Secret.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read HMAC secret: %s", err)
+	}
+
+	body := []byte{}
+	if req.GetBody != nil {
+		bodyReader, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("failed to get request body: %s", err)
+		}
+		body, err = io.ReadAll(bodyReader)
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %s", err)
+		}
+	}
+
+	newHash := sha256.New
+	if a.cfg.Algorithm == "sha512" {
+		newHash = sha512.New
+	}
+	mac := hmac.New(newHash, []byte(secret))
+
+	if a.cfg.TimestampHeader != "" {
+		timestamp := strconv.FormatInt(a.now().Unix(), 10)
+		req.Header.Set(a.cfg.TimestampHeader, timestamp)
+		mac.Write([]byte(timestamp + "\n"))
+	}
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n"))
+	mac.Write(body)
+
+	sum := mac.Sum(nil)
+	signature := hex.EncodeToString(sum)
+	if a.cfg.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+
+	header := defaultHMACHeader
+	if a.cfg.Header != "" {
+		header = a.cfg.Header
+	}
+	req.Header.Set(header, signature)
+	return nil
+}
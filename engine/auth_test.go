@@ -0,0 +1,287 @@
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_HandleWithAuth(t *testing.T) {
+	t.Run("Basic auth", func(t *testing.T) {
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "user", username)
+			assert.Equal(t, "secret-from-env", password)
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"results":[]}`))
+			assert.NoError(t, err)
+		}))
+		defer mockServer.Close()
+
+		t.Setenv("DATAPIPE_TEST_PASSWORD", "secret-from-env")
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method: "GET",
+			URL:    mockServer.URL,
+			Auth: config.Auth{
+				Type: config.AuthTypeBasic,
+				Basic: config.BasicAuth{
+					Username: "user",
+					Password: config.Secret{Env: "DATAPIPE_TEST_PASSWORD"},
+				},
+			},
+		})
+
+		_, err := h.Handle(context.Background(), nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Bearer token from file", func(t *testing.T) {
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer token-from-file", r.Header.Get("Authorization"))
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"results":[]}`))
+			assert.NoError(t, err)
+		}))
+		defer mockServer.Close()
+
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("token-from-file\n"), 0o600))
+
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method: "GET",
+			URL:    mockServer.URL,
+			Auth: config.Auth{
+				Type:   config.AuthTypeBearer,
+				Bearer: config.BearerAuth{Token: config.Secret{File: tokenFile}},
+			},
+		})
+
+		_, err := h.Handle(context.Background(), nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Missing secret", func(t *testing.T) {
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method: "GET",
+			URL:    "http://example.com",
+			Auth: config.Auth{
+				Type:   config.AuthTypeBearer,
+				Bearer: config.BearerAuth{Token: config.Secret{Env: "DATAPIPE_TEST_MISSING_TOKEN"}},
+			},
+		})
+
+		_, err := h.Handle(context.Background(), nil)
+		assert.ErrorContains(t, err, "failed to authenticate HTTP request: failed to read token: "+
+			"env variable 'DATAPIPE_TEST_MISSING_TOKEN' is not set")
+	})
+
+	t.Run("HMAC signature", func(t *testing.T) {
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+
+			mac := hmac.New(sha256.New, []byte("hmac-secret"))
+			mac.Write([]byte(r.Header.Get("X-Timestamp") + "\n" + r.Method + "\n" + r.URL.RequestURI() + "\n"))
+			mac.Write(body)
+			assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Hub-Signature"))
+			assert.NotEmpty(t, r.Header.Get("X-Timestamp"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_, err = w.Write([]byte(`{"results":[]}`))
+			assert.NoError(t, err)
+		}))
+		defer mockServer.Close()
+
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method:      "POST",
+			URL:         mockServer.URL + "/items",
+			QueryParams: map[string]string{"id": "1"},
+			Body:        `{"a":"b"}`,
+			Auth: config.Auth{
+				Type: config.AuthTypeHMAC,
+				HMAC: config.HMACAuth{
+					Secret:          config.Secret{Value: "hmac-secret"},
+					Header:          "X-Hub-Signature",
+					TimestampHeader: "X-Timestamp",
+				},
+			},
+		})
+
+		_, err := h.Handle(context.Background(), nil)
+		assert.NoError(t, err)
+	})
+}
+
+// newTestTokenServer issues 'token-1', 'token-2', ... and counts the token requests.
+func newTestTokenServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	tokenRequests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, tokenRequests.Add(1))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	return server, tokenRequests
+}
+
+func TestOAuth2Authenticator_RejectedToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		validToken    string
+		expectedError string
+		expectedCalls int
+	}{
+		{name: "Retried with a new token", validToken: "token-2", expectedCalls: 2},
+		{
+			name:          "Retried once",
+			validToken:    "token-3",
+			expectedError: "unexpected response code: got 401",
+			expectedCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenServer, tokenRequests := newTestTokenServer(t)
+			var bodies []string
+			apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				if r.Header.Get("Authorization") != "Bearer "+tt.validToken {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"results":[]}`))
+			}))
+			defer apiServer.Close()
+
+			h := newHTTPHandler("test-handler", config.HTTPHandler{
+				Method: "POST",
+				URL:    apiServer.URL,
+				Body:   `{"id":1}`,
+				Auth: config.Auth{
+					Type: config.AuthTypeOAuth2,
+					OAuth2: config.OAuth2Auth{
+						TokenURL:     tokenServer.URL,
+						ClientID:     "client",
+						ClientSecret: config.Secret{Value: "client-secret"},
+					},
+				},
+			})
+
+			_, err := h.Handle(context.Background(), nil)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedCalls, len(bodies))
+			assert.Equal(t, int32(2), tokenRequests.Load())
+			for _, body := range bodies {
+				assert.Equal(t, `{"id":1}`, body)
+			}
+		})
+	}
+}
+
+func TestOAuth2Authenticator(t *testing.T) {
+	tokenRequests := atomic.Int32{}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestNumber := tokenRequests.Add(1)
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "client", clientID)
+		assert.Equal(t, "client-secret", clientSecret)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "read write", r.PostForm.Get("scope"))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, requestNumber)
+		assert.NoError(t, err)
+	}))
+	defer tokenServer.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token-1", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"results":[]}`))
+		assert.NoError(t, err)
+	}))
+	defer apiServer.Close()
+
+	h := newHTTPHandler("test-handler", config.HTTPHandler{
+		Method:      "GET",
+		URL:         apiServer.URL,
+		ParallelRun: true,
+		Auth: config.Auth{
+			Type: config.AuthTypeOAuth2,
+			OAuth2: config.OAuth2Auth{
+				TokenURL:     tokenServer.URL,
+				ClientID:     "client",
+				ClientSecret: config.Secret{Value: "client-secret"},
+				Scopes:       []string{"read", "write"},
+			},
+		},
+	})
+
+	t.Run("Token is cached", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := h.Handle(context.Background(), nil)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, int32(1), tokenRequests.Load())
+	})
+
+	t.Run("Token is refreshed before expiry", func(t *testing.T) {
+		auth := h.auth.(*oauth2Authenticator)
+		auth.now = func() time.Time {
+			return time.Now().Add(time.Hour - 10*time.Second)
+		}
+
+		token, err := auth.getToken(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-2", token)
+		assert.Equal(t, int32(2), tokenRequests.Load())
+	})
+
+	t.Run("Token endpoint error", func(t *testing.T) {
+		failingTokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer failingTokenServer.Close()
+
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method: "GET",
+			URL:    apiServer.URL,
+			Auth: config.Auth{
+				Type: config.AuthTypeOAuth2,
+				OAuth2: config.OAuth2Auth{
+					TokenURL:     failingTokenServer.URL,
+					ClientID:     "client",
+					ClientSecret: config.Secret{Value: "client-secret"},
+				},
+			},
+		})
+
+		_, err := h.Handle(context.Background(), nil)
+		assert.ErrorContains(t, err, "failed to get OAuth2 token: unexpected token response code: 401")
+	})
+}
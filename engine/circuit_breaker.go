@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+)
+
+const defaultCircuitBreakerOpenDuration = 30 * time.Second
+
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "half-open"
+)
+
+// circuitBreaker is shared by all records of an HTTP handler. Once too many requests fail, it opens
+// and rejects requests for the open duration, then lets a few probe requests through (half-open)
+// and closes again if they all succeed.
+type circuitBreaker struct {
+	cfg    config.CircuitBreaker
+	logger zerolog.Logger
+	now    func() time.Time
+
+	mux   sync.Mutex
+	state circuitState
+	// generation changes with each state change, so results of requests started in a previous state are ignored
+	generation          uint64
+	consecutiveFailures int
+	// window holds the outcomes of the latest requests, true is a failure
+	window         []bool
+	windowPos      int
+	openedAt       time.Time
+	probesStarted  int
+	probeSuccesses int
+}
+
+// newCircuitBreaker returns nil if the circuit breaker is disabled.
+func newCircuitBreaker(cfg config.CircuitBreaker, logger zerolog.Logger) *circuitBreaker {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if cfg.WindowSize == 0 {
+		cfg.WindowSize = config.DefaultCircuitBreakerWindowSize
+	}
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = cfg.WindowSize / 2
+	}
+	if cfg.OpenDuration == 0 {
+		cfg.OpenDuration = defaultCircuitBreakerOpenDuration
+	}
+	if cfg.HalfOpenRequests == 0 {
+		cfg.HalfOpenRequests = 1
+	}
+
+	return &circuitBreaker{
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+		state:  circuitClosed,
+		window: make([]bool, 0, cfg.WindowSize),
+	}
+}
+
+// allow reports whether a request can be sent. The returned generation must be passed to record.
+func (cb *circuitBreaker) allow() (uint64, bool) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if cb.state == circuitOpen && cb.now().Sub(cb.openedAt) >= cb.cfg.OpenDuration {
+		cb.setState(circuitHalfOpen)
+	}
+
+	switch cb.state {
+	case circuitOpen:
+		return cb.generation, false
+	case circuitHalfOpen:
+		if cb.probesStarted >= cb.cfg.HalfOpenRequests {
+			return cb.generation, false
+		}
+		cb.probesStarted++
+		return cb.generation, true
+	default:
+		return cb.generation, true
+	}
+}
+
+// record stores the outcome of a request allowed in the given generation.
+func (cb *circuitBreaker) record(generation uint64, failed bool) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if generation != cb.generation {
+		return
+	}
+
+	if cb.state == circuitHalfOpen {
+		if failed {
+			cb.setState(circuitOpen)
+			return
+		}
+		cb.probeSuccesses++
+		if cb.probeSuccesses >= cb.cfg.HalfOpenRequests {
+			cb.setState(circuitClosed)
+		}
+		return
+	}
+
+	if failed {
+		cb.consecutiveFailures++
+	} else {
+		cb.consecutiveFailures = 0
+	}
+
+	if len(cb.window) < cb.cfg.WindowSize {
+		cb.window = append(cb.window, failed)
+	} else {
+		cb.window[cb.windowPos] = failed
+		cb.windowPos = (cb.windowPos + 1) % cb.cfg.WindowSize
+	}
+
+	if cb.shouldTrip() {
+		cb.setState(circuitOpen)
+	}
+}
+
+// cancel gives back the probe of a request allowed in the given generation that was not completed,
+// e.g. because it was cancelled, without recording an outcome.
+func (cb *circuitBreaker) cancel(generation uint64) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if generation == cb.generation && cb.state == circuitHalfOpen {
+		cb.probesStarted--
+	}
+}
+
+func (cb *circuitBreaker) shouldTrip() bool {
+	if cb.cfg.ConsecutiveFailures > 0 && cb.consecutiveFailures >= cb.cfg.ConsecutiveFailures {
+		return true
+	}
+	if cb.cfg.FailureRatio <= 0 || len(cb.window) < cb.cfg.MinRequests {
+		return false
+	}
+
+	return float64(cb.windowFailures())/float64(len(cb.window)) >= cb.cfg.FailureRatio
+}
+
+func (cb *circuitBreaker) windowFailures() int {
+	failures := 0
+	for _, failed := range cb.window {
+		if failed {
+			failures++
+		}
+	}
+	return failures
+}
+
+// setState moves the breaker to the state, the counts that caused the transition are logged.
+func (cb *circuitBreaker) setState(state circuitState) {
+	logEvent := cb.logger.Warn()
+	switch {
+	case state == circuitOpen && cb.state == circuitHalfOpen:
+		logEvent = logEvent.Int("probe_successes", cb.probeSuccesses)
+	case state == circuitOpen:
+		logEvent = logEvent.
+			Int("consecutive_failures", cb.consecutiveFailures).
+			Int("window_failures", cb.windowFailures()).
+			Int("window_requests", len(cb.window))
+	case state == circuitClosed:
+		logEvent = logEvent.Int("probe_successes", cb.probeSuccesses)
+	}
+	if state == circuitOpen {
+		logEvent = logEvent.Dur("open_duration", cb.cfg.OpenDuration)
+	}
+	logEvent.
+		Str("from", string(cb.state)).
+		Str("to", string(state)).
+		Msg("circuit breaker state changed")
+
+	cb.state = state
+	cb.generation++
+	cb.consecutiveFailures = 0
+	cb.window = cb.window[:0]
+	cb.windowPos = 0
+	cb.probesStarted = 0
+	cb.probeSuccesses = 0
+	if state == circuitOpen {
+		cb.openedAt = cb.now()
+	}
+}
+
+func (cb *circuitBreaker) currentState() circuitState {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	return cb.state
+}
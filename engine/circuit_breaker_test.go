@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(config.CircuitBreaker{
+		ConsecutiveFailures: 3,
+		OpenDuration:        time.Minute,
+		HalfOpenRequests:    2,
+	}, zerolog.Nop())
+	cb.now = func() time.Time { return now }
+
+	for _, failed := range []bool{true, true, false, true, true} {
+		generation, ok := cb.allow()
+		require.True(t, ok)
+		cb.record(generation, failed)
+	}
+	assert.Equal(t, circuitClosed, cb.currentState())
+
+	generation, _ := cb.allow()
+	cb.record(generation, true)
+	assert.Equal(t, circuitOpen, cb.currentState())
+
+	_, ok := cb.allow()
+	assert.False(t, ok)
+
+	// results of requests started before the breaker opened are ignored
+	cb.record(generation, false)
+	assert.Equal(t, circuitOpen, cb.currentState())
+
+	now = now.Add(time.Minute)
+	probe1, ok := cb.allow()
+	assert.True(t, ok)
+	assert.Equal(t, circuitHalfOpen, cb.currentState())
+	probe2, ok := cb.allow()
+	assert.True(t, ok)
+	_, ok = cb.allow()
+	assert.False(t, ok)
+
+	cb.record(probe1, false)
+	assert.Equal(t, circuitHalfOpen, cb.currentState())
+	cb.record(probe2, false)
+	assert.Equal(t, circuitClosed, cb.currentState())
+}
+
+func TestCircuitBreaker_HalfOpenFailure(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(config.CircuitBreaker{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Minute,
+	}, zerolog.Nop())
+	cb.now = func() time.Time { return now }
+
+	generation, _ := cb.allow()
+	cb.record(generation, true)
+	assert.Equal(t, circuitOpen, cb.currentState())
+
+	now = now.Add(time.Minute)
+	probe, ok := cb.allow()
+	assert.True(t, ok)
+	cb.record(probe, true)
+	assert.Equal(t, circuitOpen, cb.currentState())
+
+	_, ok = cb.allow()
+	assert.False(t, ok)
+}
+
+func TestCircuitBreaker_FailureRatio(t *testing.T) {
+	cb := newCircuitBreaker(config.CircuitBreaker{
+		FailureRatio: 0.75,
+		WindowSize:   4,
+		MinRequests:  4,
+	}, zerolog.Nop())
+
+	record := func(failed bool) {
+		generation, ok := cb.allow()
+		require.True(t, ok)
+		cb.record(generation, failed)
+	}
+
+	record(true)
+	record(true)
+	record(true)
+	// not enough requests yet
+	assert.Equal(t, circuitClosed, cb.currentState())
+
+	record(false)
+	// [true, true, true, false]
+	assert.Equal(t, circuitOpen, cb.currentState())
+}
+
+func TestCircuitBreaker_FailureRatioWindow(t *testing.T) {
+	cb := newCircuitBreaker(config.CircuitBreaker{
+		FailureRatio: 0.75,
+		WindowSize:   4,
+		MinRequests:  2,
+	}, zerolog.Nop())
+
+	record := func(failed bool) {
+		generation, ok := cb.allow()
+		require.True(t, ok)
+		cb.record(generation, failed)
+	}
+
+	record(false)
+	record(false)
+	record(true)
+	record(true)
+	// [false, false, true, true]
+	assert.Equal(t, circuitClosed, cb.currentState())
+
+	record(true)
+	// the oldest outcome is overwritten: [true, false, true, true]
+	assert.Equal(t, circuitOpen, cb.currentState())
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	assert.Nil(t, newCircuitBreaker(config.CircuitBreaker{OpenDuration: time.Minute}, zerolog.Nop()))
+}
+
+func TestHTTPHandler_CircuitBreaker(t *testing.T) {
+	serverCalls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	logs := &bytes.Buffer{}
+	h, err := newHandler("data-sink", config.Handler{HTTPHandler: config.HTTPHandler{
+		Method:  "POST",
+		URL:     mockServer.URL,
+		Retries: 5,
+		CircuitBreaker: config.CircuitBreaker{
+			ConsecutiveFailures: 2,
+			OpenDuration:        time.Minute,
+		},
+	}}, testHandlerEnv(t, zerolog.New(logs)))
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), nil)
+	assert.EqualError(t, err, "failed to execute HTTP request: circuit breaker is open")
+	assert.Equal(t, 2, serverCalls)
+
+	_, err = h.Handle(context.Background(), nil)
+	assert.EqualError(t, err, "failed to execute HTTP request: circuit breaker is open")
+	assert.Equal(t, 2, serverCalls)
+
+	assert.Contains(t, logs.String(), `"level":"warn","handler":"data-sink","consecutive_failures":2,`+
+		`"window_failures":2,"window_requests":2,"open_duration":60000,`+
+		`"from":"closed","to":"open","message":"circuit breaker state changed"`)
+}
+
+func TestHTTPHandler_CircuitBreakerIgnoresClientErrors(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mockServer.Close()
+
+	h, err := newHandler("data-sink", config.Handler{HTTPHandler: config.HTTPHandler{
+		Method: "POST",
+		URL:    mockServer.URL,
+		CircuitBreaker: config.CircuitBreaker{
+			ConsecutiveFailures: 1,
+			OpenDuration:        time.Minute,
+		},
+	}}, testHandlerEnv(t, zerolog.Nop()))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = h.Handle(context.Background(), nil)
+		assert.ErrorContains(t, err, "unexpected response code: got 404")
+	}
+	assert.Equal(t, circuitClosed, h.(*httpHandler).breaker.currentState())
+}
+
+func TestIsCircuitBreakerFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "Success", err: nil, expected: false},
+		{name: "ServerError", err: &requestError{statusCode: http.StatusBadGateway}, expected: true},
+		{name: "ClientError", err: &requestError{statusCode: http.StatusBadRequest}, expected: false},
+		{name: "TooManyRequests", err: &requestError{statusCode: http.StatusTooManyRequests}, expected: false},
+		{name: "Timeout", err: &requestError{class: config.RetryableErrorTimeout}, expected: true},
+		{name: "TransportError", err: &requestError{msg: "failed to send HTTP request"}, expected: true},
+		{name: "InvalidResponse", err: &requestError{class: config.RetryableErrorInvalidResponse}, expected: false},
+		{name: "Permanent", err: &requestError{permanent: true}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isCircuitBreakerFailure(tt.err))
+		})
+	}
+}
+
+func TestHTTPHandler_CircuitBreakerIgnoresUnsentProbes(t *testing.T) {
+	serverCalls := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverCalls++
+	}))
+	defer mockServer.Close()
+
+	now := time.Now()
+	h := newHTTPHandler("data-sink", config.HTTPHandler{Method: "POST", URL: mockServer.URL})
+	h.breaker = newCircuitBreaker(config.CircuitBreaker{ConsecutiveFailures: 1, OpenDuration: time.Minute}, zerolog.Nop())
+	h.breaker.now = func() time.Time { return now }
+	l, err := newLimiter(config.LimiterGroup{MaxConcurrency: 1})
+	require.NoError(t, err)
+	h.limiters = []*limiter{l}
+
+	generation, _ := h.breaker.allow()
+	h.breaker.record(generation, true)
+	now = now.Add(time.Minute)
+
+	// the probe waits for the limiter until it's cancelled
+	require.NoError(t, l.acquire(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = h.Handle(ctx, nil)
+	assert.ErrorContains(t, err, "failed to wait for rate limiter")
+	assert.Equal(t, circuitOpen, h.breaker.currentState())
+	l.release()
+	h.limiters = nil
+
+	// the probe is cancelled while it's being sent
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = h.Handle(ctx, nil)
+	assert.ErrorContains(t, err, "context canceled")
+	assert.Equal(t, circuitHalfOpen, h.breaker.currentState())
+
+	_, err = h.Handle(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, serverCalls)
+	assert.Equal(t, circuitClosed, h.breaker.currentState())
+}
+
+func TestDataPipe_LogCircuitBreakers(t *testing.T) {
+	open := newHTTPHandler("data-sink", config.HTTPHandler{})
+	open.breaker = newCircuitBreaker(config.CircuitBreaker{ConsecutiveFailures: 1}, zerolog.Nop())
+	generation, _ := open.breaker.allow()
+	open.breaker.record(generation, true)
+	closed := newHTTPHandler("data-enricher", config.HTTPHandler{})
+	closed.breaker = newCircuitBreaker(config.CircuitBreaker{ConsecutiveFailures: 1}, zerolog.Nop())
+
+	logs := &bytes.Buffer{}
+	dp := &dataPipe{
+		handlers: []Handler{newHTTPHandler("data-source", config.HTTPHandler{}), closed, open},
+		logger:   zerolog.New(logs),
+	}
+	dp.logJobResult(nil)
+
+	assert.NotContains(t, logs.String(), `"handler":"data-source"`)
+	assert.Contains(t, logs.String(),
+		`{"level":"info","handler":"data-enricher","state":"closed","message":"circuit breaker state"}`)
+	assert.Contains(t, logs.String(),
+		`{"level":"warn","handler":"data-sink","state":"open","message":"circuit breaker state"}`)
+}
@@ -2,8 +2,12 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jaxmef/datapipe/config"
@@ -11,14 +15,21 @@ import (
 	"github.com/rs/zerolog"
 )
 
+const defaultRestartDelay = 5 * time.Second
+
 type DataPipe interface {
+	// Run runs jobs on schedule until ctx is cancelled.
 	Run(ctx context.Context)
+	// RunOnce runs a single job and returns its error.
+	RunOnce(ctx context.Context) error
 }
 
 type dataPipe struct {
 	cfg      config.Engine
 	handlers []Handler
 	logger   zerolog.Logger
+
+	records recordTracker
 }
 
 func NewDataPipe(cfg config.Config, logger zerolog.Logger) (DataPipe, error) {
@@ -26,12 +37,24 @@ func NewDataPipe(cfg config.Config, logger zerolog.Logger) (DataPipe, error) {
 		cfg:    cfg.Engine,
 		logger: logger,
 	}
+	dp.records.limit(cfg.Engine.MaxInFlight)
 
 	if cfg.Handlers == nil || len(*cfg.Handlers) == 0 {
 		return nil, fmt.Errorf("no handlers defined")
 	}
-	for _, handlerItem := range *cfg.Handlers {
-		h, err := newHandler(handlerItem.Name, handlerItem.Handler)
+	env, err := newHandlerEnv(logger, cfg.Engine.LimiterGroups)
+	if err != nil {
+		return nil, err
+	}
+	env.dryRun = cfg.Engine.DryRun
+	for i, handlerItem := range *cfg.Handlers {
+		var h Handler
+		var err error
+		if cfg.Engine.DryRun && isRecordedInDryRun(i, handlerItem.Handler) {
+			h, err = newDryRunHandler(handlerItem.Name, handlerItem.Handler.HTTPHandler, logger)
+		} else {
+			h, err = newHandler(handlerItem.Name, handlerItem.Handler, env)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to create '%s' handler: %s", handlerItem.Name, err)
 		}
@@ -41,13 +64,58 @@ func NewDataPipe(cfg config.Config, logger zerolog.Logger) (DataPipe, error) {
 	return dp, nil
 }
 
+// NewHandlers creates the handlers of the config in the chain order.
+// If transport is not nil, it provides the transport of each handler that sends HTTP requests, which allows mocking
+// HTTP calls. It's only called for those handlers.
+func NewHandlers(cfg config.Config, transport func(handlerName string) http.RoundTripper) ([]Handler, error) {
+	if cfg.Handlers == nil || len(*cfg.Handlers) == 0 {
+		return nil, fmt.Errorf("no handlers defined")
+	}
+
+	env, err := newHandlerEnv(zerolog.Nop(), cfg.Engine.LimiterGroups)
+	if err != nil {
+		return nil, err
+	}
+	handlers := make([]Handler, 0, len(*cfg.Handlers))
+	for _, handlerItem := range *cfg.Handlers {
+		h, err := newHandler(handlerItem.Name, handlerItem.Handler, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create '%s' handler: %s", handlerItem.Name, err)
+		}
+		if th, ok := h.(transportHandler); ok && transport != nil {
+			th.setTransport(transport(handlerItem.Name))
+		}
+		handlers = append(handlers, h)
+	}
+
+	return handlers, nil
+}
+
+// RunHandlers runs a single job through the handler chain.
+func RunHandlers(ctx context.Context, handlers []Handler) error {
+	err := runHandlerPipe(ctx, nil, handlers, nil)
+	if flushErr := flushHandlers(ctx, handlers); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	return err
+}
+
 func (dp *dataPipe) Run(ctx context.Context) {
+	if dp.cfg.Mode == config.EngineModeStream {
+		dp.runStream(ctx)
+		return
+	}
+
+	// jobs get their own context, so a shutdown request does not abort records that are already in flight
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
 	if !dp.cfg.DisableRunOnStart {
-		err := dp.runJob(ctx)
-		if err != nil {
-			dp.logger.Error().Msgf("failed to run job: %s", err)
-		} else {
-			dp.logger.Info().Msg("job completed successfully")
+		stopped, err := dp.runJobUntilShutdown(ctx, jobCtx, cancelJobs)
+		dp.logJobResult(err)
+		if stopped {
+			dp.logger.Info().Msg("data pipe stopped")
+			return
 		}
 	}
 
@@ -63,57 +131,222 @@ func (dp *dataPipe) Run(ctx context.Context) {
 			dp.logger.Info().Msg("data pipe stopped")
 			return
 		case <-t.C:
-			err := dp.runJob(ctx)
-			if err != nil {
-				dp.logger.Error().Err(err).Msg("failed to run job")
-			} else {
-				dp.logger.Info().Msg("job completed successfully")
+			stopped, err := dp.runJobUntilShutdown(ctx, jobCtx, cancelJobs)
+			dp.logJobResult(err)
+			if stopped {
+				dp.logger.Info().Msg("data pipe stopped")
+				return
 			}
 			t.Reset(interval)
 		}
 	}
 }
 
+// runStream restarts the source handler as soon as it returns, so records flow through the chain as they arrive.
+// Failed records are logged one by one, and a failed source is restarted after the restart delay.
+func (dp *dataPipe) runStream(ctx context.Context) {
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	dp.records.onFailure(func(err error) {
+		dp.logger.Error().Err(err).Msg("failed to process record")
+	})
+	restartDelay := dp.cfg.RestartDelay
+	if restartDelay == 0 {
+		restartDelay = defaultRestartDelay
+	}
+
+	dp.logger.Info().Msg("data pipe started in stream mode")
+	for {
+		stopped, err := dp.runJobUntilShutdown(ctx, jobCtx, cancelJobs)
+		if stopped {
+			dp.logger.Info().Msg("data pipe stopped")
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		dp.logger.Error().Err(err).Dur("restart_delay", restartDelay).Msg("source failed, restarting")
+		select {
+		case <-ctx.Done():
+			dp.logger.Info().Msg("data pipe stopped")
+			return
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+func (dp *dataPipe) RunOnce(ctx context.Context) error {
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	_, err := dp.runJobUntilShutdown(ctx, jobCtx, cancelJobs)
+	dp.logJobResult(err)
+	return err
+}
+
+// runJobUntilShutdown runs a single job and reports whether a shutdown was requested while it was running.
+// If ctx is cancelled while the job is running, in-flight records are given up to
+// the configured shutdown timeout to finish before jobCtx is cancelled.
+func (dp *dataPipe) runJobUntilShutdown(
+	ctx, jobCtx context.Context, cancelJobs context.CancelFunc,
+) (bool, error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- dp.runJob(jobCtx)
+	}()
+
+	select {
+	case err := <-done:
+		return false, err
+	case <-ctx.Done():
+	}
+
+	// the source emits no new records, the ones it already emitted are given the shutdown timeout
+	dp.records.stop()
+	dp.logger.Info().
+		Int64("in_flight_records", dp.records.count()).
+		Dur("shutdown_timeout", dp.cfg.ShutdownTimeout).
+		Msg("shutting down, waiting for in-flight records to finish")
+
+	timer := time.NewTimer(dp.cfg.ShutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return true, err
+	case <-timer.C:
+		abandoned := dp.records.count()
+		cancelJobs()
+		err := <-done
+		dp.logger.Warn().
+			Int64("abandoned_records", abandoned).
+			Msg("shutdown timeout exceeded, in-flight records were abandoned")
+		return true, err
+	}
+}
+
+func (dp *dataPipe) logJobResult(err error) {
+	if err != nil {
+		dp.logger.Error().Err(err).Msg("failed to run job")
+	} else {
+		dp.logger.Info().Msg("job completed successfully")
+	}
+	dp.logCircuitBreakers()
+}
+
+// logCircuitBreakers logs the state of each circuit breaker at the end of a job,
+// breakers that are not closed are logged as warnings.
+func (dp *dataPipe) logCircuitBreakers() {
+	for _, h := range dp.handlers {
+		bh, ok := h.(circuitBreakerHandler)
+		if !ok {
+			continue
+		}
+		state, ok := bh.circuitBreakerState()
+		if !ok {
+			continue
+		}
+		logEvent := dp.logger.Info()
+		if state != circuitClosed {
+			logEvent = dp.logger.Warn()
+		}
+		logEvent.
+			Str("handler", h.Name()).
+			Str("state", string(state)).
+			Msg("circuit breaker state")
+	}
+}
+
 func (dp *dataPipe) runJob(ctx context.Context) error {
-	err := runHandlerPipe(ctx, nil, dp.handlers)
+	err := runHandlerPipe(ctx, nil, dp.handlers, &dp.records)
 	if err != nil {
-		return fmt.Errorf("failed to run handler pipe: %s", err)
+		err = fmt.Errorf("failed to run handler pipe: %s", err)
+	}
+	// the records of a failed run are flushed too, they were processed successfully
+	if flushErr := flushHandlers(ctx, dp.handlers); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// flushHandlers lets the handlers process the records they collected during the run.
+func flushHandlers(ctx context.Context, handlers []Handler) error {
+	var errs []string
+	for _, h := range handlers {
+		fh, ok := h.(flushHandler)
+		if !ok {
+			continue
+		}
+		if err := fh.Flush(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to flush handler %s: %s", h.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
 	}
 	return nil
 }
 
-func runHandlerPipe(ctx context.Context, data map[string]string, handlers []Handler) error {
+// runHandlerPipe runs the first handler and passes each of its results through the rest of the chain.
+// If records is not nil, it tracks the results of the first handler while they are being processed.
+func runHandlerPipe(ctx context.Context, data map[string]string, handlers []Handler, records *recordTracker) error {
 	if len(handlers) == 0 {
 		return nil
 	}
 
-	results, err := handlers[0].Handle(ctx, data)
-	if err != nil {
-		return fmt.Errorf("failed to run handler %s: %s", handlers[0].Name(), err)
-	}
-
 	wg := sync.WaitGroup{}
-	errsChan := make(chan error, len(results))
-	for i := 0; i < len(results); i++ {
-		newData := copyMap(data)
-		for k, v := range results[i] {
-			newData[handlers[0].Name()+"."+k] = string(v)
-		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			err := runHandlerPipe(ctx, newData, handlers[1:])
-			if err != nil {
-				errsChan <- err
+	errsMux := sync.Mutex{}
+	var errs []error
+	emit := func(results []HandlerResult) error {
+		for i := 0; i < len(results); i++ {
+			result := results[i]
+			newData := copyMap(data)
+			for k, v := range result {
+				newData[handlers[0].Name()+"."+k] = string(v)
+			}
+			if err := records.start(ctx); err != nil {
+				return err
 			}
-		}()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer records.done()
+				err := runHandlerPipe(ctx, newData, handlers[1:], nil)
+				if ah, ok := handlers[0].(ackHandler); ok {
+					ah.Ack(ctx, result, err)
+				}
+				if err != nil && !records.reportFailure(err) {
+					errsMux.Lock()
+					errs = append(errs, err)
+					errsMux.Unlock()
+				}
+			}()
+		}
+		return nil
 	}
 
+	var err error
+	if sh, ok := handlers[0].(streamHandler); ok {
+		err = sh.HandleStream(ctx, data, emit)
+	} else {
+		var results []HandlerResult
+		results, err = handlers[0].Handle(ctx, data)
+		if err == nil {
+			err = emit(results)
+		}
+	}
+
+	// results emitted before a failure are still processed by the rest of the chain
 	wg.Wait()
-	close(errsChan)
+
+	if err != nil && !errors.Is(err, errRecordsStopped) {
+		return fmt.Errorf("failed to run handler %s: %s", handlers[0].Name(), err)
+	}
 
 	errMsg := ""
-	for err := range errsChan {
+	for _, err := range errs {
 		if err != nil {
 			errMsg += fmt.Sprintf("%v; ", err.Error())
 		}
@@ -126,6 +359,98 @@ func runHandlerPipe(ctx context.Context, data map[string]string, handlers []Hand
 	return nil
 }
 
+// errRecordsStopped is returned to the source handler when it emits records after a shutdown was requested.
+// nolint: gochecknoglobals
+var errRecordsStopped = errors.New("records stopped")
+
+// recordTracker counts records that are still flowing through the handler chain.
+// It optionally limits the number of records in flight and stops accepting new records on shutdown.
+type recordTracker struct {
+	inFlight atomic.Int64
+	// slots limits the records in flight if not nil
+	slots chan struct{}
+	// failures reports the error of each failed record instead of failing the job if not nil
+	failures func(err error)
+
+	mux      sync.Mutex
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (rt *recordTracker) limit(maxInFlight int) {
+	if maxInFlight > 0 {
+		rt.slots = make(chan struct{}, maxInFlight)
+	}
+}
+
+func (rt *recordTracker) onFailure(failures func(err error)) {
+	rt.failures = failures
+}
+
+// start registers a new record, waiting for a free slot if the records in flight are limited.
+func (rt *recordTracker) start(ctx context.Context) error {
+	if rt == nil {
+		return nil
+	}
+	stopped := rt.stoppedChan()
+	select {
+	case <-stopped:
+		return errRecordsStopped
+	default:
+	}
+	if rt.slots != nil {
+		select {
+		case rt.slots <- struct{}{}:
+		case <-stopped:
+			return errRecordsStopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	rt.inFlight.Add(1)
+	return nil
+}
+
+func (rt *recordTracker) done() {
+	if rt == nil {
+		return
+	}
+	rt.inFlight.Add(-1)
+	if rt.slots != nil {
+		<-rt.slots
+	}
+}
+
+// reportFailure passes the error of a failed record to the failure callback, if there is one.
+func (rt *recordTracker) reportFailure(err error) bool {
+	if rt == nil || rt.failures == nil {
+		return false
+	}
+	rt.failures(err)
+	return true
+}
+
+// stop makes the source handler stop emitting records.
+func (rt *recordTracker) stop() {
+	stopped := rt.stoppedChan()
+	rt.stopOnce.Do(func() {
+		close(stopped)
+	})
+}
+
+func (rt *recordTracker) stoppedChan() chan struct{} {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	if rt.stopped == nil {
+		rt.stopped = make(chan struct{})
+	}
+	return rt.stopped
+}
+
+func (rt *recordTracker) count() int64 {
+	return rt.inFlight.Load()
+}
+
 func copyMap(originalMap map[string]string) map[string]string {
 	newMap := make(map[string]string, len(originalMap))
 
@@ -62,10 +62,10 @@ func TestNewDataPipe_Success(t *testing.T) {
 }
 
 func TestDataPipeRun_RunOnStart(t *testing.T) {
-	handlerCalls := 0
+	handlerCalls := atomic.Int32{}
 	mockHandler := &mockHandler{
 		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
-			handlerCalls++
+			handlerCalls.Add(1)
 			return []HandlerResult{{"key": json.RawMessage("value")}}, nil
 		},
 	}
@@ -99,7 +99,7 @@ func TestDataPipeRun_RunOnStart(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 	cancel()
 
-	assert.Equal(t, 1, handlerCalls)
+	assert.Equal(t, int32(1), handlerCalls.Load())
 }
 
 func TestDataPipeRun_CancelContext(t *testing.T) {
@@ -190,27 +190,27 @@ func TestRunHandlerPipe_Parallel(t *testing.T) {
 		},
 	}
 
-	handler2Calls := 0
+	handler2Calls := atomic.Int32{}
 	runningHandlers2 := atomic.Int32{}
-	parallelRunDetected := false
+	parallelRunDetected := atomic.Bool{}
 	timer := time.NewTimer(100 * time.Millisecond)
 	handler2 := &mockHandler{
 		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
 			runningHandlers2.Add(1)
 			defer runningHandlers2.Add(-1)
 
-			handler2Calls++
+			handler2Calls.Add(1)
 
 			for {
 				select {
 				case <-timer.C:
-					if !parallelRunDetected {
+					if !parallelRunDetected.Load() {
 						require.Fail(t, "no parallel run detected")
 					}
 					return nil, nil
 				default:
 					if runningHandlers2.Load() > 1 {
-						parallelRunDetected = true
+						parallelRunDetected.Store(true)
 						return nil, nil
 					}
 				}
@@ -218,11 +218,282 @@ func TestRunHandlerPipe_Parallel(t *testing.T) {
 		},
 	}
 
-	err := runHandlerPipe(context.Background(), map[string]string{}, []Handler{handler1, handler2})
+	err := runHandlerPipe(context.Background(), map[string]string{}, []Handler{handler1, handler2}, nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, 1, handler1Calls)
-	assert.Equal(t, 2, handler2Calls)
+	assert.Equal(t, int32(2), handler2Calls.Load())
 	assert.Equal(t, int32(0), runningHandlers2.Load())
-	assert.True(t, parallelRunDetected)
+	assert.True(t, parallelRunDetected.Load())
+}
+
+func TestDataPipeRun_GracefulShutdown(t *testing.T) {
+	handlerStarted := make(chan struct{})
+	var handlerErr error
+	source := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			return []HandlerResult{{"key": json.RawMessage("value")}}, nil
+		},
+	}
+	sink := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			close(handlerStarted)
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				handlerErr = ctx.Err()
+			}
+			return nil, nil
+		},
+	}
+
+	dp := &dataPipe{
+		cfg: config.Engine{
+			Interval:        time.Minute,
+			ShutdownTimeout: time.Second,
+		},
+		handlers: []Handler{source, sink},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runFinished := make(chan struct{})
+	go func() {
+		dp.Run(ctx)
+		close(runFinished)
+	}()
+
+	<-handlerStarted
+	cancel()
+	<-runFinished
+
+	assert.NoError(t, handlerErr)
+	assert.Equal(t, int64(0), dp.records.count())
+}
+
+func TestDataPipeRun_ShutdownTimeout(t *testing.T) {
+	handlerStarted := make(chan struct{})
+	var handlerErr error
+	source := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			return []HandlerResult{{"key": json.RawMessage("value")}}, nil
+		},
+	}
+	sink := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			close(handlerStarted)
+			<-ctx.Done()
+			handlerErr = ctx.Err()
+			return nil, handlerErr
+		},
+	}
+
+	dp := &dataPipe{
+		cfg: config.Engine{
+			Interval:        time.Minute,
+			ShutdownTimeout: 50 * time.Millisecond,
+		},
+		handlers: []Handler{source, sink},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runFinished := make(chan struct{})
+	go func() {
+		dp.Run(ctx)
+		close(runFinished)
+	}()
+
+	<-handlerStarted
+	cancel()
+
+	select {
+	case <-runFinished:
+	case <-time.After(time.Second):
+		require.Fail(t, "data pipe did not stop after the shutdown timeout")
+	}
+	assert.ErrorIs(t, handlerErr, context.Canceled)
+}
+
+func TestRunHandlerPipe_MaxInFlight(t *testing.T) {
+	source := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			return make([]HandlerResult, 10), nil
+		},
+	}
+	var running, maxRunning atomic.Int32
+	sink := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				observed := maxRunning.Load()
+				if current <= observed || maxRunning.CompareAndSwap(observed, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return nil, nil
+		},
+	}
+
+	records := &recordTracker{}
+	records.limit(2)
+	err := runHandlerPipe(context.Background(), nil, []Handler{source, sink}, records)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), maxRunning.Load())
+	assert.Equal(t, int64(0), records.count())
+}
+
+// mockStreamHandler emits the records sent to its channel, and returns the errors sent to errs once the channel
+// is drained.
+type mockStreamHandler struct {
+	records chan HandlerResult
+	errs    chan error
+}
+
+func (m *mockStreamHandler) Name() string {
+	return "source"
+}
+
+func (m *mockStreamHandler) Handle(context.Context, map[string]string) ([]HandlerResult, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (m *mockStreamHandler) HandleStream(
+	ctx context.Context, _ map[string]string, emit func(results []HandlerResult) error,
+) error {
+	for {
+		select {
+		case record := <-m.records:
+			if err := emit([]HandlerResult{record}); err != nil {
+				return err
+			}
+		case err := <-m.errs:
+			return err
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		}
+	}
+}
+
+func TestDataPipeRun_StreamMode(t *testing.T) {
+	source := &mockStreamHandler{records: make(chan HandlerResult), errs: make(chan error, 1)}
+	processed := make(chan string, 10)
+	sink := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			processed <- data["source.id"]
+			if data["source.id"] == "2" {
+				return nil, fmt.Errorf("record failed")
+			}
+			return nil, nil
+		},
+	}
+
+	dp := &dataPipe{
+		cfg: config.Engine{
+			Mode:            config.EngineModeStream,
+			RestartDelay:    time.Millisecond,
+			ShutdownTimeout: time.Second,
+		},
+		handlers: []Handler{source, sink},
+		logger:   zerolog.Nop(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runFinished := make(chan struct{})
+	go func() {
+		dp.Run(ctx)
+		close(runFinished)
+	}()
+
+	// records flow down the chain as they arrive, a failed record does not stop the stream
+	for _, id := range []string{"1", "2", "3"} {
+		source.records <- HandlerResult{"id": json.RawMessage(id)}
+		assert.Equal(t, id, <-processed)
+	}
+
+	// the source is restarted after it failed
+	source.errs <- fmt.Errorf("connection lost")
+	source.records <- HandlerResult{"id": json.RawMessage("4")}
+	assert.Equal(t, "4", <-processed)
+
+	cancel()
+	select {
+	case <-runFinished:
+	case <-time.After(time.Second):
+		require.Fail(t, "data pipe did not stop")
+	}
+	assert.Equal(t, int64(0), dp.records.count())
+}
+
+func TestDataPipeRun_ScheduleModeStopsIntake(t *testing.T) {
+	source := &mockStreamHandler{records: make(chan HandlerResult), errs: make(chan error, 1)}
+	processed := make(chan struct{}, 1)
+	sink := &mockHandler{
+		handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+			select {
+			case processed <- struct{}{}:
+			default:
+			}
+			return nil, nil
+		},
+	}
+
+	dp := &dataPipe{
+		cfg: config.Engine{
+			Interval:        time.Minute,
+			ShutdownTimeout: 5 * time.Second,
+		},
+		handlers: []Handler{source, sink},
+		logger:   zerolog.Nop(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runFinished := make(chan struct{})
+	go func() {
+		dp.Run(ctx)
+		close(runFinished)
+	}()
+	// the source keeps emitting records until it's stopped
+	go func() {
+		for {
+			select {
+			case source.records <- HandlerResult{"id": json.RawMessage("1")}:
+			case <-runFinished:
+				return
+			}
+		}
+	}()
+
+	<-processed
+	cancel()
+	select {
+	case <-runFinished:
+	case <-time.After(time.Second):
+		require.Fail(t, "source kept emitting records after the shutdown was requested")
+	}
+}
+
+func TestRecordTracker_Stop(t *testing.T) {
+	records := &recordTracker{}
+	records.limit(1)
+	require.NoError(t, records.start(context.Background()))
+
+	started := make(chan error)
+	go func() {
+		started <- records.start(context.Background())
+	}()
+	records.stop()
+	assert.ErrorIs(t, <-started, errRecordsStopped)
+	assert.ErrorIs(t, records.start(context.Background()), errRecordsStopped)
+
+	records.done()
+	assert.Equal(t, int64(0), records.count())
 }
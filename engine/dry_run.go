@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+)
+
+// dryRunHandler replaces an HTTP handler in dry-run mode.
+// It renders the request the same way the HTTP handler does, logs it instead of sending it
+// and returns the canned response, so the rest of the chain can still be exercised.
+type dryRunHandler struct {
+	http *httpHandler
+	// response is decoded like a response of the HTTP handler, a single empty result is returned if it's not set
+	response string
+	logger   zerolog.Logger
+}
+
+func newDryRunHandler(name string, cfg config.HTTPHandler, logger zerolog.Logger) (*dryRunHandler, error) {
+	response := ""
+	switch {
+	case cfg.DryRun.Response != "":
+		response = cfg.DryRun.Response
+	case cfg.DryRun.ResponseFile != "":
+		rawResponse, err := os.ReadFile(cfg.DryRun.ResponseFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dry run response file: %s", err)
+		}
+		response = string(rawResponse)
+	}
+
+	h := &dryRunHandler{
+		http:     newHTTPHandler(name, cfg),
+		response: response,
+		logger:   logger.With().Str("handler", name).Logger(),
+	}
+	// validate the canned response once, so a broken one fails on start instead of on each record
+	if _, err := h.cannedResults(); err != nil {
+		return nil, fmt.Errorf("invalid dry run response: %s", err)
+	}
+	return h, nil
+}
+
+func (h *dryRunHandler) Name() string {
+	return h.http.Name()
+}
+
+func (h *dryRunHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	data = copyMap(data)
+	data[config.RetryAttemptPlaceholder] = "1"
+
+	req, err := h.http.createRequest(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %s", err)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTTP request body: %s", err)
+	}
+
+	h.logger.Info().
+		Str("method", req.Method).
+		Str("url", req.URL.String()).
+		Interface("headers", req.Header).
+		Str("body", string(body)).
+		Msg("dry run: request recorded")
+
+	return h.cannedResults()
+}
+
+// cannedResults decodes the canned response with the response config of the HTTP handler, as a 200 response.
+func (h *dryRunHandler) cannedResults() ([]HandlerResult, error) {
+	if h.response == "" {
+		return []HandlerResult{{}}, nil
+	}
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	return decodeResponse(resp, []byte(h.response), h.http.cfg.Response)
+}
+
+// isRecordedInDryRun reports whether the handler at the given position of the chain
+// is replaced by a dryRunHandler in dry-run mode.
+func isRecordedInDryRun(position int, cfg config.Handler) bool {
+	if cfg.Type != "" && cfg.Type != config.HandlerTypeHTTP {
+		return false
+	}
+
+	switch cfg.HTTPHandler.DryRun.Mode {
+	case config.DryRunModeExecute:
+		return false
+	case config.DryRunModeRecord:
+		return true
+	default:
+		// the first handler is the data source, other handlers are expected to have side effects
+		return position > 0
+	}
+}
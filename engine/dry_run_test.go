@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunHandler_Handle(t *testing.T) {
+	t.Run("Request is logged and canned response is returned", func(t *testing.T) {
+		serverCalls := 0
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serverCalls++
+		}))
+		defer mockServer.Close()
+
+		logs := &bytes.Buffer{}
+		h, err := newDryRunHandler("data-sink", config.HTTPHandler{
+			Method:  "POST",
+			URL:     mockServer.URL + "/items/{{ data-source.id }}",
+			Body:    `{"title":{{ data-source.title }}}`,
+			Headers: map[string]string{"Content-Type": "application/json"},
+			DryRun: config.DryRun{
+				Response: `{"results":[{"id":"42"}]}`,
+			},
+		}, zerolog.New(logs))
+		require.NoError(t, err)
+
+		result, err := h.Handle(context.Background(), map[string]string{
+			"data-source.id":    "1",
+			"data-source.title": `"test"`,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, serverCalls)
+		assert.Equal(t, []HandlerResult{{"id": []byte(`"42"`)}}, result)
+
+		assert.Contains(t, logs.String(), `"handler":"data-sink"`)
+		assert.Contains(t, logs.String(), `"method":"POST"`)
+		assert.Contains(t, logs.String(), `"url":"`+mockServer.URL+`/items/1"`)
+		assert.Contains(t, logs.String(), `"headers":{"Content-Type":["application/json"]}`)
+		assert.Contains(t, logs.String(), `"body":"{\"title\":\"test\"}"`)
+	})
+
+	t.Run("Default response", func(t *testing.T) {
+		h, err := newDryRunHandler("data-sink", config.HTTPHandler{
+			Method: "POST",
+			URL:    "http://example.com",
+		}, zerolog.Nop())
+		require.NoError(t, err)
+
+		result, err := h.Handle(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []HandlerResult{{}}, result)
+	})
+
+	t.Run("Response file", func(t *testing.T) {
+		responseFile := filepath.Join(t.TempDir(), "response.json")
+		require.NoError(t, os.WriteFile(responseFile, []byte(`{"results":[{"a":"b"},{"c":"d"}]}`), 0o600))
+
+		h, err := newDryRunHandler("data-source", config.HTTPHandler{
+			Method: "GET",
+			URL:    "http://example.com",
+			DryRun: config.DryRun{ResponseFile: responseFile},
+		}, zerolog.Nop())
+		require.NoError(t, err)
+
+		result, err := h.Handle(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(result))
+	})
+
+	t.Run("Response decoded with the response config", func(t *testing.T) {
+		h, err := newDryRunHandler("data-source", config.HTTPHandler{
+			Method:   "GET",
+			URL:      "http://example.com",
+			Response: config.Response{ResultsPath: "data.items"},
+			DryRun:   config.DryRun{Response: `{"data":{"items":[{"id":1},{"id":2}]}}`},
+		}, zerolog.Nop())
+		require.NoError(t, err)
+
+		result, err := h.Handle(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []HandlerResult{
+			{"id": json.RawMessage(`1`)},
+			{"id": json.RawMessage(`2`)},
+		}, result)
+	})
+
+	t.Run("Invalid response", func(t *testing.T) {
+		_, err := newDryRunHandler("data-sink", config.HTTPHandler{
+			Method: "POST",
+			URL:    "http://example.com",
+			DryRun: config.DryRun{Response: "invalid json"},
+		}, zerolog.Nop())
+		assert.ErrorContains(t, err, "invalid dry run response")
+	})
+
+	t.Run("Failed to replace placeholders", func(t *testing.T) {
+		h, err := newDryRunHandler("data-sink", config.HTTPHandler{
+			Method: "POST",
+			URL:    "http://example.com/{{ data-source.id }}",
+		}, zerolog.Nop())
+		require.NoError(t, err)
+
+		_, err = h.Handle(context.Background(), nil)
+		assert.ErrorContains(t, err, "failed to replace placeholders in URL")
+	})
+}
+
+func TestNewDataPipe_DryRun(t *testing.T) {
+	httpHandlerCfg := func(mode config.DryRunMode) config.Handler {
+		return config.Handler{
+			HTTPHandler: config.HTTPHandler{
+				Method: "GET",
+				URL:    "http://example.com",
+				DryRun: config.DryRun{Mode: mode},
+			},
+		}
+	}
+
+	cfg := config.Config{
+		Engine: config.Engine{DryRun: true},
+		Handlers: &config.HandlerMap{
+			{Name: "data-source", Handler: httpHandlerCfg("")},
+			{Name: "filter", Handler: config.Handler{
+				Type:          config.HandlerTypeFilter,
+				FilterHandler: config.FilterHandler{Expression: "true"},
+			}},
+			{Name: "enrichment", Handler: httpHandlerCfg(config.DryRunModeExecute)},
+			{Name: "data-sink", Handler: httpHandlerCfg("")},
+			{Name: "recorded-source", Handler: httpHandlerCfg(config.DryRunModeRecord)},
+		},
+	}
+
+	dp, err := NewDataPipe(cfg, zerolog.Nop())
+	require.NoError(t, err)
+
+	handlers := dp.(*dataPipe).handlers
+	assert.IsType(t, &httpHandler{}, handlers[0])
+	assert.IsType(t, &filterHandler{}, handlers[1])
+	assert.IsType(t, &httpHandler{}, handlers[2])
+	assert.IsType(t, &dryRunHandler{}, handlers[3])
+	assert.IsType(t, &dryRunHandler{}, handlers[4])
+}
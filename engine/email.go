@@ -0,0 +1,420 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultEmailPort    = 587
+	defaultEmailTLSPort = 465
+	defaultEmailTimeout = 30 * time.Second
+)
+
+// emailHandler sends an email per record, or collects the records of a run into a digest per recipients and subject.
+type emailHandler struct {
+	name     string
+	cfg      config.EmailHandler
+	dryRun   bool
+	logger   zerolog.Logger
+	limiters []*limiter
+	from     *mail.Address
+	tls      *tls.Config
+
+	digestMux sync.Mutex
+	digests   []*emailDigest
+}
+
+// emailMessage is an email rendered for a record.
+type emailMessage struct {
+	to      []*mail.Address
+	cc      []*mail.Address
+	bcc     []*mail.Address
+	subject string
+	text    string
+	html    string
+}
+
+// emailDigest collects the text and the HTML of the records sent to the same recipients with the same subject.
+type emailDigest struct {
+	key   string
+	msg   emailMessage
+	texts []string
+	htmls []string
+}
+
+func newEmailHandler(
+	name string, cfg config.EmailHandler, limiters []*limiter, dryRun bool, logger zerolog.Logger,
+) (*emailHandler, error) {
+	if cfg.Security == "" {
+		cfg.Security = config.EmailSecurityStartTLS
+	}
+	if cfg.Port == 0 {
+		cfg.Port = defaultEmailPort
+		if cfg.Security == config.EmailSecurityTLS {
+			cfg.Port = defaultEmailTLSPort
+		}
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultEmailTimeout
+	}
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %s", err)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLS != nil {
+		tlsConfig, err = newTLSConfig(*cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = cfg.Host
+	}
+
+	return &emailHandler{
+		name:     name,
+		cfg:      cfg,
+		dryRun:   dryRun,
+		logger:   logger,
+		limiters: limiters,
+		from:     from,
+		tls:      tlsConfig,
+	}, nil
+}
+
+func (h *emailHandler) Name() string {
+	return h.name
+}
+
+func (h *emailHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	msg, err := h.render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.cfg.Digest != nil {
+		h.addToDigest(msg)
+		return []HandlerResult{{}}, nil
+	}
+
+	messageID, err := h.send(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return []HandlerResult{{"message_id": mustMarshal(messageID)}}, nil
+}
+
+// Flush sends the digests of the run. The records were already acknowledged to the source,
+// so a failed digest is not sent again, it only fails the run.
+func (h *emailHandler) Flush(ctx context.Context) error {
+	h.digestMux.Lock()
+	digests := h.digests
+	h.digests = nil
+	h.digestMux.Unlock()
+
+	var errs []string
+	for _, d := range digests {
+		msg := d.msg
+		if h.cfg.Text != "" {
+			msg.text = h.cfg.Digest.TextHeader + strings.Join(d.texts, "\n") + h.cfg.Digest.TextFooter
+		}
+		if h.cfg.HTML != "" {
+			msg.html = h.cfg.Digest.HTMLHeader + strings.Join(d.htmls, "") + h.cfg.Digest.HTMLFooter
+		}
+		if _, err := h.send(ctx, msg); err != nil {
+			errs = append(errs, fmt.Sprintf("digest '%s' of %d records: %s", msg.subject, len(d.texts), err))
+			continue
+		}
+		h.logger.Debug().Str("subject", msg.subject).Int("records", len(d.texts)).Msg("digest sent")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send digests, their records are not read again: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (h *emailHandler) addToDigest(msg emailMessage) {
+	key := strings.Join([]string{
+		joinAddresses(msg.to), joinAddresses(msg.cc), joinAddresses(msg.bcc), msg.subject,
+	}, "\n")
+
+	h.digestMux.Lock()
+	defer h.digestMux.Unlock()
+	var digest *emailDigest
+	for _, d := range h.digests {
+		if d.key == key {
+			digest = d
+			break
+		}
+	}
+	if digest == nil {
+		digest = &emailDigest{key: key, msg: msg}
+		h.digests = append(h.digests, digest)
+	}
+	digest.texts = append(digest.texts, msg.text)
+	digest.htmls = append(digest.htmls, msg.html)
+}
+
+func (h *emailHandler) render(data map[string]string) (emailMessage, error) {
+	msg := emailMessage{}
+	var err error
+	if msg.to, err = renderAddresses(h.cfg.To, data); err != nil {
+		return emailMessage{}, fmt.Errorf("failed to render 'to' recipients: %s", err)
+	}
+	if msg.cc, err = renderAddresses(h.cfg.Cc, data); err != nil {
+		return emailMessage{}, fmt.Errorf("failed to render 'cc' recipients: %s", err)
+	}
+	if msg.bcc, err = renderAddresses(h.cfg.Bcc, data); err != nil {
+		return emailMessage{}, fmt.Errorf("failed to render 'bcc' recipients: %s", err)
+	}
+	if len(msg.to)+len(msg.cc)+len(msg.bcc) == 0 {
+		return emailMessage{}, fmt.Errorf("no recipients")
+	}
+
+	var ok bool
+	if msg.subject, ok = replaceTextPlaceholders(h.cfg.Subject, data); !ok {
+		return emailMessage{}, fmt.Errorf("failed to replace placeholders in subject: some data not found")
+	}
+	if msg.text, ok = replaceTextPlaceholders(h.cfg.Text, data); !ok {
+		return emailMessage{}, fmt.Errorf("failed to replace placeholders in text: some data not found")
+	}
+	if msg.html, ok = replaceHTMLPlaceholders(h.cfg.HTML, data); !ok {
+		return emailMessage{}, fmt.Errorf("failed to replace placeholders in html: some data not found")
+	}
+	return msg, nil
+}
+
+// renderAddresses renders the recipients, each of them may be a comma separated list or a JSON array of addresses.
+func renderAddresses(recipients []string, data map[string]string) ([]*mail.Address, error) {
+	var addresses []*mail.Address
+	for _, recipient := range recipients {
+		rendered, ok := replaceTextPlaceholders(recipient, data)
+		if !ok {
+			return nil, fmt.Errorf("some data not found")
+		}
+		rendered = strings.TrimSpace(rendered)
+		if rendered == "" {
+			continue
+		}
+
+		var list []string
+		if err := json.Unmarshal([]byte(rendered), &list); err == nil {
+			rendered = strings.Join(list, ",")
+		}
+		parsed, err := mail.ParseAddressList(rendered)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address '%s': %s", rendered, err)
+		}
+		addresses = append(addresses, parsed...)
+	}
+	return addresses, nil
+}
+
+// send sends the email and returns its message ID.
+func (h *emailHandler) send(ctx context.Context, msg emailMessage) (string, error) {
+	messageID := newEmailMessageID(h.from.Address)
+	if h.dryRun {
+		h.logger.Info().
+			Str("to", joinAddresses(msg.to)).
+			Str("cc", joinAddresses(msg.cc)).
+			Str("bcc", joinAddresses(msg.bcc)).
+			Str("subject", msg.subject).
+			Str("text", msg.text).
+			Str("html", msg.html).
+			Msg("dry run: email recorded")
+		return messageID, nil
+	}
+
+	body, err := h.buildMessage(msg, messageID)
+	if err != nil {
+		return "", fmt.Errorf("failed to build email: %s", err)
+	}
+
+	release, err := acquireLimiters(ctx, h.limiters)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	var recipients []string
+	for _, addresses := range [][]*mail.Address{msg.to, msg.cc, msg.bcc} {
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	if err := h.sendMail(ctx, recipients, body); err != nil {
+		return "", fmt.Errorf("failed to send email: %s", err)
+	}
+	return messageID, nil
+}
+
+func (h *emailHandler) sendMail(ctx context.Context, recipients []string, body []byte) error {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(h.cfg.Host, strconv.Itoa(h.cfg.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if h.cfg.Security == config.EmailSecurityTLS {
+		conn = tls.Client(conn, h.tls)
+	}
+
+	client, err := smtp.NewClient(conn, h.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if h.cfg.Security == config.EmailSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(h.tls); err != nil {
+			return fmt.Errorf("STARTTLS failed: %s", err)
+		}
+	}
+	if h.cfg.Username != "" {
+		password, err := h.cfg.Password.Read()
+		if err != nil {
+			return fmt.Errorf("failed to read password: %s", err)
+		}
+		if err := client.Auth(smtp.PlainAuth("", h.cfg.Username, password, h.cfg.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %s", err)
+		}
+	}
+
+	if err := client.Mail(h.from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient '%s' rejected: %s", recipient, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMessage returns the MIME message, with a text and an HTML alternative if both are set.
+func (h *emailHandler) buildMessage(msg emailMessage, messageID string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writeHeader := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	writeHeader("From", h.from.String())
+	if len(msg.to) > 0 {
+		writeHeader("To", joinAddresses(msg.to))
+	}
+	if len(msg.cc) > 0 {
+		writeHeader("Cc", joinAddresses(msg.cc))
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", msg.subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", messageID)
+	writeHeader("MIME-Version", "1.0")
+
+	if msg.text == "" || msg.html == "" {
+		contentType, content := "text/plain", msg.text
+		if msg.text == "" {
+			contentType, content = "text/html", msg.html
+		}
+		writeHeader("Content-Type", contentType+"; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(buf, content); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(buf)
+	writeHeader("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{{"text/plain", msg.text}, {"text/html", msg.html}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func joinAddresses(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = address.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// newEmailMessageID returns a unique message ID in the domain of the sender.
+func newEmailMessageID(from string) string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
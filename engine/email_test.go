@@ -0,0 +1,458 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpStub is a local SMTP server that records the emails it receives.
+type smtpStub struct {
+	listener net.Listener
+	// tls enables STARTTLS, or implicit TLS if implicitTLS is set
+	tls         *tls.Config
+	implicitTLS bool
+	username    string
+	password    string
+
+	mux    sync.Mutex
+	emails []stubEmail
+}
+
+type stubEmail struct {
+	from       string
+	recipients []string
+	data       string
+	tls        bool
+	username   string
+}
+
+func newSMTPStub(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if implicitTLS {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s := &smtpStub{listener: listener, tls: tlsConfig, implicitTLS: implicitTLS}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return s
+}
+
+func (s *smtpStub) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) received() []stubEmail {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]stubEmail(nil), s.emails...)
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = io.WriteString(conn, line+"\r\n")
+	}
+	email := stubEmail{tls: s.implicitTLS}
+
+	reply("220 stub ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch strings.ToUpper(command) {
+		case "EHLO":
+			reply("250-stub")
+			if s.tls != nil && !email.tls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader = tlsConn, bufio.NewReader(tlsConn)
+			email.tls = true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			parts := strings.Split(string(credentials), "\x00")
+			if len(parts) != 3 || parts[1] != s.username || parts[2] != s.password {
+				reply("535 authentication failed")
+				continue
+			}
+			email.username = parts[1]
+			reply("235 authenticated")
+		case "MAIL":
+			email.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 OK")
+		case "RCPT":
+			recipient := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if strings.HasPrefix(recipient, "blocked@") {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			email.recipients = append(email.recipients, recipient)
+			reply("250 OK")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data := strings.Builder{}
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			email.data = data.String()
+			s.mux.Lock()
+			s.emails = append(s.emails, email)
+			s.mux.Unlock()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// newStubTLSConfig returns the TLS config of the stub with a self-signed certificate for 127.0.0.1,
+// and the path of the certificate for clients to trust.
+func newStubTLSConfig(t *testing.T) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtp stub"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(t.TempDir(), "smtp.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600))
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}, certFile
+}
+
+// parseEmail returns the headers of the email and its parts by content type.
+func parseEmail(t *testing.T, data string) (mail.Header, map[string]string) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	require.NoError(t, err)
+
+	parts := map[string]string{}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		require.NoError(t, err)
+		parts[mediaType] = strings.ReplaceAll(string(body), "\r\n", "\n")
+		return msg.Header, parts
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		partType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		// multipart decodes quoted-printable parts
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts[partType] = strings.ReplaceAll(string(body), "\r\n", "\n")
+	}
+	return msg.Header, parts
+}
+
+func newTestEmailHandler(t *testing.T, cfg config.EmailHandler, port int, dryRun bool) *emailHandler {
+	cfg.Host = "127.0.0.1"
+	cfg.Port = port
+	if cfg.From == "" {
+		cfg.From = "Datapipe <datapipe@example.com>"
+	}
+	h, err := newEmailHandler("notify", cfg, nil, dryRun, zerolog.Nop())
+	require.NoError(t, err)
+	return h
+}
+
+func TestEmailHandler_Send(t *testing.T) {
+	tlsConfig, certFile := newStubTLSConfig(t)
+	stub := newSMTPStub(t, tlsConfig, false)
+	stub.username, stub.password = "datapipe", "smtp-secret"
+
+	h := newTestEmailHandler(t, config.EmailHandler{
+		TLS:      &config.TLS{CAFile: certFile},
+		Username: "datapipe",
+		Password: &config.Secret{Value: "smtp-secret"},
+		To:       []string{"{{ orders.email }}", "sales@example.com"},
+		Bcc:      []string{"{{ orders.watchers }}"},
+		Subject:  "Order {{ orders.id }} is {{ orders.status }}",
+		Text:     "Order {{ orders.id }}: {{ orders.status }}",
+		HTML:     "<p>Order <b>{{ orders.id }}</b>: {{ orders.status }}</p>",
+	}, stub.port(), false)
+
+	results, err := h.Handle(context.Background(), map[string]string{
+		"orders.email":    `"Alice <alice@example.com>"`,
+		"orders.watchers": `["audit@example.com", "ops@example.com"]`,
+		"orders.id":       `7`,
+		"orders.status":   `"<shipped> & \"paid\" ✓"`,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	emails := stub.received()
+	require.Len(t, emails, 1)
+	email := emails[0]
+	assert.True(t, email.tls)
+	assert.Equal(t, "datapipe", email.username)
+	assert.Equal(t, "datapipe@example.com", email.from)
+	assert.Equal(t, []string{
+		"alice@example.com", "sales@example.com", "audit@example.com", "ops@example.com",
+	}, email.recipients)
+
+	header, parts := parseEmail(t, email.data)
+	assert.Equal(t, `"Alice" <alice@example.com>, <sales@example.com>`, header.Get("To"))
+	assert.Empty(t, header.Get("Bcc"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, `Order 7 is <shipped> & "paid" ✓`, subject)
+	var messageID string
+	require.NoError(t, json.Unmarshal(results[0]["message_id"], &messageID))
+	assert.Equal(t, header.Get("Message-ID"), messageID)
+	assert.Equal(t, map[string]string{
+		"text/plain": `Order 7: <shipped> & "paid" ✓`,
+		"text/html":  "<p>Order <b>7</b>: &lt;shipped&gt; &amp; &#34;paid&#34; ✓</p>",
+	}, parts)
+}
+
+func TestEmailHandler_Security(t *testing.T) {
+	tlsConfig, certFile := newStubTLSConfig(t)
+	cfg := config.EmailHandler{
+		TLS:     &config.TLS{CAFile: certFile},
+		To:      []string{"alice@example.com"},
+		Subject: "Hello",
+		Text:    "Hello",
+	}
+
+	t.Run("ImplicitTLS", func(t *testing.T) {
+		stub := newSMTPStub(t, tlsConfig, true)
+		cfg := cfg
+		cfg.Security = config.EmailSecurityTLS
+		_, err := newTestEmailHandler(t, cfg, stub.port(), false).Handle(context.Background(), nil)
+		require.NoError(t, err)
+		require.Len(t, stub.received(), 1)
+		assert.True(t, stub.received()[0].tls)
+	})
+
+	t.Run("PlainConnection", func(t *testing.T) {
+		stub := newSMTPStub(t, nil, false)
+		cfg := cfg
+		cfg.Security = config.EmailSecurityNone
+		_, err := newTestEmailHandler(t, cfg, stub.port(), false).Handle(context.Background(), nil)
+		require.NoError(t, err)
+		require.Len(t, stub.received(), 1)
+		assert.False(t, stub.received()[0].tls)
+	})
+
+	t.Run("STARTTLSNotSupported", func(t *testing.T) {
+		stub := newSMTPStub(t, nil, false)
+		_, err := newTestEmailHandler(t, cfg, stub.port(), false).Handle(context.Background(), nil)
+		assert.EqualError(t, err, "failed to send email: server doesn't support STARTTLS")
+		assert.Empty(t, stub.received())
+	})
+
+	t.Run("InvalidPassword", func(t *testing.T) {
+		stub := newSMTPStub(t, tlsConfig, false)
+		stub.username, stub.password = "datapipe", "smtp-secret"
+		cfg := cfg
+		cfg.Username = "datapipe"
+		cfg.Password = &config.Secret{Value: "other-secret"}
+		_, err := newTestEmailHandler(t, cfg, stub.port(), false).Handle(context.Background(), nil)
+		assert.ErrorContains(t, err, "authentication failed")
+		assert.Empty(t, stub.received())
+	})
+}
+
+func TestEmailHandler_Errors(t *testing.T) {
+	stub := newSMTPStub(t, nil, false)
+	cfg := config.EmailHandler{
+		Security: config.EmailSecurityNone,
+		To:       []string{"{{ orders.email }}"},
+		Subject:  "Order {{ orders.id }}",
+		Text:     "Order {{ orders.id }}",
+	}
+	h := newTestEmailHandler(t, cfg, stub.port(), false)
+
+	tests := []struct {
+		name string
+		data map[string]string
+		err  string
+	}{
+		{
+			name: "MissingData",
+			data: map[string]string{"orders.email": `"alice@example.com"`},
+			err:  "failed to replace placeholders in subject: some data not found",
+		},
+		{
+			name: "InvalidAddress",
+			data: map[string]string{"orders.email": `"alice"`, "orders.id": `7`},
+			err:  "failed to render 'to' recipients: invalid address 'alice': mail: missing '@' or angle-addr",
+		},
+		{
+			name: "NoRecipients",
+			data: map[string]string{"orders.email": `""`, "orders.id": `7`},
+			err:  "no recipients",
+		},
+		{
+			name: "RejectedRecipient",
+			data: map[string]string{"orders.email": `"blocked@example.com"`, "orders.id": `7`},
+			err:  "failed to send email: recipient 'blocked@example.com' rejected: 550 \"mailbox unavailable\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.Handle(context.Background(), tt.data)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+	assert.Empty(t, stub.received())
+}
+
+func TestEmailHandler_Digest(t *testing.T) {
+	stub := newSMTPStub(t, nil, false)
+	cfg := config.EmailHandler{
+		Security: config.EmailSecurityNone,
+		To:       []string{"{{ mock.manager }}"},
+		Subject:  "New orders",
+		Text:     "- order {{ mock.id }}",
+		HTML:     "<li>order {{ mock.id }}</li>",
+		Digest: &config.EmailDigest{
+			TextHeader: "New orders:\n",
+			HTMLHeader: "<ul>",
+			HTMLFooter: "</ul>",
+		},
+	}
+	source := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		return []HandlerResult{
+			{"id": []byte(`1`), "manager": []byte(`"alice@example.com"`)},
+			{"id": []byte(`2`), "manager": []byte(`"bob@example.com"`)},
+			{"id": []byte(`3`), "manager": []byte(`"alice@example.com"`)},
+		}, nil
+	}}
+
+	// in dry run, digests are only logged
+	h := newTestEmailHandler(t, cfg, stub.port(), true)
+	require.NoError(t, RunHandlers(context.Background(), []Handler{source, h}))
+	assert.Empty(t, stub.received())
+
+	h = newTestEmailHandler(t, cfg, stub.port(), false)
+	require.NoError(t, RunHandlers(context.Background(), []Handler{source, h}))
+
+	emails := stub.received()
+	require.Len(t, emails, 2)
+	byRecipient := map[string]map[string]string{}
+	for _, email := range emails {
+		require.Len(t, email.recipients, 1)
+		_, parts := parseEmail(t, email.data)
+		byRecipient[email.recipients[0]] = parts
+	}
+
+	alice := byRecipient["alice@example.com"]
+	assert.Contains(t, []string{
+		"New orders:\n- order 1\n- order 3",
+		"New orders:\n- order 3\n- order 1",
+	}, alice["text/plain"])
+	assert.Contains(t, []string{
+		"<ul><li>order 1</li><li>order 3</li></ul>",
+		"<ul><li>order 3</li><li>order 1</li></ul>",
+	}, alice["text/html"])
+	assert.Equal(t, map[string]string{
+		"text/plain": "New orders:\n- order 2",
+		"text/html":  "<ul><li>order 2</li></ul>",
+	}, byRecipient["bob@example.com"])
+
+	// digests are sent once per run
+	require.NoError(t, h.Flush(context.Background()))
+	assert.Len(t, stub.received(), 2)
+}
+
+func TestEmailHandler_DigestFailed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	h := newTestEmailHandler(t, config.EmailHandler{
+		Security: config.EmailSecurityNone,
+		To:       []string{"alice@example.com"},
+		Subject:  "New orders",
+		Text:     "- order {{ mock.id }}",
+		Digest:   &config.EmailDigest{},
+	}, port, false)
+	source := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		return []HandlerResult{{"id": []byte(`1`)}}, nil
+	}}
+
+	err = RunHandlers(context.Background(), []Handler{source, h})
+	assert.ErrorContains(t, err,
+		"failed to flush handler notify: failed to send digests, their records are not read again: "+
+			"digest 'New orders' of 1 records")
+}
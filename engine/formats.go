@@ -0,0 +1,212 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+// responseFormat returns the format of the response body: the configured one, or the one matching
+// the Content-Type header. JSON is used if neither is known.
+func responseFormat(cfg config.Response, contentType string) config.ResponseFormat {
+	if cfg.Format != "" {
+		return cfg.Format
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return config.ResponseFormatJSON
+	}
+	switch {
+	case mediaType == "application/x-ndjson", mediaType == "application/ndjson",
+		mediaType == "application/jsonl", mediaType == "application/x-jsonlines":
+		return config.ResponseFormatNDJSON
+	case mediaType == "text/csv":
+		return config.ResponseFormatCSV
+	case mediaType == "application/xml", mediaType == "text/xml", strings.HasSuffix(mediaType, "+xml"):
+		return config.ResponseFormatXML
+	case mediaType == "text/plain":
+		return config.ResponseFormatText
+	default:
+		return config.ResponseFormatJSON
+	}
+}
+
+// decodeBody converts a non-empty response body of the given format into results.
+func decodeBody(body []byte, format config.ResponseFormat, cfg config.Response) ([]HandlerResult, error) {
+	switch format {
+	case config.ResponseFormatNDJSON:
+		return decodeNDJSON(body)
+	case config.ResponseFormatCSV:
+		return decodeCSV(body, cfg.CSV)
+	case config.ResponseFormatXML:
+		return decodeXML(body, cfg.XML)
+	case config.ResponseFormatText:
+		return decodeText(body, cfg.Text)
+	default:
+		return extractResults(body, cfg)
+	}
+}
+
+// decodeNDJSON returns a result per JSON object of the body.
+func decodeNDJSON(body []byte) ([]HandlerResult, error) {
+	var results []HandlerResult
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for decoder.More() {
+		result := HandlerResult{}
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("line %d: %s", len(results)+1, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// decodeCSV returns a result per row of the body, with the fields named after the columns.
+func decodeCSV(body []byte, cfg config.CSVFormat) ([]HandlerResult, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	if cfg.Delimiter != "" {
+		reader.Comma = []rune(cfg.Delimiter)[0]
+	}
+
+	header := cfg.Header
+	if len(header) == 0 {
+		var err error
+		header, err = reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV header: %s", err)
+		}
+	}
+	reader.FieldsPerRecord = len(header)
+
+	fields := make([]string, len(header))
+	for i, column := range header {
+		fields[i] = column
+		if field, ok := cfg.Columns[column]; ok {
+			fields[i] = field
+		}
+	}
+
+	var results []HandlerResult
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row: %s", err)
+		}
+
+		result := make(HandlerResult, len(row))
+		for i, value := range row {
+			result[fields[i]], _ = json.Marshal(value)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+// decodeXML returns a result per element at the element path, or per child of the root element if
+// the path is not set. Attributes and child elements become the result fields.
+func decodeXML(body []byte, cfg config.XMLFormat) ([]HandlerResult, error) {
+	root := xmlNode{}
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return nil, err
+	}
+
+	elements := root.Nodes
+	if cfg.ElementPath != "" {
+		names := cfg.ElementPathNames()
+		if root.XMLName.Local != names[0] {
+			return nil, fmt.Errorf("root element is '%s', not '%s'", root.XMLName.Local, names[0])
+		}
+		elements = []xmlNode{root}
+		for _, name := range names[1:] {
+			var children []xmlNode
+			for _, element := range elements {
+				for _, child := range element.Nodes {
+					if child.XMLName.Local == name {
+						children = append(children, child)
+					}
+				}
+			}
+			elements = children
+		}
+	}
+
+	results := make([]HandlerResult, 0, len(elements))
+	for _, element := range elements {
+		value := element.value()
+		object, ok := value.(map[string]any)
+		if !ok {
+			object = map[string]any{"text": value}
+		}
+
+		result := make(HandlerResult, len(object))
+		for field, fieldValue := range object {
+			raw, err := json.Marshal(fieldValue)
+			if err != nil {
+				return nil, err
+			}
+			result[field] = raw
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// value converts the node into a string if it only has text, or into an object otherwise.
+// Repeated child elements are grouped into an array.
+func (n xmlNode) value() any {
+	text := strings.TrimSpace(n.Content)
+	if len(n.Attrs) == 0 && len(n.Nodes) == 0 {
+		return text
+	}
+
+	object := map[string]any{}
+	for _, attr := range n.Attrs {
+		object[attr.Name.Local] = attr.Value
+	}
+	for _, child := range n.Nodes {
+		name := child.XMLName.Local
+		switch existing := object[name].(type) {
+		case nil:
+			object[name] = child.value()
+		case []any:
+			object[name] = append(existing, child.value())
+		default:
+			object[name] = []any{existing, child.value()}
+		}
+	}
+	if text != "" {
+		object["text"] = text
+	}
+	return object
+}
+
+// decodeText returns a single result with the whole body.
+func decodeText(body []byte, cfg config.TextFormat) ([]HandlerResult, error) {
+	field := cfg.Field
+	if field == "" {
+		field = "text"
+	}
+	value, err := json.Marshal(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return []HandlerResult{{field: value}}, nil
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseFormat(t *testing.T) {
+	tests := []struct {
+		cfg         config.Response
+		contentType string
+		expected    config.ResponseFormat
+	}{
+		{contentType: "", expected: config.ResponseFormatJSON},
+		{contentType: "application/json; charset=utf-8", expected: config.ResponseFormatJSON},
+		{contentType: "application/x-ndjson", expected: config.ResponseFormatNDJSON},
+		{contentType: "text/csv; header=present", expected: config.ResponseFormatCSV},
+		{contentType: "text/xml", expected: config.ResponseFormatXML},
+		{contentType: "application/atom+xml", expected: config.ResponseFormatXML},
+		{contentType: "text/plain; charset=utf-8", expected: config.ResponseFormatText},
+		{contentType: "text/html", expected: config.ResponseFormatJSON},
+		{
+			cfg:         config.Response{Format: config.ResponseFormatText},
+			contentType: "application/json",
+			expected:    config.ResponseFormatText,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			assert.Equal(t, tt.expected, responseFormat(tt.cfg, tt.contentType))
+		})
+	}
+}
+
+func TestDecodeResponse_Formats(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.Response
+		contentType string
+		body        string
+		expected    []HandlerResult
+		errContains string
+	}{
+		{
+			name:        "NDJSON",
+			contentType: "application/x-ndjson",
+			body:        "{\"id\":1}\n\n{\"id\":2}\n",
+			expected:    []HandlerResult{{"id": json.RawMessage(`1`)}, {"id": json.RawMessage(`2`)}},
+		},
+		{
+			name:        "InvalidNDJSON",
+			cfg:         config.Response{Format: config.ResponseFormatNDJSON},
+			body:        "{\"id\":1}\n[1]\n",
+			errContains: "line 2",
+		},
+		{
+			name:        "CSV",
+			contentType: "text/csv",
+			body:        "id,full name\n1,\"Doe, John\"\n2,Jane\n",
+			expected: []HandlerResult{
+				{"id": json.RawMessage(`"1"`), "full name": json.RawMessage(`"Doe, John"`)},
+				{"id": json.RawMessage(`"2"`), "full name": json.RawMessage(`"Jane"`)},
+			},
+		},
+		{
+			name: "CSVWithHeaderAndColumns",
+			cfg: config.Response{Format: config.ResponseFormatCSV, CSV: config.CSVFormat{
+				Delimiter: ";",
+				Header:    []string{"id", "full name"},
+				Columns:   map[string]string{"full name": "name"},
+			}},
+			body:     "1;John\n",
+			expected: []HandlerResult{{"id": json.RawMessage(`"1"`), "name": json.RawMessage(`"John"`)}},
+		},
+		{
+			name:        "CSVWrongNumberOfFields",
+			cfg:         config.Response{Format: config.ResponseFormatCSV},
+			body:        "id,name\n1\n",
+			errContains: "failed to read CSV row",
+		},
+		{
+			name:        "XML",
+			contentType: "application/xml",
+			body: `<?xml version="1.0"?>
+<response>
+  <items>
+    <item id="1"><name>John</name><tag>a</tag><tag>b</tag></item>
+    <item id="2"><name>Jane</name><address city="Kyiv">Main st.</address></item>
+  </items>
+  <total>2</total>
+</response>`,
+			cfg: config.Response{XML: config.XMLFormat{ElementPath: "response/items/item"}},
+			expected: []HandlerResult{
+				{
+					"id":   json.RawMessage(`"1"`),
+					"name": json.RawMessage(`"John"`),
+					"tag":  json.RawMessage(`["a","b"]`),
+				},
+				{
+					"id":      json.RawMessage(`"2"`),
+					"name":    json.RawMessage(`"Jane"`),
+					"address": json.RawMessage(`{"city":"Kyiv","text":"Main st."}`),
+				},
+			},
+		},
+		{
+			name:        "XMLRootChildren",
+			cfg:         config.Response{Format: config.ResponseFormatXML},
+			body:        `<ids><id>1</id><id>2</id></ids>`,
+			expected:    []HandlerResult{{"text": json.RawMessage(`"1"`)}, {"text": json.RawMessage(`"2"`)}},
+			contentType: "text/plain",
+		},
+		{
+			name:        "XMLWrongRoot",
+			cfg:         config.Response{Format: config.ResponseFormatXML, XML: config.XMLFormat{ElementPath: "data/item"}},
+			body:        `<response></response>`,
+			errContains: "root element is 'response', not 'data'",
+		},
+		{
+			name:     "Text",
+			cfg:      config.Response{Format: config.ResponseFormatText, Text: config.TextFormat{Field: "message"}},
+			body:     "OK\n",
+			expected: []HandlerResult{{"message": json.RawMessage(`"OK\n"`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{tt.contentType}},
+			}
+			results, err := decodeResponse(resp, []byte(tt.body), tt.cfg)
+			if tt.errContains != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, len(tt.expected), len(results))
+			for i := range tt.expected {
+				for field, value := range tt.expected[i] {
+					assert.JSONEq(t, string(value), string(results[i][field]), "result %d, field %s", i, field)
+				}
+				assert.Len(t, results[i], len(tt.expected[i]))
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+)
+
+const defaultGraphQLTimeout = 15 * time.Second
+
+// graphqlMutationPattern matches a mutation operation of a GraphQL document.
+const graphqlMutationPattern = `(?m)^\s*mutation\b`
+
+// graphqlHandler sends a GraphQL query per record and emits the objects at the results path,
+// following the cursor of a connection if pagination is configured.
+type graphqlHandler struct {
+	name       string
+	cfg        config.GraphQLHandler
+	dryRun     bool
+	mutation   bool
+	httpClient *http.Client
+	auth       authenticator
+	limiters   []*limiter
+	logger     zerolog.Logger
+}
+
+type graphqlRequest struct {
+	Query         string                     `json:"query"`
+	OperationName string                     `json:"operationName,omitempty"`
+	Variables     map[string]json.RawMessage `json:"variables,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []graphqlError  `json:"errors"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path"`
+}
+
+type graphqlPageInfo struct {
+	EndCursor   *string `json:"endCursor"`
+	HasNextPage bool    `json:"hasNextPage"`
+}
+
+func newGraphQLHandler(
+	name string, cfg config.GraphQLHandler, transport http.RoundTripper, dryRun bool, logger zerolog.Logger,
+) *graphqlHandler {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultGraphQLTimeout
+	}
+	if p := cfg.Pagination; p != nil {
+		pagination := *p
+		if pagination.CursorVariable == "" {
+			pagination.CursorVariable = config.DefaultGraphQLCursorVariable
+		}
+		if pagination.MaxPages == 0 {
+			pagination.MaxPages = config.DefaultMaxPages
+		}
+		cfg.Pagination = &pagination
+	}
+
+	httpClient := &http.Client{Transport: transport, Timeout: cfg.Timeout}
+	return &graphqlHandler{
+		name:       name,
+		cfg:        cfg,
+		dryRun:     dryRun,
+		mutation:   regexp.MustCompile(graphqlMutationPattern).MatchString(cfg.Query),
+		httpClient: httpClient,
+		auth:       newAuthenticator(cfg.Auth, httpClient),
+		logger:     logger,
+	}
+}
+
+func (h *graphqlHandler) setTransport(transport http.RoundTripper) {
+	h.httpClient.Transport = transport
+}
+
+func (h *graphqlHandler) Name() string {
+	return h.name
+}
+
+func (h *graphqlHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	var results []HandlerResult
+	err := h.HandleStream(ctx, data, func(page []HandlerResult) error {
+		results = append(results, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HandleStream sends the query, following the end cursor while there are more pages, and emits the results
+// of each page.
+func (h *graphqlHandler) HandleStream(
+	ctx context.Context, data map[string]string, emit func(results []HandlerResult) error,
+) error {
+	req, err := h.createRequest(data)
+	if err != nil {
+		return err
+	}
+	if h.dryRun && h.mutation {
+		h.logger.Info().
+			Str("url", h.cfg.URL).
+			Str("query", req.Query).
+			Interface("variables", req.Variables).
+			Msg("dry run: mutation recorded")
+		return emit([]HandlerResult{{}})
+	}
+
+	headers, err := h.renderHeaders(data)
+	if err != nil {
+		return err
+	}
+
+	for page := 1; ; page++ {
+		resp, err := h.send(ctx, req, headers)
+		if err != nil {
+			if page > 1 {
+				return fmt.Errorf("page %d: %s", page, err)
+			}
+			return err
+		}
+		results, err := h.extractResults(resp.Data)
+		if err != nil {
+			return fmt.Errorf("failed to extract results: %s", err)
+		}
+		if err := emit(results); err != nil {
+			return err
+		}
+
+		if h.cfg.Pagination == nil {
+			return nil
+		}
+		cursor, ok, err := h.nextCursor(resp.Data)
+		if err != nil {
+			return fmt.Errorf("failed to read page info: %s", err)
+		}
+		if !ok {
+			return nil
+		}
+		if page == h.cfg.Pagination.MaxPages {
+			h.logger.Warn().Int("max_pages", page).Msg("pagination stopped at max pages")
+			return nil
+		}
+		req.Variables[h.cfg.Pagination.CursorVariable] = mustMarshal(cursor)
+	}
+}
+
+func (h *graphqlHandler) createRequest(data map[string]string) (graphqlRequest, error) {
+	req := graphqlRequest{
+		Query:         h.cfg.Query,
+		OperationName: h.cfg.OperationName,
+		Variables:     make(map[string]json.RawMessage, len(h.cfg.Variables)),
+	}
+	for name, value := range h.cfg.Variables {
+		rendered, ok := replacePlaceholders(value, data)
+		if !ok {
+			return graphqlRequest{}, fmt.Errorf("failed to replace placeholders in '%s' variable: some data not found", name)
+		}
+		req.Variables[name] = jsonOrString([]byte(rendered))
+	}
+	return req, nil
+}
+
+func (h *graphqlHandler) renderHeaders(data map[string]string) (map[string]string, error) {
+	headers := make(map[string]string, len(h.cfg.Headers))
+	for key, value := range h.cfg.Headers {
+		key, value, err := replaceKeyValuePlaceholders(key, value, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in header: %s", err)
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
+
+// send posts the query and returns the response, the errors of the response are returned as an error.
+func (h *graphqlHandler) send(
+	ctx context.Context, query graphqlRequest, headers map[string]string,
+) (*graphqlResponse, error) {
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode query: %s", err)
+	}
+
+	release, err := acquireLimiters(ctx, h.limiters)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, respBody, err := h.post(ctx, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	// the server may reject a cached token before it expires, the query is sent once more with a new one
+	if auth, ok := h.auth.(tokenAuthenticator); ok && resp.StatusCode == http.StatusUnauthorized {
+		auth.invalidate(resp.Request)
+		if resp, respBody, err = h.post(ctx, body, headers); err != nil {
+			return nil, err
+		}
+	}
+
+	graphqlResp := &graphqlResponse{}
+	decodeErr := json.Unmarshal(respBody, graphqlResp)
+	if decodeErr == nil && len(graphqlResp.Errors) > 0 {
+		return nil, fmt.Errorf("GraphQL errors: %s", formatGraphQLErrors(graphqlResp.Errors))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", decodeErr)
+	}
+	return graphqlResp, nil
+}
+
+// post sends the encoded query and returns the response with its body.
+func (h *graphqlHandler) post(
+	ctx context.Context, body []byte, headers map[string]string,
+) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/graphql-response+json, application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if h.auth != nil {
+		if err := h.auth.authenticate(ctx, req); err != nil {
+			return nil, nil, fmt.Errorf("failed to authenticate request: %s", err)
+		}
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %s", err)
+	}
+	return resp, respBody, nil
+}
+
+// extractResults returns the results at the results path of the data, a result per element of an array,
+// or a single result for an object.
+func (h *graphqlHandler) extractResults(data json.RawMessage) ([]HandlerResult, error) {
+	value, err := lookupJSONPath(data, h.cfg.ResultsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch jsonKind(value) {
+	case 'n':
+		return nil, nil
+	case '[':
+		var results []HandlerResult
+		if err := json.Unmarshal(value, &results); err != nil {
+			return nil, fmt.Errorf("results must be objects: %s", err)
+		}
+		return results, nil
+	case '{':
+		result := HandlerResult{}
+		if err := json.Unmarshal(value, &result); err != nil {
+			return nil, err
+		}
+		return []HandlerResult{result}, nil
+	default:
+		return nil, fmt.Errorf("results must be an array or an object")
+	}
+}
+
+// nextCursor returns the end cursor of the page if there is a next page.
+func (h *graphqlHandler) nextCursor(data json.RawMessage) (string, bool, error) {
+	value, err := lookupJSONPath(data, h.cfg.Pagination.PageInfoPath)
+	if err != nil {
+		return "", false, err
+	}
+	if jsonKind(value) != '{' {
+		return "", false, fmt.Errorf("'%s' is not an object", h.cfg.Pagination.PageInfoPath)
+	}
+	pageInfo := graphqlPageInfo{}
+	if err := json.Unmarshal(value, &pageInfo); err != nil {
+		return "", false, err
+	}
+	if !pageInfo.HasNextPage || pageInfo.EndCursor == nil || *pageInfo.EndCursor == "" {
+		return "", false, nil
+	}
+	return *pageInfo.EndCursor, true, nil
+}
+
+// lookupJSONPath returns the value at the dot-separated path.
+func lookupJSONPath(value json.RawMessage, path string) (json.RawMessage, error) {
+	for _, key := range config.JSONPathKeys(path) {
+		var err error
+		value, err = lookupJSON(value, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// formatGraphQLErrors joins the messages of the errors, prefixed with the path of the field they belong to.
+func formatGraphQLErrors(errs []graphqlError) string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+		if len(e.Path) > 0 {
+			path := make([]string, len(e.Path))
+			for j, p := range e.Path {
+				path[j] = fmt.Sprint(p)
+			}
+			messages[i] = strings.Join(path, ".") + ": " + e.Message
+		}
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,303 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type graphqlTestRequest struct {
+	Query         string                     `json:"query"`
+	OperationName string                     `json:"operationName"`
+	Variables     map[string]json.RawMessage `json:"variables"`
+	header        http.Header
+}
+
+func newGraphQLTestServer(
+	t *testing.T, handler func(w http.ResponseWriter, req graphqlTestRequest),
+) (*httptest.Server, *[]graphqlTestRequest) {
+	var requests []graphqlTestRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		req := graphqlTestRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		req.header = r.Header
+		requests = append(requests, req)
+		handler(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGraphQLHandler_Handle(t *testing.T) {
+	server, requests := newGraphQLTestServer(t, func(w http.ResponseWriter, req graphqlTestRequest) {
+		_, _ = w.Write([]byte(`{"data":{"customer":{"orders":[{"id":"1","total":10},{"id":"2","total":20}]}}}`))
+	})
+	h := newGraphQLHandler("orders", config.GraphQLHandler{
+		URL:           server.URL,
+		Headers:       map[string]string{"X-Tenant": "{{ data-source.tenant }}"},
+		Query:         "query Orders($id: ID!, $first: Int) { customer(id: $id) { orders(first: $first) { id total } } }",
+		OperationName: "Orders",
+		Variables: map[string]string{
+			"id":    "{{ data-source.id }}",
+			"first": "2",
+		},
+		ResultsPath: "customer.orders",
+	}, http.DefaultTransport, false, zerolog.Nop())
+
+	results, err := h.Handle(context.Background(), map[string]string{
+		"data-source.id":     "c-1",
+		"data-source.tenant": "acme",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{
+		{"id": json.RawMessage(`"1"`), "total": json.RawMessage(`10`)},
+		{"id": json.RawMessage(`"2"`), "total": json.RawMessage(`20`)},
+	}, results)
+
+	require.Len(t, *requests, 1)
+	req := (*requests)[0]
+	assert.Equal(t, "Orders", req.OperationName)
+	assert.JSONEq(t, `"c-1"`, string(req.Variables["id"]))
+	assert.JSONEq(t, `2`, string(req.Variables["first"]))
+	assert.Equal(t, "acme", req.header.Get("X-Tenant"))
+}
+
+func TestGraphQLHandler_Results(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected []HandlerResult
+		err      string
+	}{
+		{
+			name:     "Object",
+			response: `{"data":{"customer":{"id":"c-1"}}}`,
+			expected: []HandlerResult{{"id": json.RawMessage(`"c-1"`)}},
+		},
+		{
+			name:     "Null",
+			response: `{"data":{"customer":null}}`,
+		},
+		{
+			name:     "Scalar",
+			response: `{"data":{"customer":"c-1"}}`,
+			err:      "failed to extract results: results must be an array or an object",
+		},
+		{
+			name:     "Errors",
+			response: `{"data":null,"errors":[{"message":"customer not found","path":["customer"]},{"message":"rate limited"}]}`,
+			err:      "GraphQL errors: customer: customer not found; rate limited",
+		},
+		{
+			name:     "PartialErrors",
+			response: `{"data":{"customer":{"id":"c-1"}},"errors":[{"message":"no access","path":["customer","email"]}]}`,
+			err:      "GraphQL errors: customer.email: no access",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newGraphQLTestServer(t, func(w http.ResponseWriter, _ graphqlTestRequest) {
+				_, _ = w.Write([]byte(tt.response))
+			})
+			h := newGraphQLHandler("customer", config.GraphQLHandler{
+				URL:         server.URL,
+				Query:       "{ customer { id email } }",
+				ResultsPath: "customer",
+			}, http.DefaultTransport, false, zerolog.Nop())
+
+			results, err := h.Handle(context.Background(), map[string]string{})
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, results)
+		})
+	}
+}
+
+func TestGraphQLHandler_ResponseCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	h := newGraphQLHandler("customer", config.GraphQLHandler{
+		URL:         server.URL,
+		Query:       "{ customer { id } }",
+		ResultsPath: "customer",
+	}, http.DefaultTransport, false, zerolog.Nop())
+
+	_, err := h.Handle(context.Background(), map[string]string{})
+	assert.EqualError(t, err, "unexpected response code: 502")
+}
+
+func TestGraphQLHandler_RejectedToken(t *testing.T) {
+	tokenServer, tokenRequests := newTestTokenServer(t)
+	server, requests := newGraphQLTestServer(t, func(w http.ResponseWriter, req graphqlTestRequest) {
+		if req.header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"customer":{"id":1}}}`))
+	})
+
+	h := newGraphQLHandler("customer", config.GraphQLHandler{
+		URL:         server.URL,
+		Query:       "{ customer { id } }",
+		ResultsPath: "customer",
+		Auth: config.Auth{
+			Type: config.AuthTypeOAuth2,
+			OAuth2: config.OAuth2Auth{
+				TokenURL:     tokenServer.URL,
+				ClientID:     "client",
+				ClientSecret: config.Secret{Value: "client-secret"},
+			},
+		},
+	}, http.DefaultTransport, false, zerolog.Nop())
+
+	results, err := h.Handle(context.Background(), map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{"id": json.RawMessage(`1`)}}, results)
+	assert.Len(t, *requests, 2)
+	assert.Equal(t, int32(2), tokenRequests.Load())
+}
+
+func TestGraphQLHandler_Pagination(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	serve := func(w http.ResponseWriter, req graphqlTestRequest) {
+		var cursor string
+		if raw, ok := req.Variables["cursor"]; ok {
+			require.NoError(t, json.Unmarshal(raw, &cursor))
+		}
+		from, _ := strconv.Atoi(cursor)
+		to := min(from+2, len(items))
+		nodes := make([]map[string]string, 0, to-from)
+		for _, item := range items[from:to] {
+			nodes = append(nodes, map[string]string{"name": item})
+		}
+		body, _ := json.Marshal(nodes)
+		_, _ = fmt.Fprintf(w,
+			`{"data":{"items":{"nodes":%s,"pageInfo":{"endCursor":"%d","hasNextPage":%t}}}}`,
+			body, to, to < len(items),
+		)
+	}
+
+	tests := []struct {
+		name     string
+		maxPages int
+		expected []string
+		cursors  []string
+	}{
+		{
+			name:     "AllPages",
+			expected: []string{"a", "b", "c", "d", "e"},
+			cursors:  []string{"", `"2"`, `"4"`},
+		},
+		{
+			name:     "MaxPages",
+			maxPages: 2,
+			expected: []string{"a", "b", "c", "d"},
+			cursors:  []string{"", `"2"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newGraphQLTestServer(t, serve)
+			h := newGraphQLHandler("items", config.GraphQLHandler{
+				URL:         server.URL,
+				Query:       "query($cursor: String) { items(after: $cursor) { nodes { name } pageInfo { endCursor hasNextPage } } }",
+				ResultsPath: "items.nodes",
+				Pagination: &config.GraphQLPagination{
+					PageInfoPath:   "items.pageInfo",
+					CursorVariable: "cursor",
+					MaxPages:       tt.maxPages,
+				},
+			}, http.DefaultTransport, false, zerolog.Nop())
+
+			var pages int
+			var names []string
+			err := h.HandleStream(context.Background(), map[string]string{}, func(results []HandlerResult) error {
+				pages++
+				for _, result := range results {
+					var name string
+					require.NoError(t, json.Unmarshal(result["name"], &name))
+					names = append(names, name)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, names)
+			assert.Equal(t, len(tt.cursors), pages)
+
+			var cursors []string
+			for _, req := range *requests {
+				cursors = append(cursors, string(req.Variables["cursor"]))
+			}
+			assert.Equal(t, tt.cursors, cursors)
+		})
+	}
+}
+
+func TestGraphQLHandler_PaginationError(t *testing.T) {
+	server, _ := newGraphQLTestServer(t, func(w http.ResponseWriter, req graphqlTestRequest) {
+		if _, ok := req.Variables["after"]; ok {
+			_, _ = w.Write([]byte(`{"errors":[{"message":"invalid cursor"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"items":{"nodes":[{"id":1}],"pageInfo":{"endCursor":"1","hasNextPage":true}}}}`))
+	})
+	h := newGraphQLHandler("items", config.GraphQLHandler{
+		URL:         server.URL,
+		Query:       "query($after: String) { items(after: $after) { nodes { id } pageInfo { endCursor hasNextPage } } }",
+		ResultsPath: "items.nodes",
+		Pagination:  &config.GraphQLPagination{PageInfoPath: "items.pageInfo"},
+	}, http.DefaultTransport, false, zerolog.Nop())
+
+	var emitted []HandlerResult
+	err := h.HandleStream(context.Background(), map[string]string{}, func(results []HandlerResult) error {
+		emitted = append(emitted, results...)
+		return nil
+	})
+	assert.EqualError(t, err, "page 2: GraphQL errors: invalid cursor")
+	assert.Len(t, emitted, 1)
+}
+
+func TestGraphQLHandler_DryRunMutation(t *testing.T) {
+	server, requests := newGraphQLTestServer(t, func(w http.ResponseWriter, _ graphqlTestRequest) {
+		_, _ = w.Write([]byte(`{"data":{"customer":{"id":"c-1"}}}`))
+	})
+
+	mutation := newGraphQLHandler("update", config.GraphQLHandler{
+		URL:         server.URL,
+		Query:       "mutation($id: ID!) {\n  archive(id: $id) { id }\n}",
+		Variables:   map[string]string{"id": "{{ data-source.id }}"},
+		ResultsPath: "archive",
+	}, http.DefaultTransport, true, zerolog.Nop())
+	results, err := mutation.Handle(context.Background(), map[string]string{"data-source.id": "c-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{}}, results)
+	assert.Empty(t, *requests)
+
+	query := newGraphQLHandler("customer", config.GraphQLHandler{
+		URL:         server.URL,
+		Query:       "query { customer { id } }",
+		ResultsPath: "customer",
+	}, http.DefaultTransport, true, zerolog.Nop())
+	results, err = query.Handle(context.Background(), map[string]string{})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, *requests, 1)
+}
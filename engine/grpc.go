@@ -0,0 +1,426 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type grpcHandler struct {
+	name string
+	cfg  config.GRPCHandler
+
+	conn     *grpc.ClientConn
+	retries  *retryPolicy
+	limiters []*limiter
+	dryRun   bool
+	logger   zerolog.Logger
+
+	methodMux sync.Mutex
+	method    protoreflect.MethodDescriptor
+}
+
+// grpcResolveError is a failed server reflection, it keeps the status of the reflection call,
+// so it's retried like a failed call.
+type grpcResolveError struct {
+	err error
+}
+
+func (e *grpcResolveError) Error() string {
+	return fmt.Sprintf("failed to resolve gRPC method: server reflection failed: %s", e.err)
+}
+
+func (e *grpcResolveError) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
+func newGRPCHandler(name string, cfg config.GRPCHandler, dryRun bool, logger zerolog.Logger) (*grpcHandler, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 15 * time.Second
+	}
+
+	creds := insecure.NewCredentials()
+	if !cfg.Plaintext {
+		tlsConfig, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(cfg.Target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %s", err)
+	}
+
+	h := &grpcHandler{
+		name:    name,
+		cfg:     cfg,
+		conn:    conn,
+		retries: newRetryPolicyFor(cfg.Retries, cfg.RetryInterval, cfg.RetryPolicy),
+		dryRun:  dryRun,
+		logger:  logger,
+	}
+
+	// a descriptor set is loaded on start, while reflection is deferred to the first call,
+	// so the handler can be created before the server is up
+	if cfg.DescriptorSetFile != "" {
+		files, err := loadDescriptorSet(cfg.DescriptorSetFile)
+		if err != nil {
+			return nil, err
+		}
+		h.method, err = findMethod(files, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+func (h *grpcHandler) Name() string {
+	return h.name
+}
+
+func (h *grpcHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	start := time.Now()
+	var lastErr error
+	for attempt := 0; attempt <= h.cfg.Retries; attempt++ {
+		attemptData := copyMap(data)
+		attemptData[config.RetryAttemptPlaceholder] = strconv.Itoa(attempt + 1)
+
+		results, err := h.call(ctx, attemptData)
+		if err == nil {
+			return results, nil
+		}
+
+		lastErr = err
+
+		if !h.isRetryable(err) {
+			return nil, fmt.Errorf("failed to execute gRPC request: %s", err)
+		}
+
+		if attempt < h.cfg.Retries {
+			interval := h.retries.backoff(attempt+1, err)
+			maxElapsedTime := h.cfg.RetryPolicy.MaxElapsedTime
+			if maxElapsedTime > 0 && time.Since(start)+interval > maxElapsedTime {
+				return nil, fmt.Errorf(
+					"failed to execute gRPC request, retry deadline exceeded after %d attempts: %s", attempt+1, err,
+				)
+			}
+
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+				// Retry
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("failed to execute gRPC request after %d attempts: %s", h.cfg.Retries, lastErr)
+}
+
+func (h *grpcHandler) call(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	method, err := h.methodDescriptor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := h.createRequest(method, data)
+	if err != nil {
+		return nil, &requestError{
+			msg:       fmt.Sprintf("failed to create gRPC request: %s", err),
+			permanent: true,
+		}
+	}
+
+	md := metadata.MD{}
+	for key, value := range h.cfg.Metadata {
+		key, value, err := replaceKeyValuePlaceholders(key, value, data)
+		if err != nil {
+			return nil, &requestError{
+				msg:       fmt.Sprintf("failed to replace placeholders in metadata: %s", err),
+				permanent: true,
+			}
+		}
+		md.Append(key, value)
+	}
+
+	fullMethod := fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+	if h.dryRun {
+		h.logger.Info().
+			Str("method", fullMethod).
+			Interface("metadata", md).
+			Str("message", protojson.MarshalOptions{UseProtoNames: true}.Format(req)).
+			Msg("dry run: gRPC request recorded")
+		return []HandlerResult{{}}, nil
+	}
+
+	release, err := acquireLimiters(ctx, h.limiters)
+	if err != nil {
+		return nil, &requestError{
+			msg:       err.Error(),
+			permanent: true,
+		}
+	}
+	defer release()
+
+	callCtx, cancel := context.WithTimeout(metadata.NewOutgoingContext(ctx, md), h.cfg.Timeout)
+	defer cancel()
+
+	resp := dynamicpb.NewMessage(method.Output())
+	if err := h.conn.Invoke(callCtx, fullMethod, req, resp); err != nil {
+		return nil, err
+	}
+
+	respJSON, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(resp)
+	if err != nil {
+		return nil, &requestError{
+			msg:       fmt.Sprintf("failed to convert gRPC response into JSON: %s", err),
+			permanent: true,
+		}
+	}
+
+	resultsPath := config.ResultsPathRoot
+	if h.cfg.ResultsField != "" {
+		resultsPath = h.cfg.ResultsField
+	}
+	results, err := extractResults(respJSON, config.Response{ResultsPath: resultsPath, SingleObject: true})
+	if err != nil {
+		return nil, &requestError{
+			msg:       fmt.Sprintf("failed to map gRPC response into results: %s", err),
+			permanent: true,
+		}
+	}
+	return results, nil
+}
+
+func (h *grpcHandler) createRequest(method protoreflect.MethodDescriptor, data map[string]string) (proto.Message, error) {
+	body, ok := replacePlaceholders(h.cfg.Body, data)
+	if !ok {
+		return nil, fmt.Errorf("failed to replace placeholders in body: some data not found")
+	}
+
+	req := dynamicpb.NewMessage(method.Input())
+	if strings.TrimSpace(body) != "" {
+		if err := protojson.Unmarshal([]byte(body), req); err != nil {
+			return nil, fmt.Errorf("failed to decode body into '%s' message: %s", method.Input().FullName(), err)
+		}
+	}
+	return req, nil
+}
+
+// defaultGRPCRetryableCodes are the transient status codes retried when the retryable codes are not set.
+// nolint: gochecknoglobals
+var defaultGRPCRetryableCodes = []string{"UNAVAILABLE", "DEADLINE_EXCEEDED", "RESOURCE_EXHAUSTED", "ABORTED"}
+
+// isRetryable reports whether a failed call is retried: permanent errors never are, and status codes are retried
+// if they are in the retryable codes, the transient ones by default.
+func (h *grpcHandler) isRetryable(err error) bool {
+	reqErr := &requestError{}
+	if errors.As(err, &reqErr) {
+		return !reqErr.permanent
+	}
+	retryableCodes := h.cfg.RetryableCodes
+	if len(retryableCodes) == 0 {
+		retryableCodes = defaultGRPCRetryableCodes
+	}
+
+	code := status.Code(err)
+	if int(code) >= len(config.GRPCCodes) {
+		return false
+	}
+	for _, retryableCode := range retryableCodes {
+		if retryableCode == config.GRPCCodes[code] {
+			return true
+		}
+	}
+	return false
+}
+
+// methodDescriptor returns the descriptor of the called method, resolving it with server reflection until it succeeds.
+// The lock is not held during reflection, so concurrent first calls may resolve the method more than once.
+func (h *grpcHandler) methodDescriptor(ctx context.Context) (protoreflect.MethodDescriptor, error) {
+	h.methodMux.Lock()
+	method := h.method
+	h.methodMux.Unlock()
+	if method != nil {
+		return method, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	service, _, _ := h.cfg.ServiceAndMethod()
+	files, err := resolveWithReflection(ctx, h.conn, service)
+	if err != nil {
+		return nil, &grpcResolveError{err: err}
+	}
+	method, err = findMethod(files, h.cfg)
+	if err != nil {
+		return nil, &requestError{
+			msg:       fmt.Sprintf("failed to resolve gRPC method: %s", err),
+			permanent: true,
+		}
+	}
+
+	h.methodMux.Lock()
+	defer h.methodMux.Unlock()
+	if h.method == nil {
+		h.method = method
+	}
+	return h.method, nil
+}
+
+func findMethod(files *protoregistry.Files, cfg config.GRPCHandler) (protoreflect.MethodDescriptor, error) {
+	serviceName, methodName, err := cfg.ServiceAndMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, fmt.Errorf("'%s' service not found: %s", serviceName, err)
+	}
+	service, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a service", serviceName)
+	}
+
+	method := service.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return nil, fmt.Errorf("'%s' method not found in '%s' service", methodName, serviceName)
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, fmt.Errorf("'%s' method is not unary", methodName)
+	}
+	return method, nil
+}
+
+func loadDescriptorSet(path string) (*protoregistry.Files, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set file: %s", err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(raw, set); err != nil {
+		return nil, fmt.Errorf("failed to decode descriptor set file: %s", err)
+	}
+	return newFileRegistry(set.File, nil)
+}
+
+// resolveWithReflection fetches the file that defines the service and its dependencies from the server.
+func resolveWithReflection(ctx context.Context, conn *grpc.ClientConn, service string) (*protoregistry.Files, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = stream.CloseSend()
+	}()
+
+	request := func(req *reflectionpb.ServerReflectionRequest) ([]*descriptorpb.FileDescriptorProto, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if errResp := resp.GetErrorResponse(); errResp != nil {
+			return nil, fmt.Errorf("%s", errResp.GetErrorMessage())
+		}
+
+		var files []*descriptorpb.FileDescriptorProto
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, file); err != nil {
+				return nil, fmt.Errorf("failed to decode file descriptor: %s", err)
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}
+
+	files, err := request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileRegistry(files, func(path string) ([]*descriptorpb.FileDescriptorProto, error) {
+		return request(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: path},
+		})
+	})
+}
+
+// newFileRegistry builds a registry from the files. Missing dependencies are taken from the linked-in
+// files, e.g. the well-known types, or fetched if fetch is not nil.
+func newFileRegistry(
+	files []*descriptorpb.FileDescriptorProto,
+	fetch func(path string) ([]*descriptorpb.FileDescriptorProto, error),
+) (*protoregistry.Files, error) {
+	byPath := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, file := range files {
+		byPath[file.GetName()] = file
+	}
+
+	for i := 0; i < len(files); i++ {
+		for _, dependency := range files[i].GetDependency() {
+			if _, ok := byPath[dependency]; ok {
+				continue
+			}
+
+			var found []*descriptorpb.FileDescriptorProto
+			if linked, err := protoregistry.GlobalFiles.FindFileByPath(dependency); err == nil {
+				found = []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(linked)}
+			} else if fetch != nil {
+				found, err = fetch(dependency)
+				if err != nil {
+					return nil, fmt.Errorf("failed to fetch '%s' dependency: %s", dependency, err)
+				}
+			}
+
+			for _, file := range found {
+				if _, ok := byPath[file.GetName()]; !ok {
+					byPath[file.GetName()] = file
+					files = append(files, file)
+				}
+			}
+		}
+	}
+
+	registry, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: files})
+	if err != nil {
+		return nil, fmt.Errorf("invalid file descriptors: %s", err)
+	}
+	return registry, nil
+}
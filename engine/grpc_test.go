@@ -0,0 +1,380 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testItemServiceFile describes:
+//
+//	package test.v1;
+//	message ListItemsRequest { string query = 1; }
+//	message Item { int64 id = 1; string name = 2; }
+//	message ListItemsResponse { repeated Item items = 1; }
+//	service ItemService { rpc ListItems(ListItemsRequest) returns (ListItemsResponse); }
+func testItemServiceFile(t *testing.T) *descriptorpb.FileDescriptorProto {
+	t.Helper()
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	items := field("items", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	items.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	items.TypeName = proto.String(".test.v1.Item")
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/v1/items.proto"),
+		Package: proto.String("test.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("ListItemsRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("query", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING)},
+			},
+			{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+					field("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				},
+			},
+			{
+				Name:  proto.String("ListItemsResponse"),
+				Field: []*descriptorpb.FieldDescriptorProto{items},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("ItemService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("ListItems"),
+				InputType:  proto.String(".test.v1.ListItemsRequest"),
+				OutputType: proto.String(".test.v1.ListItemsResponse"),
+			}},
+		}},
+	}
+}
+
+type testItemServer struct {
+	calls    atomic.Int32
+	failures int32
+	// failureCode is the code of the failed calls, UNAVAILABLE by default
+	failureCode codes.Code
+
+	reflectionCalls    atomic.Int32
+	reflectionFailures int32
+}
+
+// startTestItemServer serves the item service on a local port, with server reflection.
+// ListItems returns an item per tenant from the metadata, named after the query.
+func startTestItemServer(t *testing.T, srv *testItemServer) (string, *descriptorpb.FileDescriptorProto) {
+	t.Helper()
+
+	fileProto := testItemServiceFile(t)
+	file, err := protodesc.NewFile(fileProto, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+	files := &protoregistry.Files{}
+	require.NoError(t, files.RegisterFile(file))
+
+	method := file.Services().Get(0).Methods().Get(0)
+	server := grpc.NewServer(grpc.StreamInterceptor(
+		func(s any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if srv.reflectionCalls.Add(1) <= srv.reflectionFailures {
+				return status.Error(codes.Unavailable, "reflection not ready")
+			}
+			return handler(s, ss)
+		},
+	))
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.v1.ItemService",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "ListItems",
+			Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				if srv.calls.Add(1) <= srv.failures {
+					if srv.failureCode != codes.OK {
+						return nil, status.Error(srv.failureCode, "call failed")
+					}
+					return nil, status.Error(codes.Unavailable, "try again")
+				}
+
+				req := dynamicpb.NewMessage(method.Input())
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				query := req.Get(method.Input().Fields().ByName("query")).String()
+				md, _ := metadata.FromIncomingContext(ctx)
+
+				resp := dynamicpb.NewMessage(method.Output())
+				itemsField := method.Output().Fields().ByName("items")
+				items := resp.Mutable(itemsField).List()
+				for i, tenant := range md.Get("x-tenant") {
+					item := items.NewElement().Message()
+					item.Set(item.Descriptor().Fields().ByName("id"), protoreflect.ValueOfInt64(int64(i+1)))
+					item.Set(item.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString(query+"@"+tenant))
+					items.Append(protoreflect.ValueOfMessage(item))
+				}
+				return resp, nil
+			},
+		}},
+	}, struct{}{})
+	reflectionpb.RegisterServerReflectionServer(server, reflection.NewServerV1(reflection.ServerOptions{
+		Services:           server,
+		DescriptorResolver: files,
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String(), fileProto
+}
+
+func TestGRPCHandler_Reflection(t *testing.T) {
+	addr, _ := startTestItemServer(t, &testItemServer{})
+
+	h, err := newGRPCHandler("items", config.GRPCHandler{
+		Target:       addr,
+		Method:       "test.v1.ItemService/ListItems",
+		Body:         `{"query": {{ source.query }}}`,
+		Metadata:     map[string]string{"X-Tenant": "{{ source.tenant }}"},
+		ResultsField: "items",
+		Plaintext:    true,
+	}, false, zerolog.Nop())
+	require.NoError(t, err)
+
+	results, err := h.Handle(context.Background(), map[string]string{
+		"source.query":  `"books"`,
+		"source.tenant": `"acme"`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{
+		"id":   json.RawMessage(`"1"`),
+		"name": json.RawMessage(`"books@acme"`),
+	}}, results)
+}
+
+func TestGRPCHandler_DescriptorSet(t *testing.T) {
+	addr, fileProto := startTestItemServer(t, &testItemServer{})
+
+	raw, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fileProto}})
+	require.NoError(t, err)
+	descriptorSetFile := filepath.Join(t.TempDir(), "items.pb")
+	require.NoError(t, os.WriteFile(descriptorSetFile, raw, 0o600))
+
+	h, err := newGRPCHandler("items", config.GRPCHandler{
+		Target:            addr,
+		Method:            "/test.v1.ItemService/ListItems",
+		DescriptorSetFile: descriptorSetFile,
+		Body:              `{"query": "books"}`,
+		Plaintext:         true,
+	}, false, zerolog.Nop())
+	require.NoError(t, err)
+
+	// without results_field, the whole response is a single result
+	results, err := h.Handle(context.Background(), map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{"items": json.RawMessage(`[]`)}}, results)
+
+	_, err = newGRPCHandler("items", config.GRPCHandler{
+		Target:            addr,
+		Method:            "test.v1.ItemService/DeleteItems",
+		DescriptorSetFile: descriptorSetFile,
+		Plaintext:         true,
+	}, false, zerolog.Nop())
+	assert.ErrorContains(t, err, "'DeleteItems' method not found in 'test.v1.ItemService' service")
+}
+
+func TestGRPCHandler_Retries(t *testing.T) {
+	srv := &testItemServer{failures: 2}
+	addr, _ := startTestItemServer(t, srv)
+
+	h, err := newGRPCHandler("items", config.GRPCHandler{
+		Target:         addr,
+		Method:         "test.v1.ItemService/ListItems",
+		Plaintext:      true,
+		Retries:        2,
+		RetryableCodes: []string{"UNAVAILABLE"},
+	}, false, zerolog.Nop())
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), srv.calls.Load())
+
+	srv = &testItemServer{failures: 1}
+	addr, _ = startTestItemServer(t, srv)
+	h, err = newGRPCHandler("items", config.GRPCHandler{
+		Target:         addr,
+		Method:         "test.v1.ItemService/ListItems",
+		Plaintext:      true,
+		Retries:        2,
+		RetryableCodes: []string{"DEADLINE_EXCEEDED"},
+	}, false, zerolog.Nop())
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.ErrorContains(t, err, "failed to execute gRPC request: rpc error: code = Unavailable desc = try again")
+	assert.Equal(t, int32(1), srv.calls.Load())
+}
+
+func TestGRPCHandler_DefaultRetryableCodes(t *testing.T) {
+	tests := []struct {
+		name          string
+		code          codes.Code
+		expectedCalls int32
+		expectedErr   string
+	}{
+		{name: "Unavailable", code: codes.Unavailable, expectedCalls: 2},
+		{name: "ResourceExhausted", code: codes.ResourceExhausted, expectedCalls: 2},
+		{
+			name:          "InvalidArgument",
+			code:          codes.InvalidArgument,
+			expectedCalls: 1,
+			expectedErr:   "failed to execute gRPC request: rpc error: code = InvalidArgument desc = call failed",
+		},
+		{
+			name:          "NotFound",
+			code:          codes.NotFound,
+			expectedCalls: 1,
+			expectedErr:   "failed to execute gRPC request: rpc error: code = NotFound desc = call failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &testItemServer{failures: 1, failureCode: tt.code}
+			addr, _ := startTestItemServer(t, srv)
+
+			h, err := newGRPCHandler("items", config.GRPCHandler{
+				Target:        addr,
+				Method:        "test.v1.ItemService/ListItems",
+				Plaintext:     true,
+				Retries:       2,
+				RetryInterval: time.Millisecond,
+			}, false, zerolog.Nop())
+			require.NoError(t, err)
+
+			_, err = h.Handle(context.Background(), map[string]string{})
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedCalls, srv.calls.Load())
+		})
+	}
+}
+
+func TestGRPCHandler_InvalidBody(t *testing.T) {
+	srv := &testItemServer{}
+	addr, _ := startTestItemServer(t, srv)
+
+	h, err := newGRPCHandler("items", config.GRPCHandler{
+		Target:    addr,
+		Method:    "test.v1.ItemService/ListItems",
+		Body:      `{"unknown": 1}`,
+		Plaintext: true,
+		Retries:   3,
+	}, false, zerolog.Nop())
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.ErrorContains(t, err, "failed to decode body into 'test.v1.ListItemsRequest' message")
+	assert.Equal(t, int32(0), srv.calls.Load())
+}
+
+func TestGRPCHandler_ReflectionRetries(t *testing.T) {
+	srv := &testItemServer{reflectionFailures: 1}
+	addr, _ := startTestItemServer(t, srv)
+
+	h, err := newGRPCHandler("items", config.GRPCHandler{
+		Target:         addr,
+		Method:         "test.v1.ItemService/ListItems",
+		Plaintext:      true,
+		Retries:        1,
+		RetryableCodes: []string{"UNAVAILABLE"},
+	}, false, zerolog.Nop())
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), srv.reflectionCalls.Load())
+	assert.Equal(t, int32(1), srv.calls.Load())
+
+	// the method is only resolved once
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), srv.reflectionCalls.Load())
+
+	srv = &testItemServer{reflectionFailures: 1}
+	addr, _ = startTestItemServer(t, srv)
+	h, err = newGRPCHandler("items", config.GRPCHandler{
+		Target:         addr,
+		Method:         "test.v1.ItemService/ListItems",
+		Plaintext:      true,
+		Retries:        1,
+		RetryableCodes: []string{"DEADLINE_EXCEEDED"},
+	}, false, zerolog.Nop())
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.ErrorContains(t, err, "failed to execute gRPC request: failed to resolve gRPC method: server reflection failed")
+	assert.Equal(t, int32(0), srv.calls.Load())
+}
+
+func TestGRPCHandler_DryRun(t *testing.T) {
+	srv := &testItemServer{}
+	addr, _ := startTestItemServer(t, srv)
+
+	logs := &bytes.Buffer{}
+	h, err := newGRPCHandler("items", config.GRPCHandler{
+		Target:    addr,
+		Method:    "test.v1.ItemService/ListItems",
+		Body:      `{"query": {{ source.query }}}`,
+		Metadata:  map[string]string{"X-Tenant": "acme"},
+		Plaintext: true,
+	}, true, zerolog.New(logs))
+	require.NoError(t, err)
+
+	results, err := h.Handle(context.Background(), map[string]string{"source.query": `"books"`})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{}}, results)
+	assert.Equal(t, int32(0), srv.calls.Load())
+	assert.Contains(t, logs.String(), `"method":"/test.v1.ItemService/ListItems"`)
+	assert.Contains(t, logs.String(), `"metadata":{"x-tenant":["acme"]}`)
+	assert.Contains(t, logs.String(), `books`)
+}
@@ -3,14 +3,21 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
 )
 
 type Handler interface {
@@ -18,12 +25,136 @@ type Handler interface {
 	Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error)
 }
 
-func newHandler(name string, cfg config.Handler) (Handler, error) {
+// streamHandler is a Handler that emits its results in batches as they arrive, e.g. page by page,
+// so the rest of the chain doesn't wait for all of them.
+type streamHandler interface {
+	Handler
+	HandleStream(ctx context.Context, data map[string]string, emit func(results []HandlerResult) error) error
+}
+
+// ackHandler is a Handler whose results are acknowledged once the rest of the chain processed them,
+// e.g. to commit a consumed message only after it was handled. err is the error of the rest of the chain.
+type ackHandler interface {
+	Handler
+	Ack(ctx context.Context, result HandlerResult, err error)
+}
+
+// flushHandler is a Handler that collects records during a run and processes them once the run ended,
+// e.g. to send a digest of them.
+type flushHandler interface {
+	Handler
+	Flush(ctx context.Context) error
+}
+
+// circuitBreakerHandler is implemented by handlers that can have a circuit breaker.
+type circuitBreakerHandler interface {
+	Handler
+	// circuitBreakerState returns false if the circuit breaker is disabled.
+	circuitBreakerState() (circuitState, bool)
+}
+
+// transportHandler is a Handler that sends HTTP requests, so its transport can be replaced, e.g. to mock them.
+type transportHandler interface {
+	Handler
+	setTransport(transport http.RoundTripper)
+}
+
+// handlerEnv holds what is shared by the handlers of a data pipe.
+type handlerEnv struct {
+	logger     zerolog.Logger
+	transports *transportPool
+	limiters   limiterGroups
+	// dryRun makes handlers without a dry-run replacement avoid side effects, e.g. committing offsets
+	dryRun bool
+}
+
+func newHandlerEnv(logger zerolog.Logger, limiterGroups map[string]config.LimiterGroup) (*handlerEnv, error) {
+	limiters, err := newLimiterGroups(limiterGroups)
+	if err != nil {
+		return nil, err
+	}
+	return &handlerEnv{
+		logger:     logger,
+		transports: newTransportPool(),
+		limiters:   limiters,
+	}, nil
+}
+
+func newHandler(name string, cfg config.Handler, env *handlerEnv) (Handler, error) {
+	logger := env.logger.With().Str("handler", name).Logger()
+
 	switch cfg.Type {
 	case "", config.HandlerTypeHTTP:
-		return newHTTPHandler(name, cfg.HTTPHandler), nil
+		if cfg.Limits.MaxConcurrency > 0 {
+			// max_concurrency replaces the serial execution of non-parallel handlers
+			cfg.HTTPHandler.ParallelRun = true
+		}
+		h := newHTTPHandler(name, cfg.HTTPHandler)
+		transport, err := env.transports.get(cfg.HTTPHandler)
+		if err != nil {
+			return nil, err
+		}
+		h.httpClient.Transport = transport
+		h.breaker = newCircuitBreaker(cfg.HTTPHandler.CircuitBreaker, logger)
+		h.logger = logger
+		h.limiters, err = env.limiters.limitersFor(cfg.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
 	case config.HandlerTypeFilter:
 		return newFilterHandler(name, cfg.FilterHandler), nil
+	case config.HandlerTypeKafka:
+		return newKafkaHandler(name, cfg.KafkaHandler, env, logger)
+	case config.HandlerTypeAMQP:
+		return newAMQPHandler(name, cfg.AMQPHandler, env, logger)
+	case config.HandlerTypeRedis:
+		return newRedisHandler(name, cfg.RedisHandler, env, logger)
+	case config.HandlerTypeWebhook:
+		return newWebhookHandler(name, cfg.WebhookHandler, logger), nil
+	case config.HandlerTypeSQL:
+		h, err := newSQLHandler(name, cfg.SQLHandler, env.dryRun, logger)
+		if err != nil {
+			return nil, err
+		}
+		h.limiters, err = env.limiters.limitersFor(cfg.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case config.HandlerTypeS3:
+		limiters, err := env.limiters.limitersFor(cfg.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return newS3Handler(name, cfg.S3Handler, limiters, env.dryRun, logger)
+	case config.HandlerTypeGraphQL:
+		transport, err := env.transports.get(config.HTTPHandler{TLS: cfg.GraphQLHandler.TLS})
+		if err != nil {
+			return nil, err
+		}
+		h := newGraphQLHandler(name, cfg.GraphQLHandler, transport, env.dryRun, logger)
+		h.limiters, err = env.limiters.limitersFor(cfg.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case config.HandlerTypeEmail:
+		limiters, err := env.limiters.limitersFor(cfg.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return newEmailHandler(name, cfg.EmailHandler, limiters, env.dryRun, logger)
+	case config.HandlerTypeGRPC:
+		h, err := newGRPCHandler(name, cfg.GRPCHandler, env.dryRun, logger)
+		if err != nil {
+			return nil, err
+		}
+		h.limiters, err = env.limiters.limitersFor(cfg.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
 	default:
 		return nil, fmt.Errorf("unknown handler type: %s", cfg.Type)
 	}
@@ -36,6 +167,13 @@ type httpHandler struct {
 	cfg  config.HTTPHandler
 
 	httpClient *http.Client
+	auth       authenticator
+	retries    *retryPolicy
+	breaker    *circuitBreaker
+	limiters   []*limiter
+	logger     zerolog.Logger
+
+	expectedCodes []int
 }
 
 func newHTTPHandler(name string, cfg config.HTTPHandler) *httpHandler {
@@ -44,35 +182,103 @@ func newHTTPHandler(name string, cfg config.HTTPHandler) *httpHandler {
 		timeout = cfg.Timeout
 	}
 
-	if cfg.ExpectedResponseCode == 0 {
+	if cfg.ExpectedResponseCode == 0 && len(cfg.ExpectedResponseCodes) == 0 {
 		cfg.ExpectedResponseCode = http.StatusOK
 	}
 
+	httpClient := &http.Client{
+		Timeout: timeout,
+	}
+
 	return &httpHandler{
-		name: name,
-		cfg:  cfg,
-		httpClient: &http.Client{
-			Timeout: timeout,
-		},
+		name:       name,
+		cfg:        cfg,
+		httpClient: httpClient,
+		auth:       newAuthenticator(cfg.Auth, httpClient),
+		retries:    newRetryPolicy(cfg),
+		logger:     zerolog.Nop(),
+
+		expectedCodes: cfg.ExpectedCodes(),
 	}
 }
 
+func (h *httpHandler) setTransport(transport http.RoundTripper) {
+	h.httpClient.Transport = transport
+}
+
 func (h *httpHandler) Name() string {
 	return h.name
 }
 
 func (h *httpHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	var results []HandlerResult
+	err := h.HandleStream(ctx, data, func(page []HandlerResult) error {
+		results = append(results, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HandleStream sends the request, following the pagination if it's configured, and emits the results of each page.
+func (h *httpHandler) HandleStream(
+	ctx context.Context, data map[string]string, emit func(results []HandlerResult) error,
+) error {
+	pages := newPaginator(h.cfg.Pagination)
+	for {
+		resp, err := h.requestWithRetries(ctx, data, pages.request())
+		if err != nil {
+			if pages.count() > 0 {
+				return fmt.Errorf("page %d: %s", pages.count()+1, err)
+			}
+			return err
+		}
+
+		if err := emit(resp.results); err != nil {
+			return err
+		}
+
+		if !pages.advance(resp) {
+			if pages.capped() {
+				h.logger.Warn().Int("max_pages", pages.count()).Msg("pagination stopped at max pages")
+			}
+			return nil
+		}
+	}
+}
+
+func (h *httpHandler) requestWithRetries(
+	ctx context.Context, data map[string]string, page pageRequest,
+) (*httpResponse, error) {
+	start := time.Now()
 	var lastErr error
 	for attempt := 0; attempt <= h.cfg.Retries; attempt++ {
-		results, err := h.executeRequest(ctx, data)
+		attemptData := copyMap(data)
+		attemptData[config.RetryAttemptPlaceholder] = strconv.Itoa(attempt + 1)
+
+		resp, err := h.executeRequest(ctx, attemptData, page)
 		if err == nil {
-			return results, nil
+			return resp, nil
 		}
 
 		lastErr = err
 
+		if !h.retries.isRetryable(err) {
+			return nil, fmt.Errorf("failed to execute HTTP request: %s", err)
+		}
+
 		if attempt < h.cfg.Retries {
-			timer := time.NewTimer(h.cfg.RetryInterval)
+			interval := h.retries.backoff(attempt+1, err)
+			maxElapsedTime := h.cfg.RetryPolicy.MaxElapsedTime
+			if maxElapsedTime > 0 && time.Since(start)+interval > maxElapsedTime {
+				return nil, fmt.Errorf(
+					"failed to execute HTTP request, retry deadline exceeded after %d attempts: %s", attempt+1, err,
+				)
+			}
+
+			timer := time.NewTimer(interval)
 			select {
 			case <-timer.C:
 				// Retry
@@ -86,37 +292,166 @@ func (h *httpHandler) Handle(ctx context.Context, data map[string]string) ([]Han
 	return nil, fmt.Errorf("failed to execute HTTP request after %d attempts: %s", h.cfg.Retries, lastErr)
 }
 
-func (h *httpHandler) executeRequest(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+func (h *httpHandler) circuitBreakerState() (circuitState, bool) {
+	if h.breaker == nil {
+		return "", false
+	}
+	return h.breaker.currentState(), true
+}
+
+func (h *httpHandler) executeRequest(
+	ctx context.Context, data map[string]string, page pageRequest,
+) (*httpResponse, error) {
+	req, release, err := h.prepareRequest(ctx, data, page)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if h.breaker == nil {
+		return h.sendAuthenticatedRequest(ctx, req)
+	}
+
+	// the breaker is only asked once the request is about to be sent, so requests that fail before that
+	// don't take a half-open probe and their outcome is not recorded
+	generation, ok := h.breaker.allow()
+	if !ok {
+		return nil, &requestError{
+			msg:       "circuit breaker is open",
+			permanent: true,
+		}
+	}
+
+	resp, err := h.sendAuthenticatedRequest(ctx, req)
+	if err != nil && ctx.Err() != nil {
+		// a cancelled request says nothing about the service
+		h.breaker.cancel(generation)
+		return nil, err
+	}
+	h.breaker.record(generation, isCircuitBreakerFailure(err))
+
+	return resp, err
+}
+
+// isCircuitBreakerFailure reports whether an error means the server is unhealthy: a 5xx response, a timeout
+// or a transport error. Other errors, e.g. 4xx responses, are caused by the record.
+func isCircuitBreakerFailure(err error) bool {
+	reqErr := &requestError{}
+	if !errors.As(err, &reqErr) || reqErr.permanent {
+		return false
+	}
+	if reqErr.statusCode != 0 {
+		return reqErr.statusCode >= 500
+	}
+	return reqErr.class != config.RetryableErrorInvalidResponse
+}
+
+// httpResponse is a response of an HTTP handler request.
+type httpResponse struct {
+	results []HandlerResult
+	url     *url.URL
+	header  http.Header
+	body    []byte
+}
+
+// prepareRequest waits for the limiters and renders the request, release must be called once it's sent.
+func (h *httpHandler) prepareRequest(
+	ctx context.Context, data map[string]string, page pageRequest,
+) (*http.Request, func(), error) {
+	release, err := acquireLimiters(ctx, h.limiters)
+	if err != nil {
+		return nil, nil, &requestError{
+			msg:       err.Error(),
+			permanent: true,
+		}
+	}
 	if !h.cfg.ParallelRun {
 		h.busyMux.Lock()
-		defer h.busyMux.Unlock()
+		releaseLimiters := release
+		release = func() {
+			h.busyMux.Unlock()
+			releaseLimiters()
+		}
 	}
 
 	req, err := h.createRequest(ctx, data)
 	if err != nil {
+		release()
+		return nil, nil, &requestError{
+			msg:       fmt.Sprintf("failed to create HTTP request: %s", err),
+			permanent: true,
+		}
+	}
+	page.apply(req)
+
+	if h.auth != nil {
+		err = h.auth.authenticate(ctx, req)
+		if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("failed to authenticate HTTP request: %s", err)
+		}
+	}
+	return req, release, nil
+}
+
+// sendAuthenticatedRequest sends the request, and once more with a new token if the server rejected the cached one.
+func (h *httpHandler) sendAuthenticatedRequest(ctx context.Context, req *http.Request) (*httpResponse, error) {
+	resp, err := h.sendRequest(req)
+	reqErr := &requestError{}
+	if !errors.As(err, &reqErr) || reqErr.statusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	auth, ok := h.auth.(tokenAuthenticator)
+	if !ok || req.GetBody == nil {
+		return resp, err
+	}
+
+	auth.invalidate(req)
+	retry := req.Clone(ctx)
+	if retry.Body, err = req.GetBody(); err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %s", err)
 	}
+	if err := auth.authenticate(ctx, retry); err != nil {
+		return nil, fmt.Errorf("failed to authenticate HTTP request: %s", err)
+	}
+	return h.sendRequest(retry)
+}
 
+func (h *httpHandler) sendRequest(req *http.Request) (*httpResponse, error) {
 	resp, err := h.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send HTTP request: %s", err)
+		return nil, &requestError{
+			msg:   fmt.Sprintf("failed to send HTTP request: %s", err),
+			class: classifyError(err),
+		}
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != h.cfg.ExpectedResponseCode {
-		return nil, fmt.Errorf(
-			"unexpected response code: got %d, expected %d",
-			resp.StatusCode, h.cfg.ExpectedResponseCode,
-		)
+	if !containsInt(h.expectedCodes, resp.StatusCode) {
+		return h.unexpectedResponse(req, resp)
 	}
 
-	respBody := handlerResponseBody{}
-	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode response body: %s", err)
+		return nil, &requestError{
+			msg:   fmt.Sprintf("failed to read response body: %s", err),
+			class: classifyError(err),
+		}
 	}
 
-	return respBody.Results, nil
+	results, err := decodeResponse(resp, body, h.cfg.Response)
+	if err != nil {
+		return nil, &requestError{
+			msg:   err.Error(),
+			class: config.RetryableErrorInvalidResponse,
+		}
+	}
+	return &httpResponse{
+		results: results,
+		url:     req.URL,
+		header:  resp.Header,
+		body:    body,
+	}, nil
 }
 
 func (h *httpHandler) createRequest(ctx context.Context, data map[string]string) (*http.Request, error) {
@@ -136,11 +471,19 @@ func (h *httpHandler) createRequest(ctx context.Context, data map[string]string)
 	}
 
 	for key, value := range h.cfg.Headers {
+		key, value, err := replaceKeyValuePlaceholders(key, value, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in header: %s", err)
+		}
 		req.Header.Set(key, value)
 	}
 
 	q := req.URL.Query()
 	for key, value := range h.cfg.QueryParams {
+		key, value, err := replaceKeyValuePlaceholders(key, value, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in query param: %s", err)
+		}
 		q.Add(key, value)
 	}
 	req.URL.RawQuery = q.Encode()
@@ -148,17 +491,129 @@ func (h *httpHandler) createRequest(ctx context.Context, data map[string]string)
 	return req, nil
 }
 
+// maxErrorBodySize is the number of response body bytes added to the error of an unexpected response code.
+const maxErrorBodySize = 1024
+
+// unexpectedResponse handles a response with an unexpected code: it applies the outcome mapped to the code,
+// or returns an error with the beginning of the response body.
+func (h *httpHandler) unexpectedResponse(req *http.Request, resp *http.Response) (*httpResponse, error) {
+	outcome := h.cfg.ResponseCodeOutcomes[resp.StatusCode]
+	switch outcome {
+	case config.ResponseCodeOutcomeSkipRecord:
+		h.logger.Debug().Int("status_code", resp.StatusCode).Msg("record skipped by response code")
+		return &httpResponse{url: req.URL, header: resp.Header}, nil
+	case config.ResponseCodeOutcomeTreatAsSuccess:
+		results := []HandlerResult{{}}
+		captureResponseFields(results, resp, h.cfg.Response)
+		return &httpResponse{results: results, url: req.URL, header: resp.Header}, nil
+	}
+
+	expected := strconv.Itoa(h.expectedCodes[0])
+	if len(h.expectedCodes) > 1 {
+		codes := make([]string, 0, len(h.expectedCodes))
+		for _, code := range h.expectedCodes {
+			codes = append(codes, strconv.Itoa(code))
+		}
+		expected = "one of " + strings.Join(codes, ", ")
+	}
+	msg := fmt.Sprintf("unexpected response code: got %d, expected %s", resp.StatusCode, expected)
+
+	errBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize+1))
+	if body := strings.TrimSpace(string(errBody)); body != "" {
+		if len(errBody) > maxErrorBodySize {
+			body = strings.TrimSpace(string(errBody[:maxErrorBodySize])) + "..."
+		}
+		msg += fmt.Sprintf(", response body: %s", body)
+	}
+
+	return nil, &requestError{
+		msg:        msg,
+		statusCode: resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		retryable:  outcome == config.ResponseCodeOutcomeRetry,
+	}
+}
+
+// replaceKeyValuePlaceholders replaces placeholders in a header or query param.
+// JSON strings are inserted without quotes, as both key and value are plain text.
+func replaceKeyValuePlaceholders(key, value string, data map[string]string) (string, string, error) {
+	newKey, ok := replaceTextPlaceholders(key, data)
+	if !ok {
+		return "", "", fmt.Errorf("'%s' key: some data not found", key)
+	}
+	newValue, ok := replaceTextPlaceholders(value, data)
+	if !ok {
+		return "", "", fmt.Errorf("'%s' value: some data not found", key)
+	}
+	return newKey, newValue, nil
+}
+
 // replacePlaceholders replaces placeholders in the format {{ key }} with the value from the data map.
 func replacePlaceholders(s string, data map[string]string) (string, bool) {
+	return renderPlaceholders(s, data, func(value string) string {
+		return value
+	})
+}
+
+// replaceTextPlaceholders works like replacePlaceholders, but JSON strings are inserted without quotes.
+func replaceTextPlaceholders(s string, data map[string]string) (string, bool) {
+	return renderPlaceholders(s, data, textValue)
+}
+
+// replaceHTMLPlaceholders works like replaceTextPlaceholders, but the values are HTML-escaped,
+// so they can't inject markup into an HTML template.
+func replaceHTMLPlaceholders(s string, data map[string]string) (string, bool) {
+	return renderPlaceholders(s, data, func(value string) string {
+		return html.EscapeString(textValue(value))
+	})
+}
+
+// textValue returns the content of a JSON string, other values are returned as they are.
+func textValue(value string) string {
+	var str string
+	if err := json.Unmarshal([]byte(value), &str); err == nil {
+		return str
+	}
+	return value
+}
+
+// lookupPlaceholder returns the value of the key, or of the field at the dot-separated path inside a JSON value,
+// e.g. '{{ orders.body.customer.id }}' for the 'customer.id' field of the 'orders.body' value.
+func lookupPlaceholder(data map[string]string, key string) (string, bool) {
+	if value, ok := data[key]; ok {
+		return value, true
+	}
+
+	// keys may contain dots, so the longest key that prefixes the path wins
+	prefix := ""
+	for k := range data {
+		if strings.HasPrefix(key, k+".") && len(k) > len(prefix) {
+			prefix = k
+		}
+	}
+	if prefix == "" || !json.Valid([]byte(data[prefix])) {
+		return "", false
+	}
+	value := json.RawMessage(data[prefix])
+	for _, field := range strings.Split(strings.TrimPrefix(key, prefix+"."), ".") {
+		var err error
+		if value, err = lookupJSON(value, field); err != nil {
+			return "", false
+		}
+	}
+	return string(value), true
+}
+
+func renderPlaceholders(s string, data map[string]string, format func(value string) string) (string, bool) {
 	re := regexp.MustCompile(`\{\{\s*([^\s}]+)\s*\}\}`)
 
 	result := re.ReplaceAllStringFunc(s, func(m string) string {
 		key := strings.TrimSpace(m[2 : len(m)-2])
-		value, exists := data[key]
+		value, exists := lookupPlaceholder(data, key)
 		if !exists {
 			return m
 		}
-		return value
+		return format(value)
 	})
 
 	if re.MatchString(result) {
@@ -43,6 +43,7 @@ func TestHandler_Handle(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, "test-body", string(reqBody))
 
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			_, err = w.Write([]byte(`{"results":[{"output":"test-output"}]}`))
 			assert.NoError(t, err)
@@ -99,6 +100,76 @@ func TestHandler_Handle(t *testing.T) {
 		assert.Contains(t, err.Error(), "failed to replace placeholders in body")
 	})
 
+	t.Run("Placeholders in headers and query params", func(t *testing.T) {
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "tenant-1", r.Header.Get("X-Tenant-ID"))
+			assert.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+			assert.Equal(t, "yes", r.Header.Get("X-Flag-tenant-1"))
+			assert.Equal(t, "id=a%26b+c&limit=10&tenant-1=true", r.URL.RawQuery)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(`{"results":[]}`))
+			assert.NoError(t, err)
+		}))
+		defer mockServer.Close()
+
+		cfg := config.HTTPHandler{
+			Method: "GET",
+			URL:    mockServer.URL,
+			Headers: map[string]string{
+				"X-Tenant-ID":                     "{{ data-source.tenant }}",
+				"Authorization":                   "Bearer {{ data-source.token }}",
+				"X-Flag-{{ data-source.tenant }}": "yes",
+			},
+			QueryParams: map[string]string{
+				"id":                       "{{ data-source.id }}",
+				"limit":                    "{{ data-source.limit }}",
+				"{{ data-source.tenant }}": "true",
+			},
+		}
+		h := newHTTPHandler("test-handler", cfg)
+
+		data := map[string]string{
+			"data-source.tenant": `"tenant-1"`,
+			"data-source.token":  `"abc"`,
+			"data-source.id":     `"a&b c"`,
+			"data-source.limit":  `10`,
+		}
+
+		result, err := h.Handle(context.Background(), data)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(result))
+	})
+
+	t.Run("Failed to replace placeholders in header", func(t *testing.T) {
+		cfg := config.HTTPHandler{
+			Method:  "GET",
+			URL:     "http://example.com",
+			Headers: map[string]string{"X-Tenant-ID": "{{ missing-placeholder }}"},
+		}
+		h := newHTTPHandler("test-handler", cfg)
+
+		result, err := h.Handle(context.Background(), nil)
+		assert.Error(t, err)
+		assert.Nil(t, result)
+		assert.Contains(t, err.Error(), "failed to replace placeholders in header: 'X-Tenant-ID' value")
+	})
+
+	t.Run("Failed to replace placeholders in query param key", func(t *testing.T) {
+		cfg := config.HTTPHandler{
+			Method:      "GET",
+			URL:         "http://example.com",
+			QueryParams: map[string]string{"{{ missing-placeholder }}": "value"},
+		}
+		h := newHTTPHandler("test-handler", cfg)
+
+		result, err := h.Handle(context.Background(), nil)
+		assert.Error(t, err)
+		assert.Nil(t, result)
+		assert.Contains(t, err.Error(), "failed to replace placeholders in query param: '{{ missing-placeholder }}' key")
+	})
+
 	t.Run("Failed HTTP request", func(t *testing.T) {
 		cfg := config.HTTPHandler{
 			Method: "GET",
@@ -137,6 +208,7 @@ func TestHandler_Handle(t *testing.T) {
 
 	t.Run("Failed to decode response body", func(t *testing.T) {
 		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			_, err := w.Write([]byte(`invalid json`))
 			assert.NoError(t, err)
@@ -165,6 +237,7 @@ func TestHandler_Handle(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, "test-body", string(reqBody))
 
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			_, err = w.Write([]byte(`{"results":[{"output": {"a":"b","c":"d"}}]}`))
 			assert.NoError(t, err)
@@ -199,6 +272,7 @@ func TestHandler_Handle(t *testing.T) {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			default:
+				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				_, err := w.Write([]byte(`{"results":[{"output":"test-output"}]}`))
 				assert.NoError(t, err)
@@ -314,4 +388,49 @@ func TestReplacePlaceholders(t *testing.T) {
 		assert.False(t, success)
 		assert.Equal(t, "", result)
 	})
+
+	t.Run("Nested fields", func(t *testing.T) {
+		s := "{{ orders.body.customer.id }} {{ orders.body.items.0 }} {{ orders.body.missing }}"
+		data := map[string]string{"orders.body": `{"customer": {"id": 7}, "items": ["book"]}`}
+
+		result, success := replacePlaceholders(s, data)
+
+		assert.True(t, success)
+		assert.Equal(t, `7 "book" null`, result)
+	})
+
+	t.Run("Nested field of a handler name with dots", func(t *testing.T) {
+		s := "{{ shop.orders.body.id }}"
+		data := map[string]string{"shop.orders.body": `{"id": 7}`, "shop.orders": `{"body": {"id": 1}}`}
+
+		result, success := replacePlaceholders(s, data)
+
+		assert.True(t, success)
+		assert.Equal(t, "7", result)
+	})
+
+	t.Run("Nested field of a value that is not an object", func(t *testing.T) {
+		s := "{{ orders.body.id }}"
+		data := map[string]string{"orders.body": `"text"`}
+
+		result, success := replacePlaceholders(s, data)
+
+		assert.False(t, success)
+		assert.Equal(t, "", result)
+	})
+}
+
+func TestReplaceTextPlaceholders(t *testing.T) {
+	data := map[string]string{
+		"data-source.string": `"value"`,
+		"data-source.number": `12`,
+		"data-source.object": `{"a":"b"}`,
+	}
+
+	result, success := replaceTextPlaceholders(
+		"{{ data-source.string }} {{ data-source.number }} {{ data-source.object }}", data,
+	)
+
+	assert.True(t, success)
+	assert.Equal(t, `value 12 {"a":"b"}`, result)
 }
@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultKafkaMaxMessages = 100
+	defaultKafkaWaitTime    = 10 * time.Second
+	defaultKafkaTimeout     = 15 * time.Second
+)
+
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+func newKafkaHandler(name string, cfg config.KafkaHandler, env *handlerEnv, logger zerolog.Logger) (Handler, error) {
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true}
+	transport := &kafka.Transport{}
+	if cfg.TLS != nil {
+		tlsConfig, err := newTLSConfig(*cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		dialer.TLS = tlsConfig
+		transport.TLS = tlsConfig
+	}
+
+	switch cfg.Mode {
+	case config.KafkaModeConsume:
+		startOffset := kafka.FirstOffset
+		if cfg.StartOffset == config.KafkaStartOffsetLast {
+			startOffset = kafka.LastOffset
+		}
+		return newKafkaConsumer(name, cfg, env.dryRun, logger, func() kafkaReader {
+			return kafka.NewReader(kafka.ReaderConfig{
+				Brokers:     cfg.Brokers,
+				GroupID:     cfg.GroupID,
+				Topic:       cfg.Topic,
+				StartOffset: startOffset,
+				Dialer:      dialer,
+			})
+		}), nil
+	case config.KafkaModeProduce:
+		return newKafkaProducer(name, cfg, env.dryRun, logger, &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			Transport:    transport,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown kafka mode: %s", cfg.Mode)
+	}
+}
+
+// kafkaConsumer emits a result per consumed message. The offset of a message is only committed after
+// the rest of the chain succeeded for it and for all previous messages of its partition.
+// If a message fails, the reader is restarted before the next run, so it's consumed again from the last committed offset.
+type kafkaConsumer struct {
+	name      string
+	cfg       config.KafkaHandler
+	dryRun    bool
+	logger    zerolog.Logger
+	newReader func() kafkaReader
+
+	mux        sync.Mutex
+	reader     kafkaReader
+	partitions map[int]*partitionOffsets
+	restart    bool
+}
+
+// partitionOffsets tracks the messages of a partition that are not committed yet, in the order they were consumed.
+type partitionOffsets struct {
+	pending []kafka.Message
+	done    map[int64]bool
+}
+
+func newKafkaConsumer(
+	name string, cfg config.KafkaHandler, dryRun bool, logger zerolog.Logger, newReader func() kafkaReader,
+) *kafkaConsumer {
+	if cfg.MaxMessages == 0 {
+		cfg.MaxMessages = defaultKafkaMaxMessages
+	}
+	if cfg.WaitTime == 0 {
+		cfg.WaitTime = defaultKafkaWaitTime
+	}
+	return &kafkaConsumer{
+		name:       name,
+		cfg:        cfg,
+		dryRun:     dryRun,
+		logger:     logger,
+		newReader:  newReader,
+		partitions: map[int]*partitionOffsets{},
+	}
+}
+
+func (h *kafkaConsumer) Name() string {
+	return h.name
+}
+
+func (h *kafkaConsumer) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	var results []HandlerResult
+	err := h.HandleStream(ctx, data, func(messages []HandlerResult) error {
+		results = append(results, messages...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HandleStream emits the consumed messages one by one, until max messages are consumed
+// or no message arrives for the wait time.
+func (h *kafkaConsumer) HandleStream(
+	ctx context.Context, _ map[string]string, emit func(results []HandlerResult) error,
+) error {
+	reader := h.currentReader()
+	for i := 0; i < h.cfg.MaxMessages && !h.restarting(); i++ {
+		fetchCtx, cancel := context.WithTimeout(ctx, h.cfg.WaitTime)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				// no new messages
+				return nil
+			}
+			return fmt.Errorf("failed to fetch Kafka message: %s", err)
+		}
+
+		h.track(msg)
+		if err := emit([]HandlerResult{kafkaMessageResult(msg)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ack commits the offset of the message of the result once the rest of the chain succeeded for it.
+func (h *kafkaConsumer) Ack(ctx context.Context, result HandlerResult, chainErr error) {
+	partition, offset, err := kafkaResultOffset(result)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to acknowledge Kafka message")
+		return
+	}
+
+	h.mux.Lock()
+	if chainErr != nil {
+		h.restart = true
+		h.mux.Unlock()
+		h.logger.Warn().Int("partition", partition).Int64("offset", offset).
+			Msg("Kafka message failed, it will be consumed again from the last committed offset")
+		return
+	}
+
+	p := h.partitions[partition]
+	if p == nil {
+		h.mux.Unlock()
+		return
+	}
+	p.done[offset] = true
+
+	var commit *kafka.Message
+	for len(p.pending) > 0 && p.done[p.pending[0].Offset] {
+		commit = &p.pending[0]
+		delete(p.done, p.pending[0].Offset)
+		p.pending = p.pending[1:]
+	}
+	reader := h.reader
+	h.mux.Unlock()
+
+	if commit == nil {
+		return
+	}
+	if h.dryRun {
+		h.logger.Info().Int("partition", partition).Int64("offset", commit.Offset).
+			Msg("dry run: Kafka offset not committed")
+		return
+	}
+	if err := reader.CommitMessages(ctx, *commit); err != nil {
+		h.logger.Error().Err(err).Int("partition", partition).Int64("offset", commit.Offset).
+			Msg("failed to commit Kafka offset")
+	}
+}
+
+// currentReader returns the reader, restarting it if a message failed since the previous run.
+func (h *kafkaConsumer) currentReader() kafkaReader {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.restart && h.reader != nil {
+		if err := h.reader.Close(); err != nil {
+			h.logger.Error().Err(err).Msg("failed to close Kafka reader")
+		}
+		h.reader = nil
+	}
+	if h.reader == nil {
+		h.reader = h.newReader()
+		h.partitions = map[int]*partitionOffsets{}
+		h.restart = false
+	}
+	return h.reader
+}
+
+func (h *kafkaConsumer) restarting() bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.restart
+}
+
+func (h *kafkaConsumer) track(msg kafka.Message) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	p := h.partitions[msg.Partition]
+	if p == nil {
+		p = &partitionOffsets{done: map[int64]bool{}}
+		h.partitions[msg.Partition] = p
+	}
+	p.pending = append(p.pending, msg)
+}
+
+func kafkaMessageResult(msg kafka.Message) HandlerResult {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	result := HandlerResult{
+		"value":     jsonOrString(msg.Value),
+		"topic":     mustMarshal(msg.Topic),
+		"partition": json.RawMessage(strconv.Itoa(msg.Partition)),
+		"offset":    json.RawMessage(strconv.FormatInt(msg.Offset, 10)),
+		"headers":   mustMarshal(headers),
+		"timestamp": mustMarshal(msg.Time.UTC().Format(time.RFC3339Nano)),
+		"key":       json.RawMessage("null"),
+	}
+	if msg.Key != nil {
+		result["key"] = mustMarshal(string(msg.Key))
+	}
+	return result
+}
+
+func kafkaResultOffset(result HandlerResult) (int, int64, error) {
+	partition, err := strconv.Atoi(string(result["partition"]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid partition: %s", err)
+	}
+	offset, err := strconv.ParseInt(string(result["offset"]), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid offset: %s", err)
+	}
+	return partition, offset, nil
+}
+
+// jsonOrString returns the value as is if it's valid JSON, or as a JSON string otherwise.
+func jsonOrString(value []byte) json.RawMessage {
+	if json.Valid(value) {
+		return value
+	}
+	return mustMarshal(string(value))
+}
+
+func mustMarshal(v any) json.RawMessage {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+// kafkaProducer produces a message per record.
+type kafkaProducer struct {
+	name   string
+	cfg    config.KafkaHandler
+	dryRun bool
+	logger zerolog.Logger
+	writer kafkaWriter
+}
+
+func newKafkaProducer(
+	name string, cfg config.KafkaHandler, dryRun bool, logger zerolog.Logger, writer kafkaWriter,
+) *kafkaProducer {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultKafkaTimeout
+	}
+	return &kafkaProducer{
+		name:   name,
+		cfg:    cfg,
+		dryRun: dryRun,
+		logger: logger,
+		writer: writer,
+	}
+}
+
+func (h *kafkaProducer) Name() string {
+	return h.name
+}
+
+func (h *kafkaProducer) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	msg, err := h.createMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka message: %s", err)
+	}
+
+	if h.dryRun {
+		headers := map[string]string{}
+		for _, header := range msg.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		h.logger.Info().
+			Str("topic", msg.Topic).
+			Str("key", string(msg.Key)).
+			Interface("headers", headers).
+			Str("value", string(msg.Value)).
+			Msg("dry run: message recorded")
+		return []HandlerResult{{}}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+	if err := h.writer.WriteMessages(ctx, msg); err != nil {
+		return nil, fmt.Errorf("failed to produce Kafka message: %s", err)
+	}
+	return []HandlerResult{{}}, nil
+}
+
+func (h *kafkaProducer) createMessage(data map[string]string) (kafka.Message, error) {
+	topic, ok := replaceTextPlaceholders(h.cfg.Topic, data)
+	if !ok {
+		return kafka.Message{}, fmt.Errorf("failed to replace placeholders in topic: some data not found")
+	}
+	value, ok := replacePlaceholders(h.cfg.Value, data)
+	if !ok {
+		return kafka.Message{}, fmt.Errorf("failed to replace placeholders in value: some data not found")
+	}
+
+	msg := kafka.Message{Topic: topic, Value: []byte(value)}
+	if h.cfg.Key != "" {
+		key, ok := replaceTextPlaceholders(h.cfg.Key, data)
+		if !ok {
+			return kafka.Message{}, fmt.Errorf("failed to replace placeholders in key: some data not found")
+		}
+		msg.Key = []byte(key)
+	}
+	for key, value := range h.cfg.Headers {
+		key, value, err := replaceKeyValuePlaceholders(key, value, data)
+		if err != nil {
+			return kafka.Message{}, fmt.Errorf("failed to replace placeholders in header: %s", err)
+		}
+		msg.Headers = append(msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	return msg, nil
+}
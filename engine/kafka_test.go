@@ -0,0 +1,251 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKafkaReader returns the queued messages and waits for the context once there are none.
+type fakeKafkaReader struct {
+	mux       sync.Mutex
+	messages  []kafka.Message
+	committed []kafka.Message
+	closed    bool
+}
+
+func (r *fakeKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mux.Lock()
+	if len(r.messages) > 0 {
+		msg := r.messages[0]
+		r.messages = r.messages[1:]
+		r.mux.Unlock()
+		return msg, nil
+	}
+	r.mux.Unlock()
+
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeKafkaReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.committed = append(r.committed, msgs...)
+	return nil
+}
+
+func (r *fakeKafkaReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (r *fakeKafkaReader) committedOffsets() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var offsets []string
+	for _, msg := range r.committed {
+		offsets = append(offsets, fmt.Sprintf("%d:%d", msg.Partition, msg.Offset))
+	}
+	return offsets
+}
+
+func (r *fakeKafkaReader) lastCommitted() map[int]int64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	last := map[int]int64{}
+	for _, msg := range r.committed {
+		if offset, ok := last[msg.Partition]; !ok || msg.Offset > offset {
+			last[msg.Partition] = msg.Offset
+		}
+	}
+	return last
+}
+
+func testKafkaMessages(partition int, offsets ...int64) []kafka.Message {
+	var messages []kafka.Message
+	for _, offset := range offsets {
+		messages = append(messages, kafka.Message{
+			Topic:     "orders",
+			Partition: partition,
+			Offset:    offset,
+			Key:       []byte(fmt.Sprintf("key-%d", offset)),
+			Value:     []byte(fmt.Sprintf(`{"id":%d}`, offset)),
+		})
+	}
+	return messages
+}
+
+func TestKafkaConsumer_Handle(t *testing.T) {
+	reader := &fakeKafkaReader{messages: []kafka.Message{{
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    7,
+		Key:       []byte("order-1"),
+		Value:     []byte("not json"),
+		Headers:   []kafka.Header{{Key: "source", Value: []byte("shop")}},
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}}}
+	h := newKafkaConsumer("orders", config.KafkaHandler{WaitTime: 10 * time.Millisecond}, false, zerolog.Nop(),
+		func() kafkaReader { return reader })
+
+	results, err := h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{
+		"key":       json.RawMessage(`"order-1"`),
+		"value":     json.RawMessage(`"not json"`),
+		"headers":   json.RawMessage(`{"source":"shop"}`),
+		"topic":     json.RawMessage(`"orders"`),
+		"partition": json.RawMessage(`2`),
+		"offset":    json.RawMessage(`7`),
+		"timestamp": json.RawMessage(`"2024-01-02T03:04:05Z"`),
+	}}, results)
+}
+
+func TestKafkaConsumer_MaxMessages(t *testing.T) {
+	reader := &fakeKafkaReader{messages: testKafkaMessages(0, 0, 1, 2)}
+	h := newKafkaConsumer("orders", config.KafkaHandler{MaxMessages: 2, WaitTime: time.Second}, false, zerolog.Nop(),
+		func() kafkaReader { return reader })
+
+	results, err := h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, json.RawMessage(`{"id":0}`), results[0]["value"])
+}
+
+func TestKafkaConsumer_CommitsAfterChain(t *testing.T) {
+	messages := append(testKafkaMessages(0, 0, 1, 2), testKafkaMessages(1, 10, 11)...)
+	readers := []*fakeKafkaReader{{messages: messages}, {}}
+	newReaders := 0
+	h := newKafkaConsumer("orders", config.KafkaHandler{WaitTime: 10 * time.Millisecond}, false, zerolog.Nop(),
+		func() kafkaReader {
+			newReaders++
+			return readers[newReaders-1]
+		})
+
+	sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		if data["orders.offset"] == "1" {
+			return nil, fmt.Errorf("sink is down")
+		}
+		return []HandlerResult{}, nil
+	}}
+
+	err := runHandlerPipe(context.Background(), nil, []Handler{h, sink}, nil)
+	assert.ErrorContains(t, err, "sink is down")
+
+	// offset 1 failed, so only offset 0 of partition 0 is committed, other partitions are not affected
+	assert.Equal(t, map[int]int64{0: 0, 1: 11}, readers[0].lastCommitted())
+
+	// the reader is restarted on the next run, to consume the failed message again
+	err = runHandlerPipe(context.Background(), nil, []Handler{h, sink}, nil)
+	assert.NoError(t, err)
+	assert.True(t, readers[0].closed)
+	assert.Equal(t, 2, newReaders)
+}
+
+func TestKafkaConsumer_AckOutOfOrder(t *testing.T) {
+	reader := &fakeKafkaReader{}
+	h := newKafkaConsumer("orders", config.KafkaHandler{}, false, zerolog.Nop(), func() kafkaReader { return reader })
+	h.currentReader()
+
+	messages := testKafkaMessages(0, 5, 6, 7)
+	for _, msg := range messages {
+		h.track(msg)
+	}
+
+	h.Ack(context.Background(), kafkaMessageResult(messages[1]), nil)
+	assert.Empty(t, reader.committedOffsets())
+
+	h.Ack(context.Background(), kafkaMessageResult(messages[0]), nil)
+	assert.Equal(t, []string{"0:6"}, reader.committedOffsets())
+
+	h.Ack(context.Background(), kafkaMessageResult(messages[2]), nil)
+	assert.Equal(t, []string{"0:6", "0:7"}, reader.committedOffsets())
+}
+
+func TestKafkaConsumer_DryRunDoesNotCommit(t *testing.T) {
+	reader := &fakeKafkaReader{}
+	h := newKafkaConsumer("orders", config.KafkaHandler{}, true, zerolog.Nop(), func() kafkaReader { return reader })
+	h.currentReader()
+
+	msg := testKafkaMessages(0, 1)[0]
+	h.track(msg)
+	h.Ack(context.Background(), kafkaMessageResult(msg), nil)
+	assert.Empty(t, reader.committedOffsets())
+}
+
+type fakeKafkaWriter struct {
+	mux      sync.Mutex
+	messages []kafka.Message
+}
+
+func (w *fakeKafkaWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func TestKafkaProducer_Handle(t *testing.T) {
+	cfg := config.KafkaHandler{
+		Topic:   "orders-{{ source.region }}",
+		Key:     "{{ source.id }}",
+		Value:   `{"id": {{ source.id }}, "status": "new"}`,
+		Headers: map[string]string{"x-source": "{{ source.name }}"},
+	}
+	data := map[string]string{
+		"source.region": `"eu"`,
+		"source.id":     `42`,
+		"source.name":   `"shop"`,
+	}
+
+	writer := &fakeKafkaWriter{}
+	h := newKafkaProducer("sink", cfg, false, zerolog.Nop(), writer)
+	results, err := h.Handle(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{}}, results)
+	assert.Equal(t, []kafka.Message{{
+		Topic:   "orders-eu",
+		Key:     []byte("42"),
+		Value:   []byte(`{"id": 42, "status": "new"}`),
+		Headers: []kafka.Header{{Key: "x-source", Value: []byte("shop")}},
+	}}, writer.messages)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.ErrorContains(t, err, "failed to replace placeholders in topic")
+
+	writer = &fakeKafkaWriter{}
+	h = newKafkaProducer("sink", cfg, true, zerolog.Nop(), writer)
+	_, err = h.Handle(context.Background(), data)
+	require.NoError(t, err)
+	assert.Empty(t, writer.messages)
+}
+
+func TestKafkaConsumer_NestedValueFields(t *testing.T) {
+	reader := &fakeKafkaReader{messages: testKafkaMessages(0, 1, 2)}
+	consumer := newKafkaConsumer("orders", config.KafkaHandler{WaitTime: 10 * time.Millisecond}, false, zerolog.Nop(),
+		func() kafkaReader { return reader })
+	writer := &fakeKafkaWriter{}
+	producer := newKafkaProducer("events", config.KafkaHandler{
+		Topic: "order-events",
+		Key:   "{{ orders.value.id }}",
+		Value: `{"order": {{ orders.value.id }}}`,
+	}, false, zerolog.Nop(), writer)
+
+	require.NoError(t, runHandlerPipe(context.Background(), nil, []Handler{consumer, producer}, nil))
+	values := map[string]string{}
+	for _, msg := range writer.messages {
+		values[string(msg.Key)] = string(msg.Value)
+	}
+	assert.Equal(t, map[string]string{"1": `{"order": 1}`, "2": `{"order": 2}`}, values)
+}
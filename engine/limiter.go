@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+// limiter bounds the number of concurrent requests and their rate.
+// A nil limiter does not limit anything.
+type limiter struct {
+	slots  chan struct{}
+	bucket *tokenBucket
+}
+
+func newLimiter(cfg config.LimiterGroup) (*limiter, error) {
+	rate, err := cfg.RateLimit.PerSecond()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConcurrency == 0 && rate == 0 {
+		return nil, nil
+	}
+
+	l := &limiter{}
+	if cfg.MaxConcurrency > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrency)
+	}
+	if rate > 0 {
+		l.bucket = newTokenBucket(rate, cfg.Burst)
+	}
+	return l, nil
+}
+
+// acquire waits for a free concurrency slot and a rate token.
+// If it returns nil, release must be called once the request is done.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if l.bucket != nil {
+		if err := l.bucket.wait(ctx); err != nil {
+			l.release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *limiter) release() {
+	if l == nil || l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
+// acquireLimiters acquires all the limiters, the returned function releases them.
+// If one of them can't be acquired, the ones acquired before are released.
+func acquireLimiters(ctx context.Context, limiters []*limiter) (func(), error) {
+	acquired := 0
+	release := func() {
+		for _, l := range limiters[:acquired] {
+			l.release()
+		}
+	}
+	for _, l := range limiters {
+		if err := l.acquire(ctx); err != nil {
+			release()
+			return nil, fmt.Errorf("failed to wait for rate limiter: %s", err)
+		}
+		acquired++
+	}
+	return release, nil
+}
+
+// tokenBucket allows 'rate' requests per second on average with bursts of up to 'burst' requests.
+type tokenBucket struct {
+	mux sync.Mutex
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// wait takes a token, waiting until one is available. Tokens are reserved in
+// order, so waiting callers are served first come, first served.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token and returns how long to wait until it is available.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a reserved token that was not used.
+func (b *tokenBucket) cancel() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+// limiterGroups holds the limiters shared by handlers of the same group.
+type limiterGroups map[string]*limiter
+
+func newLimiterGroups(cfg map[string]config.LimiterGroup) (limiterGroups, error) {
+	groups := make(limiterGroups, len(cfg))
+	for name, groupCfg := range cfg {
+		l, err := newLimiter(groupCfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' limiter group: %s", name, err)
+		}
+		groups[name] = l
+	}
+	return groups, nil
+}
+
+// limitersFor returns the limiters of a handler: its own limits followed by its group limits.
+func (g limiterGroups) limitersFor(cfg config.Limits) ([]*limiter, error) {
+	var limiters []*limiter
+
+	own, err := newLimiter(cfg.LimiterGroup)
+	if err != nil {
+		return nil, err
+	}
+	if own != nil {
+		limiters = append(limiters, own)
+	}
+
+	if cfg.Group != "" {
+		group, ok := g[cfg.Group]
+		if !ok {
+			return nil, fmt.Errorf("unknown limiter group: '%s'", cfg.Group)
+		}
+		if group != nil {
+			limiters = append(limiters, group)
+		}
+	}
+	return limiters, nil
+}
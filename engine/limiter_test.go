@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHandlerEnv(t *testing.T, logger zerolog.Logger) *handlerEnv {
+	t.Helper()
+	env, err := newHandlerEnv(logger, nil)
+	require.NoError(t, err)
+	return env
+}
+
+func TestNewLimiter(t *testing.T) {
+	l, err := newLimiter(config.LimiterGroup{})
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+
+	l, err = newLimiter(config.LimiterGroup{MaxConcurrency: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cap(l.slots))
+	assert.Nil(t, l.bucket)
+
+	l, err = newLimiter(config.LimiterGroup{RateLimit: "120/m", Burst: 5})
+	assert.NoError(t, err)
+	assert.Nil(t, l.slots)
+	assert.Equal(t, 2.0, l.bucket.rate)
+	assert.Equal(t, 5.0, l.bucket.burst)
+
+	_, err = newLimiter(config.LimiterGroup{RateLimit: "fast"})
+	assert.Error(t, err)
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(2, 2)
+	b.now = func() time.Time { return now }
+
+	// burst is available immediately
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+
+	// next tokens are reserved in order
+	assert.Equal(t, 500*time.Millisecond, b.reserve())
+	assert.Equal(t, time.Second, b.reserve())
+
+	// refilled tokens are capped by burst
+	now = now.Add(time.Hour)
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, 500*time.Millisecond, b.reserve())
+
+	b.cancel()
+	assert.Equal(t, 500*time.Millisecond, b.reserve())
+}
+
+func TestTokenBucket_WaitCancelled(t *testing.T) {
+	b := newTokenBucket(1.0/3600, 1)
+	assert.NoError(t, b.wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.wait(ctx), context.DeadlineExceeded)
+}
+
+func TestLimiter_MaxConcurrency(t *testing.T) {
+	l, err := newLimiter(config.LimiterGroup{MaxConcurrency: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+
+	l.release()
+	assert.NoError(t, l.acquire(context.Background()))
+}
+
+func TestAcquireLimiters(t *testing.T) {
+	first, err := newLimiter(config.LimiterGroup{MaxConcurrency: 1})
+	require.NoError(t, err)
+	second, err := newLimiter(config.LimiterGroup{MaxConcurrency: 1})
+	require.NoError(t, err)
+	limiters := []*limiter{first, nil, second}
+
+	release, err := acquireLimiters(context.Background(), limiters)
+	require.NoError(t, err)
+
+	// the first limiter is released again when the second one can't be acquired
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	first.release()
+	_, err = acquireLimiters(ctx, limiters)
+	assert.EqualError(t, err, "failed to wait for rate limiter: context deadline exceeded")
+	assert.NoError(t, first.acquire(context.Background()))
+
+	release()
+	release, err = acquireLimiters(context.Background(), limiters)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestLimiterGroups_LimitersFor(t *testing.T) {
+	groups, err := newLimiterGroups(map[string]config.LimiterGroup{
+		"partner-api": {MaxConcurrency: 2},
+	})
+	require.NoError(t, err)
+
+	limiters, err := groups.limitersFor(config.Limits{})
+	assert.NoError(t, err)
+	assert.Empty(t, limiters)
+
+	limiters, err = groups.limitersFor(config.Limits{
+		LimiterGroup: config.LimiterGroup{RateLimit: "10/s"},
+		Group:        "partner-api",
+	})
+	assert.NoError(t, err)
+	require.Len(t, limiters, 2)
+	assert.Same(t, groups["partner-api"], limiters[1])
+
+	_, err = groups.limitersFor(config.Limits{Group: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestHTTPHandler_Limits(t *testing.T) {
+	var active, maxActive atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[{}]}`))
+	}))
+	defer server.Close()
+
+	env, err := newHandlerEnv(zerolog.Nop(), map[string]config.LimiterGroup{
+		"shared": {MaxConcurrency: 2},
+	})
+	require.NoError(t, err)
+
+	var handlers []Handler
+	for _, name := range []string{"sink-1", "sink-2"} {
+		h, err := newHandler(name, config.Handler{
+			HTTPHandler: config.HTTPHandler{URL: server.URL, Method: http.MethodGet},
+			Limits: config.Limits{
+				LimiterGroup: config.LimiterGroup{MaxConcurrency: 2},
+				Group:        "shared",
+			},
+		}, env)
+		require.NoError(t, err)
+		handlers = append(handlers, h)
+	}
+
+	done := make(chan error)
+	for i := 0; i < 8; i++ {
+		h := handlers[i%2]
+		go func() {
+			_, err := h.Handle(context.Background(), map[string]string{})
+			done <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, <-done)
+	}
+
+	assert.Equal(t, int32(2), maxActive.Load())
+}
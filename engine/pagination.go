@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+// pageRequest changes the request of a page.
+type pageRequest struct {
+	// url replaces the request URL if set.
+	url *url.URL
+	// params are set as query params.
+	params map[string]string
+}
+
+func (p pageRequest) apply(req *http.Request) {
+	if p.url != nil {
+		req.URL = p.url
+		req.Host = p.url.Host
+	}
+	if len(p.params) == 0 {
+		return
+	}
+
+	q := req.URL.Query()
+	for key, value := range p.params {
+		q.Set(key, value)
+	}
+	u := *req.URL
+	u.RawQuery = q.Encode()
+	req.URL = &u
+}
+
+// paginator tracks the pages of a request and builds the request of the next page.
+type paginator struct {
+	cfg config.Pagination
+
+	pages    int
+	page     int
+	offset   int
+	cursor   string
+	next     pageRequest
+	isCapped bool
+}
+
+func newPaginator(cfg config.Pagination) *paginator {
+	if cfg.MaxPages == 0 {
+		cfg.MaxPages = config.DefaultMaxPages
+	}
+	if cfg.PageParam == "" {
+		cfg.PageParam = "page"
+	}
+	if cfg.OffsetParam == "" {
+		cfg.OffsetParam = "offset"
+	}
+	if cfg.LimitParam == "" {
+		cfg.LimitParam = "limit"
+	}
+	if cfg.CursorParam == "" {
+		cfg.CursorParam = "cursor"
+	}
+
+	p := &paginator{cfg: cfg, page: 1}
+	if cfg.StartPage != nil {
+		p.page = *cfg.StartPage
+	}
+	p.next = p.paramsRequest()
+	return p
+}
+
+// request returns the request of the next page.
+func (p *paginator) request() pageRequest {
+	return p.next
+}
+
+// count returns the number of received pages.
+func (p *paginator) count() int {
+	return p.pages
+}
+
+// capped reports whether the pagination was stopped by the max pages.
+func (p *paginator) capped() bool {
+	return p.isCapped
+}
+
+// advance registers the response of the current page and reports whether there is a next page.
+func (p *paginator) advance(resp *httpResponse) bool {
+	p.pages++
+
+	var hasNext bool
+	switch p.cfg.Type {
+	case config.PaginationTypePage:
+		hasNext = p.isFullPage(resp)
+		p.page++
+		p.next = p.paramsRequest()
+	case config.PaginationTypeOffset:
+		hasNext = p.isFullPage(resp)
+		p.offset += len(resp.results)
+		p.next = p.paramsRequest()
+	case config.PaginationTypeCursor:
+		cursor := nextCursor(resp.body, p.cfg.CursorPath)
+		hasNext = cursor != "" && cursor != p.cursor
+		p.cursor = cursor
+		p.next = p.paramsRequest()
+	case config.PaginationTypeLink:
+		next := nextLink(resp.header, resp.url)
+		hasNext = next != nil
+		p.next = pageRequest{url: next}
+	default:
+		return false
+	}
+
+	if hasNext && p.pages >= p.cfg.MaxPages {
+		p.isCapped = true
+		return false
+	}
+	return hasNext
+}
+
+func (p *paginator) isFullPage(resp *httpResponse) bool {
+	if len(resp.results) == 0 {
+		return false
+	}
+	return p.cfg.PageSize == 0 || len(resp.results) >= p.cfg.PageSize
+}
+
+func (p *paginator) paramsRequest() pageRequest {
+	params := map[string]string{}
+	switch p.cfg.Type {
+	case config.PaginationTypePage:
+		params[p.cfg.PageParam] = strconv.Itoa(p.page)
+	case config.PaginationTypeOffset:
+		params[p.cfg.OffsetParam] = strconv.Itoa(p.offset)
+	case config.PaginationTypeCursor:
+		if p.cursor != "" {
+			params[p.cfg.CursorParam] = p.cursor
+		}
+	default:
+		return pageRequest{}
+	}
+	if p.cfg.PageSize > 0 {
+		params[p.cfg.LimitParam] = strconv.Itoa(p.cfg.PageSize)
+	}
+	return pageRequest{params: params}
+}
+
+// nextCursor returns the cursor at the path of the JSON body, or an empty string if there is none.
+func nextCursor(body []byte, path string) string {
+	value := json.RawMessage(body)
+	for _, key := range config.JSONPathKeys(path) {
+		var err error
+		value, err = lookupJSON(value, key)
+		if err != nil {
+			return ""
+		}
+	}
+
+	switch jsonKind(value) {
+	case 0, 'n':
+		return ""
+	case '"':
+		var cursor string
+		_ = json.Unmarshal(value, &cursor)
+		return cursor
+	default:
+		return strings.TrimSpace(string(value))
+	}
+}
+
+// nextLink returns the URL of the 'next' relation of the RFC 5988 Link header, resolved against the request URL.
+func nextLink(header http.Header, requestURL *url.URL) *url.URL {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			if !hasNextRelation(params) {
+				continue
+			}
+
+			next, err := url.Parse(target[1 : len(target)-1])
+			if err != nil {
+				return nil
+			}
+			if requestURL != nil {
+				next = requestURL.ResolveReference(next)
+			}
+			return next
+		}
+	}
+	return nil
+}
+
+func hasNextRelation(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+			if strings.EqualFold(rel, "next") {
+				return true
+			}
+		}
+	}
+	return false
+}
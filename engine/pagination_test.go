@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPHandler_Pagination(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	pageOf := func(from, to int) []int {
+		from, to = min(from, len(items)), min(to, len(items))
+		return items[from:to]
+	}
+	writeItems := func(w http.ResponseWriter, ids []int, extra string) {
+		results := make([]map[string]int, 0, len(ids))
+		for _, id := range ids {
+			results = append(results, map[string]int{"id": id})
+		}
+		body, _ := json.Marshal(results)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"results":%s%s}`, body, extra)
+	}
+
+	tests := []struct {
+		name       string
+		pagination config.Pagination
+		handler    func(w http.ResponseWriter, r *http.Request)
+		expected   []int
+		requests   []string
+	}{
+		{
+			name:       "Page",
+			pagination: config.Pagination{Type: config.PaginationTypePage, PageSize: 2},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				writeItems(w, pageOf((page-1)*2, page*2), "")
+			},
+			expected: []int{1, 2, 3, 4, 5},
+			requests: []string{"limit=2&page=1", "limit=2&page=2", "limit=2&page=3"},
+		},
+		{
+			name: "PageFromZeroUntilEmpty",
+			pagination: config.Pagination{
+				Type: config.PaginationTypePage, PageParam: "p", StartPage: new(int),
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("p"))
+				writeItems(w, pageOf(page*3, (page+1)*3), "")
+			},
+			expected: []int{1, 2, 3, 4, 5},
+			requests: []string{"p=0", "p=1", "p=2"},
+		},
+		{
+			name:       "Offset",
+			pagination: config.Pagination{Type: config.PaginationTypeOffset, PageSize: 3, LimitParam: "size"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+				writeItems(w, pageOf(offset, offset+3), "")
+			},
+			expected: []int{1, 2, 3, 4, 5},
+			requests: []string{"offset=0&size=3", "offset=3&size=3"},
+		},
+		{
+			name:       "Cursor",
+			pagination: config.Pagination{Type: config.PaginationTypeCursor, CursorPath: "meta.next"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				from, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+				next := `null`
+				if from+2 < len(items) {
+					next = fmt.Sprintf(`"%d"`, from+2)
+				}
+				writeItems(w, pageOf(from, from+2), `,"meta":{"next":`+next+`}`)
+			},
+			expected: []int{1, 2, 3, 4, 5},
+			requests: []string{"", "cursor=2", "cursor=4"},
+		},
+		{
+			name:       "Link",
+			pagination: config.Pagination{Type: config.PaginationTypeLink},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+				if from+2 < len(items) {
+					w.Header().Set("Link", fmt.Sprintf(`</items?from=0>; rel="first", </items?from=%d>; rel="next"`, from+2))
+				}
+				writeItems(w, pageOf(from, from+2), "")
+			},
+			expected: []int{1, 2, 3, 4, 5},
+			requests: []string{"", "from=2", "from=4"},
+		},
+		{
+			name:       "MaxPages",
+			pagination: config.Pagination{Type: config.PaginationTypePage, PageSize: 1, MaxPages: 2},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				writeItems(w, pageOf(page-1, page), "")
+			},
+			expected: []int{1, 2},
+			requests: []string{"limit=1&page=1", "limit=1&page=2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.URL.RawQuery)
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+
+			h := newHTTPHandler("data-source", config.HTTPHandler{
+				URL:        server.URL + "/items",
+				Method:     http.MethodGet,
+				Pagination: tt.pagination,
+			})
+
+			results, err := h.Handle(context.Background(), map[string]string{})
+			require.NoError(t, err)
+
+			var ids []int
+			for _, result := range results {
+				id, _ := strconv.Atoi(string(result["id"]))
+				ids = append(ids, id)
+			}
+			assert.Equal(t, tt.expected, ids)
+			assert.Equal(t, tt.requests, requests)
+		})
+	}
+}
+
+func TestHTTPHandler_PaginationError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[{"id":1}]}`))
+	}))
+	defer server.Close()
+
+	h := newHTTPHandler("data-source", config.HTTPHandler{
+		URL:        server.URL,
+		Method:     http.MethodGet,
+		Pagination: config.Pagination{Type: config.PaginationTypePage},
+	})
+
+	var emitted []HandlerResult
+	err := h.HandleStream(context.Background(), map[string]string{}, func(results []HandlerResult) error {
+		emitted = append(emitted, results...)
+		return nil
+	})
+	assert.ErrorContains(t, err, "page 2: failed to execute HTTP request")
+	assert.ErrorContains(t, err, "unexpected response code: got 500")
+	assert.Len(t, emitted, 1)
+}
+
+func TestRunHandlerPipe_StreamsPages(t *testing.T) {
+	secondPage := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/source":
+			if r.URL.Query().Get("page") == "1" {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"results":[{"id":1}]}`))
+				return
+			}
+			// the second page is only sent after the first one reached the sink
+			select {
+			case <-secondPage:
+			case <-time.After(time.Second):
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"results":[]}`))
+		case "/sink":
+			close(secondPage)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"results":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	source := newHTTPHandler("source", config.HTTPHandler{
+		URL:        server.URL + "/source",
+		Method:     http.MethodGet,
+		Pagination: config.Pagination{Type: config.PaginationTypePage},
+	})
+	sink := newHTTPHandler("sink", config.HTTPHandler{URL: server.URL + "/sink", Method: http.MethodPost})
+
+	start := time.Now()
+	err := runHandlerPipe(context.Background(), nil, []Handler{source, sink}, nil)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestNextLink(t *testing.T) {
+	requestURL, _ := url.Parse("https://api.example.com/v1/items?page=1")
+
+	tests := []struct {
+		name     string
+		link     []string
+		expected string
+	}{
+		{name: "NoHeader"},
+		{
+			name:     "Absolute",
+			link:     []string{`<https://api.example.com/v1/items?page=2>; rel="next"`},
+			expected: "https://api.example.com/v1/items?page=2",
+		},
+		{
+			name:     "RelativeAmongOthers",
+			link:     []string{`<?page=1>; rel="prev first"`, `<items?page=2>; rel=next, <items?page=9>; rel="last"`},
+			expected: "https://api.example.com/v1/items?page=2",
+		},
+		{
+			name: "NoNext",
+			link: []string{`<https://api.example.com/v1/items?page=9>; rel="last"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := nextLink(http.Header{"Link": tt.link}, requestURL)
+			if tt.expected == "" {
+				assert.Nil(t, next)
+				return
+			}
+			require.NotNil(t, next)
+			assert.Equal(t, tt.expected, next.String())
+		})
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	assert.Equal(t, "abc", nextCursor([]byte(`{"meta":{"next":"abc"}}`), "meta.next"))
+	assert.Equal(t, "42", nextCursor([]byte(`{"meta":{"next":42}}`), "meta.next"))
+	assert.Equal(t, "", nextCursor([]byte(`{"meta":{"next":null}}`), "meta.next"))
+	assert.Equal(t, "", nextCursor([]byte(`{"meta":{}}`), "meta.next"))
+	assert.Equal(t, "", nextCursor([]byte(`not json`), "meta.next"))
+}
@@ -0,0 +1,401 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultRedisMaxMessages = 100
+	defaultRedisWaitTime    = 10 * time.Second
+	defaultRedisTimeout     = 5 * time.Second
+)
+
+func newRedisHandler(name string, cfg config.RedisHandler, env *handlerEnv, logger zerolog.Logger) (Handler, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultRedisTimeout
+	}
+
+	opts := &redis.Options{
+		Addr:         cfg.Addr,
+		Username:     cfg.Username,
+		DB:           cfg.DB,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+	}
+	if cfg.Password != nil {
+		password := *cfg.Password
+		// the password is read on each new connection, so it can be rotated
+		opts.CredentialsProvider = func() (string, string) {
+			value, err := password.Read()
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to read Redis password")
+			}
+			return cfg.Username, value
+		}
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := newTLSConfig(*cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	client := redis.NewClient(opts)
+
+	if cfg.Command == config.RedisCommandXRead {
+		return newRedisStreamConsumer(name, cfg, env.dryRun, logger, client), nil
+	}
+	return &redisHandler{
+		name:   name,
+		cfg:    cfg,
+		dryRun: env.dryRun,
+		logger: logger,
+		client: client,
+	}, nil
+}
+
+// redisHandler runs a lookup or a write command per record.
+type redisHandler struct {
+	name   string
+	cfg    config.RedisHandler
+	dryRun bool
+	logger zerolog.Logger
+	client *redis.Client
+}
+
+func (h *redisHandler) Name() string {
+	return h.name
+}
+
+func (h *redisHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	key, ok := replaceTextPlaceholders(h.cfg.Key, data)
+	if !ok {
+		return nil, fmt.Errorf("failed to replace placeholders in key: some data not found")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	switch h.cfg.Command {
+	case config.RedisCommandGet:
+		value, err := h.client.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return []HandlerResult{{"value": mustMarshal(nil)}}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get '%s' key: %s", key, err)
+		}
+		return []HandlerResult{{"value": jsonOrString(value)}}, nil
+	case config.RedisCommandHGetAll:
+		fields, err := h.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get '%s' hash: %s", key, err)
+		}
+		result := make(HandlerResult, len(fields))
+		for field, value := range fields {
+			result[field] = jsonOrString([]byte(value))
+		}
+		return []HandlerResult{result}, nil
+	case config.RedisCommandSet:
+		return h.set(ctx, key, data)
+	case config.RedisCommandXAdd:
+		return h.xadd(ctx, key, data)
+	default:
+		return nil, fmt.Errorf("unknown redis command: %s", h.cfg.Command)
+	}
+}
+
+func (h *redisHandler) set(ctx context.Context, key string, data map[string]string) ([]HandlerResult, error) {
+	value, ok := replacePlaceholders(h.cfg.Value, data)
+	if !ok {
+		return nil, fmt.Errorf("failed to replace placeholders in value: some data not found")
+	}
+
+	if h.dryRun {
+		h.logger.Info().Str("key", key).Str("value", value).Dur("ttl", h.cfg.TTL).Msg("dry run: set recorded")
+		return []HandlerResult{{}}, nil
+	}
+	if err := h.client.Set(ctx, key, value, h.cfg.TTL).Err(); err != nil {
+		return nil, fmt.Errorf("failed to set '%s' key: %s", key, err)
+	}
+	return []HandlerResult{{}}, nil
+}
+
+func (h *redisHandler) xadd(ctx context.Context, stream string, data map[string]string) ([]HandlerResult, error) {
+	values := make(map[string]any, len(h.cfg.Fields))
+	for field, value := range h.cfg.Fields {
+		field, value, err := replaceKeyValuePlaceholders(field, value, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace placeholders in fields: %s", err)
+		}
+		values[field] = value
+	}
+
+	if h.dryRun {
+		h.logger.Info().Str("stream", stream).Interface("fields", values).Msg("dry run: stream entry recorded")
+		return []HandlerResult{{"id": mustMarshal(nil)}}, nil
+	}
+	args := &redis.XAddArgs{Stream: stream, Values: values}
+	if h.cfg.MaxLen > 0 {
+		args.MaxLen = h.cfg.MaxLen
+		args.Approx = true
+	}
+	id, err := h.client.XAdd(ctx, args).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to add entry to '%s' stream: %s", stream, err)
+	}
+	return []HandlerResult{{"id": mustMarshal(id)}}, nil
+}
+
+// redisStreamConsumer emits a result per stream entry. Without a consumer group, the ID of the last entry is kept
+// in memory. With a group, an entry is acknowledged after the rest of the chain succeeded for it,
+// and failed entries are read again from the pending entries list on the next run.
+type redisStreamConsumer struct {
+	name   string
+	cfg    config.RedisHandler
+	dryRun bool
+	logger zerolog.Logger
+	client *redis.Client
+
+	mux          sync.Mutex
+	lastID       string
+	groupCreated bool
+	retryPending bool
+}
+
+func newRedisStreamConsumer(
+	name string, cfg config.RedisHandler, dryRun bool, logger zerolog.Logger, client *redis.Client,
+) *redisStreamConsumer {
+	if cfg.StartID == "" {
+		cfg.StartID = config.RedisStreamStartLatest
+	}
+	if cfg.MaxMessages == 0 {
+		cfg.MaxMessages = defaultRedisMaxMessages
+	}
+	if cfg.WaitTime == 0 {
+		cfg.WaitTime = defaultRedisWaitTime
+	}
+	return &redisStreamConsumer{
+		name:   name,
+		cfg:    cfg,
+		dryRun: dryRun,
+		logger: logger,
+		client: client,
+	}
+}
+
+func (h *redisStreamConsumer) Name() string {
+	return h.name
+}
+
+func (h *redisStreamConsumer) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	var results []HandlerResult
+	err := h.HandleStream(ctx, data, func(entries []HandlerResult) error {
+		results = append(results, entries...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HandleStream emits the stream entries one by one, until max messages are consumed
+// or no entry arrives for the wait time.
+func (h *redisStreamConsumer) HandleStream(
+	ctx context.Context, _ map[string]string, emit func(results []HandlerResult) error,
+) error {
+	read := h.read
+	if h.cfg.Group != "" {
+		if err := h.createGroup(ctx); err != nil {
+			return err
+		}
+		// failed entries are only read again at the start of a run, so entries that are still processed are not
+		h.mux.Lock()
+		pending := h.retryPending
+		h.retryPending = false
+		h.mux.Unlock()
+		read = func(ctx context.Context, count int) ([]redis.XMessage, error) {
+			messages, err := h.readGroup(ctx, count, pending)
+			pending = false
+			return messages, err
+		}
+	} else if err := h.resolveStartID(ctx); err != nil {
+		return err
+	}
+
+	for consumed := 0; consumed < h.cfg.MaxMessages; {
+		messages, err := read(ctx, h.cfg.MaxMessages-consumed)
+		if errors.Is(err, redis.Nil) {
+			// no new entries
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read '%s' stream: %s", h.cfg.Key, err)
+		}
+		for _, msg := range messages {
+			if err := emit([]HandlerResult{redisEntryResult(h.cfg.Key, msg)}); err != nil {
+				return err
+			}
+		}
+		consumed += len(messages)
+	}
+	return nil
+}
+
+// read reads the entries after the last one that was read.
+func (h *redisStreamConsumer) read(ctx context.Context, count int) ([]redis.XMessage, error) {
+	h.mux.Lock()
+	lastID := h.lastID
+	h.mux.Unlock()
+
+	streams, err := h.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{h.cfg.Key, lastID},
+		Count:   int64(count),
+		Block:   h.cfg.WaitTime,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := streamMessages(streams)
+	if len(messages) > 0 {
+		h.mux.Lock()
+		h.lastID = messages[len(messages)-1].ID
+		h.mux.Unlock()
+	}
+	return messages, nil
+}
+
+// readGroup reads new entries of the group, or the pending entries of the consumer if pending is true.
+func (h *redisStreamConsumer) readGroup(ctx context.Context, count int, pending bool) ([]redis.XMessage, error) {
+	id, block := ">", h.cfg.WaitTime
+	if pending {
+		id, block = "0", -1
+	}
+
+	streams, err := h.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    h.cfg.Group,
+		Consumer: h.cfg.Consumer,
+		Streams:  []string{h.cfg.Key, id},
+		Count:    int64(count),
+		Block:    block,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := streamMessages(streams)
+	if pending && len(messages) == 0 {
+		// no pending entries
+		return h.readGroup(ctx, count, false)
+	}
+	return messages, nil
+}
+
+// Ack acknowledges the entry of the result in the consumer group once the rest of the chain succeeded for it.
+func (h *redisStreamConsumer) Ack(ctx context.Context, result HandlerResult, chainErr error) {
+	if h.cfg.Group == "" {
+		return
+	}
+	var id string
+	if err := json.Unmarshal(result["id"], &id); err != nil {
+		h.logger.Error().Err(err).Msg("failed to acknowledge Redis stream entry: invalid id")
+		return
+	}
+
+	if chainErr != nil {
+		h.mux.Lock()
+		h.retryPending = true
+		h.mux.Unlock()
+		h.logger.Warn().Str("id", id).Msg("Redis stream entry failed, it will be read again on the next run")
+		return
+	}
+	if h.dryRun {
+		h.logger.Info().Str("id", id).Msg("dry run: Redis stream entry not acknowledged")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.cfg.Timeout)
+	defer cancel()
+	if err := h.client.XAck(ctx, h.cfg.Key, h.cfg.Group, id).Err(); err != nil {
+		h.logger.Error().Err(err).Str("id", id).Msg("failed to acknowledge Redis stream entry")
+	}
+}
+
+// createGroup creates the consumer group and the stream, if they don't exist yet.
+func (h *redisStreamConsumer) createGroup(ctx context.Context) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.groupCreated {
+		return nil
+	}
+
+	err := h.client.XGroupCreateMkStream(ctx, h.cfg.Key, h.cfg.Group, h.cfg.StartID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create '%s' consumer group: %s", h.cfg.Group, err)
+	}
+	h.groupCreated = true
+	// entries that were read but not acknowledged before a restart are processed first
+	h.retryPending = true
+	return nil
+}
+
+// resolveStartID replaces '$' with the ID of the last entry of the stream on the first run,
+// so entries added between runs are not missed.
+func (h *redisStreamConsumer) resolveStartID(ctx context.Context) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.lastID != "" {
+		return nil
+	}
+	if h.cfg.StartID != config.RedisStreamStartLatest {
+		h.lastID = h.cfg.StartID
+		return nil
+	}
+
+	messages, err := h.client.XRevRangeN(ctx, h.cfg.Key, "+", "-", 1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read the last entry of '%s' stream: %s", h.cfg.Key, err)
+	}
+	h.lastID = "0-0"
+	if len(messages) > 0 {
+		h.lastID = messages[0].ID
+	}
+	return nil
+}
+
+func streamMessages(streams []redis.XStream) []redis.XMessage {
+	var messages []redis.XMessage
+	for _, stream := range streams {
+		messages = append(messages, stream.Messages...)
+	}
+	return messages
+}
+
+func redisEntryResult(stream string, msg redis.XMessage) HandlerResult {
+	fields := make(map[string]any, len(msg.Values))
+	for field, value := range msg.Values {
+		if s, ok := value.(string); ok {
+			fields[field] = jsonOrString([]byte(s))
+		} else {
+			fields[field] = value
+		}
+	}
+	return HandlerResult{
+		"id":     mustMarshal(msg.ID),
+		"stream": mustMarshal(stream),
+		"fields": mustMarshal(fields),
+	}
+}
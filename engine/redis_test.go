@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisHandler(t *testing.T, server *miniredis.Miniredis, cfg config.RedisHandler, dryRun bool) Handler {
+	cfg.Addr = server.Addr()
+	env := testHandlerEnv(t, zerolog.Nop())
+	env.dryRun = dryRun
+	h, err := newRedisHandler("cache", cfg, env, zerolog.Nop())
+	require.NoError(t, err)
+	return h
+}
+
+func TestRedisHandler_Lookups(t *testing.T) {
+	server := miniredis.RunT(t)
+	require.NoError(t, server.Set("customer:1", `{"name":"alice"}`))
+	server.HSet("customer:2", "name", "bob", "orders", "3")
+
+	data := map[string]string{"source.id": `1`}
+	get := newTestRedisHandler(t, server, config.RedisHandler{
+		Command: config.RedisCommandGet,
+		Key:     "customer:{{ source.id }}",
+	}, false)
+	results, err := get.Handle(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{"value": json.RawMessage(`{"name":"alice"}`)}}, results)
+
+	results, err = get.Handle(context.Background(), map[string]string{"source.id": `5`})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{"value": json.RawMessage(`null`)}}, results)
+
+	hgetall := newTestRedisHandler(t, server, config.RedisHandler{
+		Command: config.RedisCommandHGetAll,
+		Key:     "customer:{{ source.id }}",
+	}, false)
+	results, err = hgetall.Handle(context.Background(), map[string]string{"source.id": `2`})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{
+		"name":   json.RawMessage(`"bob"`),
+		"orders": json.RawMessage(`3`),
+	}}, results)
+
+	_, err = hgetall.Handle(context.Background(), map[string]string{})
+	assert.EqualError(t, err, "failed to replace placeholders in key: some data not found")
+}
+
+func TestRedisHandler_Set(t *testing.T) {
+	server := miniredis.RunT(t)
+	cfg := config.RedisHandler{
+		Command: config.RedisCommandSet,
+		Key:     "order:{{ source.id }}",
+		Value:   `{"id": {{ source.id }}, "status": {{ source.status }}}`,
+		TTL:     time.Hour,
+	}
+	data := map[string]string{"source.id": `7`, "source.status": `"paid"`}
+
+	_, err := newTestRedisHandler(t, server, cfg, true).Handle(context.Background(), data)
+	require.NoError(t, err)
+	assert.False(t, server.Exists("order:7"))
+
+	results, err := newTestRedisHandler(t, server, cfg, false).Handle(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{}}, results)
+	value, err := server.Get("order:7")
+	require.NoError(t, err)
+	assert.Equal(t, `{"id": 7, "status": "paid"}`, value)
+	assert.Equal(t, time.Hour, server.TTL("order:7"))
+}
+
+func TestRedisHandler_XAdd(t *testing.T) {
+	server := miniredis.RunT(t)
+	h := newTestRedisHandler(t, server, config.RedisHandler{
+		Command: config.RedisCommandXAdd,
+		Key:     "events",
+		Fields:  map[string]string{"order": "{{ source.id }}", "status": "paid"},
+	}, false)
+
+	results, err := h.Handle(context.Background(), map[string]string{"source.id": `7`})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	entries, err := server.Stream("events")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, json.RawMessage(fmt.Sprintf("%q", entries[0].ID)), results[0]["id"])
+	assert.ElementsMatch(t, []string{"order", "7", "status", "paid"}, entries[0].Values)
+}
+
+func TestRedisStreamConsumer_WithoutGroup(t *testing.T) {
+	server := miniredis.RunT(t)
+	_, err := server.XAdd("events", "*", []string{"order", "1"})
+	require.NoError(t, err)
+
+	h := newTestRedisHandler(t, server, config.RedisHandler{
+		Command:  config.RedisCommandXRead,
+		Key:      "events",
+		WaitTime: 10 * time.Millisecond,
+	}, false)
+
+	// entries added before the first run are skipped
+	results, err := h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	// entries added between runs are not missed
+	id, err := server.XAdd("events", "*", []string{"order", `{"id":2}`})
+	require.NoError(t, err)
+	results, err = h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{
+		"id":     mustMarshal(id),
+		"stream": json.RawMessage(`"events"`),
+		"fields": json.RawMessage(`{"order":{"id":2}}`),
+	}}, results)
+	order, ok := replacePlaceholders("{{ cache.fields.order.id }}",
+		map[string]string{"cache.fields": string(results[0]["fields"])})
+	require.True(t, ok)
+	assert.Equal(t, "2", order)
+
+	results, err = h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestRedisStreamConsumer_Group(t *testing.T) {
+	server := miniredis.RunT(t)
+	h := newTestRedisHandler(t, server, config.RedisHandler{
+		Command:  config.RedisCommandXRead,
+		Key:      "events",
+		Group:    "datapipe",
+		Consumer: "worker-1",
+		StartID:  "0",
+		WaitTime: 10 * time.Millisecond,
+	}, false)
+	for i := 1; i <= 3; i++ {
+		_, err := server.XAdd("events", "*", []string{"order", fmt.Sprint(i)})
+		require.NoError(t, err)
+	}
+
+	fail := true
+	var processed []string
+	sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		processed = append(processed, data["cache.fields"])
+		if fail && data["cache.fields"] == `{"order":2}` {
+			return nil, fmt.Errorf("sink is down")
+		}
+		return nil, nil
+	}}
+	runSerially := func() error {
+		results, err := h.Handle(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		var errs []error
+		for _, result := range results {
+			data := map[string]string{}
+			for k, v := range result {
+				data["cache."+k] = string(v)
+			}
+			_, err := sink.Handle(context.Background(), data)
+			h.(ackHandler).Ack(context.Background(), result, err)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			return errs[0]
+		}
+		return nil
+	}
+
+	assert.EqualError(t, runSerially(), "sink is down")
+	assert.Equal(t, []string{`{"order":1}`, `{"order":2}`, `{"order":3}`}, processed)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	pending, err := client.XPending(context.Background(), "events", "datapipe").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pending.Count)
+
+	// the failed entry is read again on the next run
+	fail = false
+	processed = nil
+	assert.NoError(t, runSerially())
+	assert.Equal(t, []string{`{"order":2}`}, processed)
+
+	pending, err = client.XPending(context.Background(), "events", "datapipe").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+// decodeResponse maps an HTTP response into the handler results as defined by the response config.
+func decodeResponse(resp *http.Response, body []byte, cfg config.Response) ([]HandlerResult, error) {
+	var results []HandlerResult
+	var err error
+	if len(bytes.TrimSpace(body)) == 0 {
+		switch cfg.EmptyBody {
+		case config.EmptyBodyActionError:
+			return nil, fmt.Errorf("failed to decode response body: body is empty")
+		case config.EmptyBodyActionDrop:
+			return nil, nil
+		default:
+			results = []HandlerResult{{}}
+		}
+	} else {
+		results, err = decodeBody(body, responseFormat(cfg, resp.Header.Get("Content-Type")), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response body: %s", err)
+		}
+	}
+
+	captureResponseFields(results, resp, cfg)
+	return results, nil
+}
+
+// extractResults returns the results found at the results path of the body.
+func extractResults(body []byte, cfg config.Response) ([]HandlerResult, error) {
+	value := json.RawMessage(body)
+	for _, key := range cfg.ResultsPathKeys() {
+		var err error
+		value, err = lookupJSON(value, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch jsonKind(value) {
+	case 'n':
+		return nil, nil
+	case '[':
+		var results []HandlerResult
+		if err := json.Unmarshal(value, &results); err != nil {
+			return nil, err
+		}
+		return results, nil
+	case '{':
+		if !cfg.SingleObject {
+			return nil, fmt.Errorf("results are an object, not an array, set 'single_object' to use it as a result")
+		}
+		result := HandlerResult{}
+		if err := json.Unmarshal(value, &result); err != nil {
+			return nil, err
+		}
+		return []HandlerResult{result}, nil
+	default:
+		return nil, fmt.Errorf("results must be an array or an object")
+	}
+}
+
+// lookupJSON returns the value of the key of a JSON object, or of the index of a JSON array.
+// A missing key or index results in null.
+func lookupJSON(value json.RawMessage, key string) (json.RawMessage, error) {
+	switch jsonKind(value) {
+	case 'n':
+		return value, nil
+	case '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil, err
+		}
+		if v, ok := object[key]; ok {
+			return v, nil
+		}
+		return json.RawMessage("null"), nil
+	case '[':
+		index, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not an index of an array", key)
+		}
+		var array []json.RawMessage
+		if err := json.Unmarshal(value, &array); err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= len(array) {
+			return json.RawMessage("null"), nil
+		}
+		return array[index], nil
+	default:
+		return nil, fmt.Errorf("'%s' is not found, the parent is not an object or an array", key)
+	}
+}
+
+// jsonKind returns the first character of a JSON value: '{', '[', 'n' for null, etc.
+func jsonKind(value json.RawMessage) byte {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+	return value[0]
+}
+
+// captureResponseFields adds the captured response headers and status code to each result.
+func captureResponseFields(results []HandlerResult, resp *http.Response, cfg config.Response) {
+	if len(cfg.Headers) == 0 && cfg.StatusCodeField == "" {
+		return
+	}
+
+	fields := HandlerResult{}
+	for field, header := range cfg.Headers {
+		value, _ := json.Marshal(resp.Header.Get(header))
+		fields[field] = value
+	}
+	if cfg.StatusCodeField != "" {
+		fields[cfg.StatusCodeField] = json.RawMessage(strconv.Itoa(resp.StatusCode))
+	}
+
+	for i := range results {
+		if results[i] == nil {
+			results[i] = HandlerResult{}
+		}
+		for field, value := range fields {
+			results[i][field] = value
+		}
+	}
+}
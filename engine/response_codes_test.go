@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPHandler_ResponseCodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.HTTPHandler
+		statusCode  int
+		body        string
+		expected    []HandlerResult
+		errContains string
+		attempts    int32
+	}{
+		{
+			name:       "ExpectedCode",
+			cfg:        config.HTTPHandler{ExpectedResponseCodes: []int{200, 201}},
+			statusCode: http.StatusCreated,
+			body:       `{"results":[{"id":1}]}`,
+			expected:   []HandlerResult{{"id": json.RawMessage(`1`)}},
+			attempts:   1,
+		},
+		{
+			name:        "UnexpectedCodeWithBody",
+			cfg:         config.HTTPHandler{ExpectedResponseCodes: []int{200, 201}},
+			statusCode:  http.StatusBadRequest,
+			body:        `{"error":"invalid id"}` + "\n",
+			errContains: `unexpected response code: got 400, expected one of 200, 201, response body: {"error":"invalid id"}`,
+			attempts:    1,
+		},
+		{
+			name:        "LongErrorBodyIsTruncated",
+			statusCode:  http.StatusInternalServerError,
+			body:        strings.Repeat("a", 2000),
+			errContains: "expected 200, response body: " + strings.Repeat("a", maxErrorBodySize) + "...",
+			attempts:    1,
+		},
+		{
+			name: "SkipRecord",
+			cfg: config.HTTPHandler{ResponseCodeOutcomes: map[int]config.ResponseCodeOutcome{
+				http.StatusNotFound: config.ResponseCodeOutcomeSkipRecord,
+			}},
+			statusCode: http.StatusNotFound,
+			body:       `{"error":"not found"}`,
+			expected:   nil,
+			attempts:   1,
+		},
+		{
+			name: "TreatAsSuccess",
+			cfg: config.HTTPHandler{
+				ResponseCodeOutcomes: map[int]config.ResponseCodeOutcome{
+					http.StatusConflict: config.ResponseCodeOutcomeTreatAsSuccess,
+				},
+				Response: config.Response{StatusCodeField: "status"},
+			},
+			statusCode: http.StatusConflict,
+			body:       `{"error":"already exists"}`,
+			expected:   []HandlerResult{{"status": json.RawMessage(`409`)}},
+			attempts:   1,
+		},
+		{
+			name: "Retry",
+			cfg: config.HTTPHandler{
+				Retries: 2,
+				RetryPolicy: config.RetryPolicy{
+					RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+				},
+				ResponseCodeOutcomes: map[int]config.ResponseCodeOutcome{
+					http.StatusTooManyRequests: config.ResponseCodeOutcomeRetry,
+				},
+			},
+			statusCode:  http.StatusTooManyRequests,
+			errContains: "failed to execute HTTP request after 2 attempts: unexpected response code: got 429",
+			attempts:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.statusCode)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			cfg := tt.cfg
+			cfg.URL = server.URL
+			cfg.Method = http.MethodPost
+			h := newHTTPHandler("data-sink", cfg)
+
+			results, err := h.Handle(context.Background(), map[string]string{})
+			assert.Equal(t, tt.attempts, attempts.Load())
+			if tt.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, results)
+		})
+	}
+}
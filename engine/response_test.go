@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.Response
+		body        string
+		expected    []HandlerResult
+		errContains string
+	}{
+		{
+			name:     "DefaultPath",
+			body:     `{"results":[{"a":"b"},{"c":1}]}`,
+			expected: []HandlerResult{{"a": json.RawMessage(`"b"`)}, {"c": json.RawMessage(`1`)}},
+		},
+		{
+			name:     "MissingResults",
+			body:     `{"count":0}`,
+			expected: nil,
+		},
+		{
+			name:     "NestedPath",
+			cfg:      config.Response{ResultsPath: "data.items"},
+			body:     `{"data":{"items":[{"id":1}]}}`,
+			expected: []HandlerResult{{"id": json.RawMessage(`1`)}},
+		},
+		{
+			name:     "NestedPathWithRootPrefixAndIndex",
+			cfg:      config.Response{ResultsPath: "$.pages.1.items"},
+			body:     `{"pages":[{"items":[]},{"items":[{"id":2}]}]}`,
+			expected: []HandlerResult{{"id": json.RawMessage(`2`)}},
+		},
+		{
+			name:     "RootArray",
+			cfg:      config.Response{ResultsPath: "$"},
+			body:     `[{"id":1},{"id":2}]`,
+			expected: []HandlerResult{{"id": json.RawMessage(`1`)}, {"id": json.RawMessage(`2`)}},
+		},
+		{
+			name:     "RootSingleObject",
+			cfg:      config.Response{ResultsPath: "$", SingleObject: true},
+			body:     `{"id":1}`,
+			expected: []HandlerResult{{"id": json.RawMessage(`1`)}},
+		},
+		{
+			name:        "ObjectWithoutSingleObject",
+			cfg:         config.Response{ResultsPath: "$"},
+			body:        `{"id":1}`,
+			errContains: "set 'single_object' to use it as a result",
+		},
+		{
+			name:        "NotAnArray",
+			cfg:         config.Response{ResultsPath: "data"},
+			body:        `{"data":"text"}`,
+			errContains: "results must be an array or an object",
+		},
+		{
+			name:        "IndexOfObject",
+			cfg:         config.Response{ResultsPath: "data.items"},
+			body:        `{"data":[{"items":[]}]}`,
+			errContains: "'items' is not an index of an array",
+		},
+		{
+			name:        "InvalidJSON",
+			body:        `{"results":`,
+			errContains: "failed to decode response body",
+		},
+		{
+			name:     "EmptyBody",
+			body:     "",
+			expected: []HandlerResult{{}},
+		},
+		{
+			name:        "EmptyBodyError",
+			cfg:         config.Response{EmptyBody: config.EmptyBodyActionError},
+			body:        "",
+			errContains: "body is empty",
+		},
+		{
+			name:     "EmptyBodyPassThrough",
+			cfg:      config.Response{EmptyBody: config.EmptyBodyActionPassThrough},
+			body:     "",
+			expected: []HandlerResult{{}},
+		},
+		{
+			name:     "EmptyBodyDrop",
+			cfg:      config.Response{EmptyBody: config.EmptyBodyActionDrop},
+			body:     " \n",
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+			}
+			results, err := decodeResponse(resp, []byte(tt.body), tt.cfg)
+			if tt.errContains != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, results)
+		})
+	}
+}
+
+func TestHTTPHandler_CaptureResponseFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-1")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	h := newHTTPHandler("data-sink", config.HTTPHandler{
+		URL:                  server.URL,
+		Method:               http.MethodPost,
+		ExpectedResponseCode: http.StatusNoContent,
+		Response: config.Response{
+			Headers:         map[string]string{"request_id": "X-Request-Id", "missing": "X-Missing"},
+			StatusCodeField: "status",
+			EmptyBody:       config.EmptyBodyActionPassThrough,
+		},
+	})
+
+	results, err := h.Handle(context.Background(), map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{
+		"request_id": json.RawMessage(`"req-1"`),
+		"missing":    json.RawMessage(`""`),
+		"status":     json.RawMessage(`204`),
+	}}, results)
+}
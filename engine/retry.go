@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+const defaultBackoffMultiplier = 2
+
+// requestError describes a failed request attempt, so the retry policy can decide whether to retry it.
+type requestError struct {
+	msg string
+	// permanent errors fail the same way on each attempt, e.g. when placeholders can't be replaced
+	permanent bool
+	// retryable errors are retried regardless of the retry policy, e.g. when the response code is mapped to 'retry'
+	retryable  bool
+	class      config.RetryableError
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+// retryPolicy decides whether and when a failed request is retried.
+type retryPolicy struct {
+	retries  int
+	interval time.Duration
+	cfg      config.RetryPolicy
+	// jitter returns a random number in [0, 1)
+	jitter func() float64
+}
+
+func newRetryPolicy(cfg config.HTTPHandler) *retryPolicy {
+	return newRetryPolicyFor(cfg.Retries, cfg.RetryInterval, cfg.RetryPolicy)
+}
+
+func newRetryPolicyFor(retries int, interval time.Duration, cfg config.RetryPolicy) *retryPolicy {
+	return &retryPolicy{
+		retries:  retries,
+		interval: interval,
+		cfg:      cfg,
+		// nolint: gosec
+		jitter: rand.Float64,
+	}
+}
+
+func (p *retryPolicy) isRetryable(err error) bool {
+	reqErr := &requestError{}
+	if !errors.As(err, &reqErr) {
+		return true
+	}
+
+	switch {
+	case reqErr.permanent:
+		return false
+	case reqErr.retryable:
+		return true
+	case reqErr.statusCode != 0 && len(p.cfg.RetryableStatusCodes) == 0:
+		return isTransientStatusCode(reqErr.statusCode)
+	case reqErr.statusCode != 0:
+		return containsInt(p.cfg.RetryableStatusCodes, reqErr.statusCode)
+	default:
+		return len(p.cfg.RetryableErrors) == 0 || containsRetryableError(p.cfg.RetryableErrors, reqErr.class)
+	}
+}
+
+// backoff returns how long to wait after the given number of failed attempts.
+// A Retry-After of the response is honored, up to the max interval.
+func (p *retryPolicy) backoff(failedAttempts int, err error) time.Duration {
+	reqErr := &requestError{}
+	if !p.cfg.IgnoreRetryAfter && errors.As(err, &reqErr) && reqErr.retryAfter > 0 {
+		if p.cfg.MaxInterval > 0 && reqErr.retryAfter > p.cfg.MaxInterval {
+			return p.cfg.MaxInterval
+		}
+		return reqErr.retryAfter
+	}
+
+	interval := float64(p.interval)
+	if p.cfg.Backoff == config.BackoffExponential {
+		multiplier := p.cfg.Multiplier
+		if multiplier == 0 {
+			multiplier = defaultBackoffMultiplier
+		}
+		interval *= math.Pow(multiplier, float64(failedAttempts-1))
+	}
+	if p.cfg.Jitter > 0 {
+		// spread the interval evenly over [interval * (1 - jitter), interval * (1 + jitter)]
+		interval *= 1 + p.cfg.Jitter*(2*p.jitter()-1)
+	}
+	// the max interval is applied after the jitter, so it's never exceeded
+	if p.cfg.MaxInterval > 0 && interval > float64(p.cfg.MaxInterval) {
+		interval = float64(p.cfg.MaxInterval)
+	}
+	// an exponential backoff without max interval overflows a duration after enough attempts
+	if interval >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(interval)
+}
+
+// isTransientStatusCode reports whether a response code is retried by default: 408, 429 and 5xx.
+func isTransientStatusCode(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// classifyError returns the class of an error returned by the HTTP client.
+func classifyError(err error) config.RetryableError {
+	netErr := net.Error(nil)
+	dnsErr := &net.DNSError{}
+	switch {
+	case errors.As(err, &dnsErr):
+		return config.RetryableErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return config.RetryableErrorTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return config.RetryableErrorConnectionReset
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return config.RetryableErrorConnectionRefused
+	default:
+		return ""
+	}
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsRetryableError(values []config.RetryableError, value config.RetryableError) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            config.HTTPHandler
+		jitter         float64
+		failedAttempts int
+		err            error
+		expected       time.Duration
+	}{
+		{
+			name:           "Constant",
+			cfg:            config.HTTPHandler{RetryInterval: time.Second},
+			failedAttempts: 3,
+			expected:       time.Second,
+		},
+		{
+			name: "Exponential",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy:   config.RetryPolicy{Backoff: config.BackoffExponential},
+			},
+			failedAttempts: 3,
+			expected:       4 * time.Second,
+		},
+		{
+			name: "ExponentialWithMultiplierAndMaxInterval",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy: config.RetryPolicy{
+					Backoff:     config.BackoffExponential,
+					Multiplier:  3,
+					MaxInterval: 5 * time.Second,
+				},
+			},
+			failedAttempts: 3,
+			expected:       5 * time.Second,
+		},
+		{
+			name: "MinJitter",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy:   config.RetryPolicy{Jitter: 0.5},
+			},
+			jitter:         0,
+			failedAttempts: 1,
+			expected:       500 * time.Millisecond,
+		},
+		{
+			name: "MaxJitter",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy:   config.RetryPolicy{Jitter: 0.5},
+			},
+			jitter:         1,
+			failedAttempts: 1,
+			expected:       1500 * time.Millisecond,
+		},
+		{
+			name: "MaxJitterAtMaxInterval",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy: config.RetryPolicy{
+					Backoff:     config.BackoffExponential,
+					MaxInterval: 5 * time.Second,
+					Jitter:      0.5,
+				},
+			},
+			jitter:         1,
+			failedAttempts: 10,
+			expected:       5 * time.Second,
+		},
+		{
+			name:           "RetryAfter",
+			cfg:            config.HTTPHandler{RetryInterval: time.Second},
+			failedAttempts: 1,
+			err:            &requestError{statusCode: http.StatusTooManyRequests, retryAfter: time.Minute},
+			expected:       time.Minute,
+		},
+		{
+			name: "RetryAfterAboveMaxInterval",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy:   config.RetryPolicy{MaxInterval: 10 * time.Second},
+			},
+			failedAttempts: 1,
+			err:            &requestError{statusCode: http.StatusTooManyRequests, retryAfter: time.Minute},
+			expected:       10 * time.Second,
+		},
+		{
+			name: "ExponentialOverflow",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy:   config.RetryPolicy{Backoff: config.BackoffExponential},
+			},
+			failedAttempts: 100,
+			expected:       math.MaxInt64,
+		},
+		{
+			name: "IgnoredRetryAfter",
+			cfg: config.HTTPHandler{
+				RetryInterval: time.Second,
+				RetryPolicy:   config.RetryPolicy{IgnoreRetryAfter: true},
+			},
+			failedAttempts: 1,
+			err:            &requestError{statusCode: http.StatusTooManyRequests, retryAfter: time.Minute},
+			expected:       time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRetryPolicy(tt.cfg)
+			p.jitter = func() float64 { return tt.jitter }
+
+			assert.Equal(t, tt.expected, p.backoff(tt.failedAttempts, tt.err))
+		})
+	}
+}
+
+func TestRetryPolicy_IsRetryable(t *testing.T) {
+	policy := newRetryPolicy(config.HTTPHandler{
+		RetryPolicy: config.RetryPolicy{
+			RetryableStatusCodes: []int{429, 503},
+			RetryableErrors:      []config.RetryableError{config.RetryableErrorTimeout},
+		},
+	})
+	defaultPolicy := newRetryPolicy(config.HTTPHandler{})
+
+	tests := []struct {
+		name             string
+		err              error
+		retryable        bool
+		retryableDefault bool
+	}{
+		{
+			name:             "RetryableStatusCode",
+			err:              &requestError{statusCode: 503},
+			retryable:        true,
+			retryableDefault: true,
+		},
+		{
+			name:             "NotRetryableStatusCode",
+			err:              &requestError{statusCode: 400},
+			retryable:        false,
+			retryableDefault: false,
+		},
+		{
+			name:             "TransientStatusCode",
+			err:              &requestError{statusCode: 408},
+			retryable:        false,
+			retryableDefault: true,
+		},
+		{
+			name:             "TooManyRequests",
+			err:              &requestError{statusCode: 429},
+			retryable:        true,
+			retryableDefault: true,
+		},
+		{
+			name:             "RetryableErrorClass",
+			err:              &requestError{class: config.RetryableErrorTimeout},
+			retryable:        true,
+			retryableDefault: true,
+		},
+		{
+			name:             "NotRetryableErrorClass",
+			err:              &requestError{class: config.RetryableErrorDNS},
+			retryable:        false,
+			retryableDefault: true,
+		},
+		{
+			name:             "PermanentError",
+			err:              &requestError{permanent: true},
+			retryable:        false,
+			retryableDefault: false,
+		},
+		{
+			name:             "OtherError",
+			err:              fmt.Errorf("failed to authenticate HTTP request"),
+			retryable:        true,
+			retryableDefault: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.retryable, policy.isRetryable(tt.err))
+			assert.Equal(t, tt.retryableDefault, defaultPolicy.isRetryable(tt.err))
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, 2*time.Minute, parseRetryAfter("Mon, 01 Jan 2024 12:02:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Mon, 01 Jan 2024 11:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("invalid", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func TestHandler_HandleWithRetryPolicy(t *testing.T) {
+	t.Run("Not retryable status code", func(t *testing.T) {
+		serverCalls := 0
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serverCalls++
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer mockServer.Close()
+
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method:  "GET",
+			URL:     mockServer.URL,
+			Retries: 3,
+			RetryPolicy: config.RetryPolicy{
+				RetryableStatusCodes: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+			},
+		})
+
+		_, err := h.Handle(context.Background(), nil)
+		assert.EqualError(t, err, "failed to execute HTTP request: unexpected response code: got 400, expected 200")
+		assert.Equal(t, 1, serverCalls)
+	})
+
+	t.Run("Retry-After and attempt placeholder", func(t *testing.T) {
+		var attempts []string
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts = append(attempts, r.Header.Get("Idempotency-Key"))
+			if len(attempts) < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"results":[{}]}`))
+			assert.NoError(t, err)
+		}))
+		defer mockServer.Close()
+
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method:        "POST",
+			URL:           mockServer.URL,
+			Headers:       map[string]string{"Idempotency-Key": "{{ data-source.id }}-{{ _pipe.retry_attempt }}"},
+			Retries:       3,
+			RetryInterval: time.Millisecond,
+		})
+
+		result, err := h.Handle(context.Background(), map[string]string{"data-source.id": `"item"`})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(result))
+		assert.Equal(t, []string{"item-1", "item-2", "item-3"}, attempts)
+	})
+
+	t.Run("Retry deadline", func(t *testing.T) {
+		serverCalls := 0
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serverCalls++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer mockServer.Close()
+
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method:        "GET",
+			URL:           mockServer.URL,
+			Retries:       10,
+			RetryInterval: 20 * time.Millisecond,
+			RetryPolicy: config.RetryPolicy{
+				Backoff:        config.BackoffExponential,
+				MaxElapsedTime: 100 * time.Millisecond,
+			},
+		})
+
+		_, err := h.Handle(context.Background(), nil)
+		assert.ErrorContains(t, err, "retry deadline exceeded after 3 attempts")
+		assert.Equal(t, 3, serverCalls)
+	})
+
+	t.Run("Connection refused", func(t *testing.T) {
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		url := mockServer.URL
+		mockServer.Close()
+
+		h := newHTTPHandler("test-handler", config.HTTPHandler{
+			Method:  "GET",
+			URL:     url,
+			Retries: 3,
+			RetryPolicy: config.RetryPolicy{
+				RetryableErrors: []config.RetryableError{config.RetryableErrorTimeout},
+			},
+		})
+
+		_, err := h.executeRequest(context.Background(), nil, pageRequest{})
+		reqErr := &requestError{}
+		assert.ErrorAs(t, err, &reqErr)
+		assert.Equal(t, config.RetryableErrorConnectionRefused, reqErr.class)
+
+		_, err = h.Handle(context.Background(), nil)
+		assert.ErrorContains(t, err, "failed to execute HTTP request: failed to send HTTP request")
+	})
+}
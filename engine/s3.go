@@ -0,0 +1,641 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultS3Region     = "us-east-1"
+	defaultS3MaxObjects = 100
+	defaultS3BatchWait  = time.Second
+	defaultS3Timeout    = 30 * time.Second
+)
+
+func newS3Handler(
+	name string, cfg config.S3Handler, limiters []*limiter, dryRun bool, logger zerolog.Logger,
+) (Handler, error) {
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultS3Timeout
+	}
+
+	client, err := newS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.limiters = limiters
+
+	if cfg.Mode == config.S3ModeList {
+		return newS3Lister(name, cfg, dryRun, logger, client)
+	}
+	return newS3Writer(name, cfg, dryRun, logger, client), nil
+}
+
+// s3Client sends signed requests to the objects of a bucket.
+type s3Client struct {
+	endpoint   *url.URL
+	bucket     string
+	pathStyle  bool
+	timeout    time.Duration
+	signer     *sigV4Signer
+	httpClient *http.Client
+	limiters   []*limiter
+}
+
+// s3Object is an object of a list response.
+type s3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type s3ListResult struct {
+	IsTruncated           bool       `xml:"IsTruncated"`
+	Contents              []s3Object `xml:"Contents"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func newS3Client(cfg config.S3Handler) (*s3Client, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %s", err)
+	}
+	accessKeyID, err := cfg.AccessKeyID.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access key id: %s", err)
+	}
+	secretAccessKey, err := cfg.SecretAccessKey.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret access key: %s", err)
+	}
+	signer := &sigV4Signer{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		region:          cfg.Region,
+		service:         "s3",
+	}
+	if cfg.SessionToken != nil {
+		signer.sessionToken, err = cfg.SessionToken.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session token: %s", err)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS != nil {
+		transport.TLSClientConfig, err = newTLSConfig(*cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &s3Client{
+		endpoint:   endpoint,
+		bucket:     cfg.Bucket,
+		pathStyle:  cfg.PathStyle,
+		timeout:    cfg.Timeout,
+		signer:     signer,
+		httpClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+// objectURL returns the URL of the object, or of the bucket if the key is empty.
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if c.pathStyle {
+		path += "/" + c.bucket
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+	path += "/" + key
+
+	u.Path = path
+	u.RawPath = sigV4Escape(path, false)
+	u.RawQuery = sigV4Query(query)
+	return &u
+}
+
+// do sends a signed request and returns the response if it succeeded.
+func (c *s3Client) do(
+	ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string,
+) (*http.Response, []byte, error) {
+	release, err := acquireLimiters(ctx, c.limiters)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %s", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	c.signer.sign(req, sha256Hex(body))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %s", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		s3Err := s3Error{}
+		if err := xml.Unmarshal(respBody, &s3Err); err != nil || s3Err.Code == "" {
+			return nil, nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+		}
+		return nil, nil, fmt.Errorf("unexpected response code: %d: %s: %s", resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return resp, respBody, nil
+}
+
+// listObjects lists the objects under the prefix after the startAfter key, a page at a time.
+func (c *s3Client) listObjects(
+	ctx context.Context, prefix, startAfter, continuationToken string, maxKeys int,
+) (s3ListResult, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"max-keys":  {strconv.Itoa(maxKeys)},
+	}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if continuationToken != "" {
+		query.Set("continuation-token", continuationToken)
+	} else if startAfter != "" {
+		query.Set("start-after", startAfter)
+	}
+
+	_, body, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return s3ListResult{}, fmt.Errorf("failed to list objects: %s", err)
+	}
+	result := s3ListResult{}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return s3ListResult{}, fmt.Errorf("failed to decode list of objects: %s", err)
+	}
+	return result, nil
+}
+
+func (c *s3Client) getObject(ctx context.Context, key string) ([]byte, error) {
+	_, body, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get '%s' object: %s", key, err)
+	}
+	return body, nil
+}
+
+// putObject writes the object and returns its ETag.
+func (c *s3Client) putObject(ctx context.Context, key string, body []byte, contentType string) (string, error) {
+	resp, _, err := c.do(ctx, http.MethodPut, key, nil, body, map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return "", fmt.Errorf("failed to put '%s' object: %s", key, err)
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// s3Lister emits a result per record of the objects listed after the marker, the key of the last object
+// whose records were all processed. Objects after a failed one are read again on the next run.
+type s3Lister struct {
+	name   string
+	cfg    config.S3Handler
+	dryRun bool
+	logger zerolog.Logger
+	client *s3Client
+
+	mux    sync.Mutex
+	marker string
+	// objects are the objects read in the current run whose records are still processed, in the listing order
+	objects []*s3PendingObject
+}
+
+// s3PendingObject tracks the records of an object that are being processed.
+type s3PendingObject struct {
+	key       string
+	remaining int
+	emitted   bool
+	failed    bool
+}
+
+// s3State is the content of the state file.
+type s3State struct {
+	Marker string `json:"marker"`
+}
+
+func newS3Lister(
+	name string, cfg config.S3Handler, dryRun bool, logger zerolog.Logger, client *s3Client,
+) (*s3Lister, error) {
+	if cfg.MaxObjects == 0 {
+		cfg.MaxObjects = defaultS3MaxObjects
+	}
+	h := &s3Lister{
+		name:   name,
+		cfg:    cfg,
+		dryRun: dryRun,
+		logger: logger,
+		client: client,
+		marker: cfg.StartAfter,
+	}
+
+	if cfg.StateFile != "" {
+		data, err := os.ReadFile(cfg.StateFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read state file: %s", err)
+		}
+		if err == nil {
+			state := s3State{}
+			if err := json.Unmarshal(data, &state); err != nil {
+				return nil, fmt.Errorf("failed to decode state file: %s", err)
+			}
+			h.marker = state.Marker
+		}
+	}
+	return h, nil
+}
+
+func (h *s3Lister) setTransport(transport http.RoundTripper) {
+	h.client.httpClient.Transport = transport
+}
+
+func (h *s3Lister) Name() string {
+	return h.name
+}
+
+func (h *s3Lister) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	var results []HandlerResult
+	err := h.HandleStream(ctx, data, func(records []HandlerResult) error {
+		results = append(results, records...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HandleStream reads the objects listed after the marker one by one, until max objects are read.
+func (h *s3Lister) HandleStream(
+	ctx context.Context, _ map[string]string, emit func(results []HandlerResult) error,
+) error {
+	h.mux.Lock()
+	// the objects that failed in the previous run are listed again
+	h.objects = nil
+	startAfter := h.marker
+	h.mux.Unlock()
+
+	read := 0
+	token := ""
+	for read < h.cfg.MaxObjects {
+		list, err := h.client.listObjects(ctx, h.cfg.Prefix, startAfter, token, h.cfg.MaxObjects-read)
+		if err != nil {
+			return err
+		}
+		for _, object := range list.Contents {
+			if strings.HasSuffix(object.Key, "/") {
+				// folder placeholder
+				continue
+			}
+			if err := h.readObject(ctx, object.Key, emit); err != nil {
+				return err
+			}
+			read++
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	return nil
+}
+
+// readObject emits the records of the object, the object is tracked until all of them are acknowledged.
+func (h *s3Lister) readObject(ctx context.Context, key string, emit func(results []HandlerResult) error) error {
+	body, err := h.client.getObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	records, err := h.decode(body)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s' object: %s", key, err)
+	}
+
+	object := &s3PendingObject{key: key, remaining: len(records)}
+	h.mux.Lock()
+	h.objects = append(h.objects, object)
+	h.mux.Unlock()
+
+	for i, record := range records {
+		err := emit([]HandlerResult{{
+			"key":    mustMarshal(key),
+			"line":   mustMarshal(i + 1),
+			"record": mustMarshal(record),
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	object.emitted = true
+	h.advance()
+	return nil
+}
+
+func (h *s3Lister) decode(body []byte) ([]HandlerResult, error) {
+	if h.cfg.Format == config.S3FormatCSV {
+		return decodeCSV(body, h.cfg.CSV)
+	}
+	return decodeNDJSON(body)
+}
+
+// Ack counts down the records of the object of the result, the marker is moved past the object
+// once the rest of the chain succeeded for all of them.
+func (h *s3Lister) Ack(_ context.Context, result HandlerResult, chainErr error) {
+	var key string
+	if err := json.Unmarshal(result["key"], &key); err != nil {
+		h.logger.Error().Err(err).Msg("failed to acknowledge S3 record: invalid key")
+		return
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for _, object := range h.objects {
+		if object.key != key {
+			continue
+		}
+		object.remaining--
+		if chainErr != nil && !object.failed {
+			object.failed = true
+			h.logger.Warn().Str("key", key).Msg("S3 object failed, it will be read again on the next run")
+		}
+		h.advance()
+		return
+	}
+}
+
+// advance moves the marker past the leading objects that were fully processed. The mutex must be held.
+func (h *s3Lister) advance() {
+	marker := ""
+	for len(h.objects) > 0 {
+		object := h.objects[0]
+		if !object.emitted || object.remaining > 0 || object.failed {
+			break
+		}
+		marker = object.key
+		h.objects = h.objects[1:]
+	}
+	if marker == "" {
+		return
+	}
+
+	if h.dryRun {
+		h.logger.Info().Str("marker", marker).Msg("dry run: S3 marker not saved")
+		return
+	}
+	h.marker = marker
+	if h.cfg.StateFile == "" {
+		return
+	}
+	if err := saveS3State(h.cfg.StateFile, s3State{Marker: marker}); err != nil {
+		h.logger.Error().Err(err).Str("marker", marker).Msg("failed to save S3 marker")
+	}
+}
+
+// saveS3State replaces the state file, so it's never left partially written.
+func saveS3State(path string, state s3State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// s3Writer writes an object per record. With batching, the records of a batch are written as lines
+// of an object per rendered key.
+type s3Writer struct {
+	name   string
+	cfg    config.S3Handler
+	dryRun bool
+	logger zerolog.Logger
+	client *s3Client
+
+	batchMux sync.Mutex
+	batch    *s3Batch
+}
+
+// s3Batch collects the lines of records until it's full or the batch wait time passes.
+type s3Batch struct {
+	id      string
+	objects map[string]*s3BatchObject
+	keys    []string
+	size    int
+	full    chan struct{}
+	// done is closed once the objects of the batch are written
+	done chan struct{}
+}
+
+type s3BatchObject struct {
+	lines []string
+	etag  string
+	err   error
+}
+
+func newS3Writer(name string, cfg config.S3Handler, dryRun bool, logger zerolog.Logger, client *s3Client) *s3Writer {
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 1
+	}
+	if cfg.BatchWait == 0 {
+		cfg.BatchWait = defaultS3BatchWait
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+		if cfg.BatchSize > 1 {
+			cfg.ContentType = "application/x-ndjson"
+		}
+	}
+	return &s3Writer{
+		name:   name,
+		cfg:    cfg,
+		dryRun: dryRun,
+		logger: logger,
+		client: client,
+	}
+}
+
+func (h *s3Writer) setTransport(transport http.RoundTripper) {
+	h.client.httpClient.Transport = transport
+}
+
+func (h *s3Writer) Name() string {
+	return h.name
+}
+
+func (h *s3Writer) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	if h.cfg.BatchSize > 1 {
+		return h.writeInBatch(data)
+	}
+
+	key, body, err := h.render(data, newS3BatchID())
+	if err != nil {
+		return nil, err
+	}
+	etag, err := h.write(ctx, key, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+	return []HandlerResult{{"key": mustMarshal(key), "etag": mustMarshal(etag)}}, nil
+}
+
+// render returns the key and the body of the record, the batch ID is available as a placeholder.
+func (h *s3Writer) render(data map[string]string, batchID string) (string, string, error) {
+	data = copyMap(data)
+	data[config.S3BatchIDPlaceholder] = string(mustMarshal(batchID))
+
+	key, ok := replaceTextPlaceholders(h.cfg.Key, data)
+	if !ok {
+		return "", "", fmt.Errorf("failed to replace placeholders in key: some data not found")
+	}
+	body, ok := replacePlaceholders(h.cfg.Body, data)
+	if !ok {
+		return "", "", fmt.Errorf("failed to replace placeholders in body: some data not found")
+	}
+	return key, body, nil
+}
+
+// writeInBatch adds the record to the current batch and waits until the batch is written.
+func (h *s3Writer) writeInBatch(data map[string]string) ([]HandlerResult, error) {
+	h.batchMux.Lock()
+	b := h.batch
+	if b == nil {
+		b = &s3Batch{
+			id:      newS3BatchID(),
+			objects: map[string]*s3BatchObject{},
+			full:    make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		h.batch = b
+		go h.writeBatch(b)
+	}
+	key, body, err := h.render(data, b.id)
+	if err != nil {
+		h.batchMux.Unlock()
+		return nil, err
+	}
+	object, ok := b.objects[key]
+	if !ok {
+		object = &s3BatchObject{}
+		b.objects[key] = object
+		b.keys = append(b.keys, key)
+	}
+	object.lines = append(object.lines, body)
+	b.size++
+	if b.size == h.cfg.BatchSize {
+		h.batch = nil
+		close(b.full)
+	}
+	h.batchMux.Unlock()
+
+	<-b.done
+	if object.err != nil {
+		return nil, object.err
+	}
+	return []HandlerResult{{"key": mustMarshal(key), "etag": mustMarshal(object.etag)}}, nil
+}
+
+// writeBatch writes the objects of the batch once it's full or the batch wait time passed.
+// The batch is shared by several records, so it doesn't depend on the context of any of them.
+func (h *s3Writer) writeBatch(b *s3Batch) {
+	defer close(b.done)
+
+	timer := time.NewTimer(h.cfg.BatchWait)
+	defer timer.Stop()
+	select {
+	case <-b.full:
+	case <-timer.C:
+		h.batchMux.Lock()
+		if h.batch == b {
+			h.batch = nil
+		}
+		h.batchMux.Unlock()
+	}
+
+	for _, key := range b.keys {
+		object := b.objects[key]
+		body := strings.Join(object.lines, "\n") + "\n"
+		object.etag, object.err = h.write(context.Background(), key, []byte(body))
+		if object.err == nil {
+			h.logger.Debug().Str("key", key).Int("records", len(object.lines)).Msg("batch written")
+		}
+	}
+}
+
+func (h *s3Writer) write(ctx context.Context, key string, body []byte) (string, error) {
+	if h.dryRun {
+		h.logger.Info().
+			Str("bucket", h.cfg.Bucket).
+			Str("key", key).
+			Str("body", string(body)).
+			Msg("dry run: object recorded")
+		return "", nil
+	}
+	return h.client.putObject(ctx, key, body, h.cfg.ContentType)
+}
+
+// newS3BatchID returns an ID that sorts by the time it was created, so objects written with it in their keys
+// are listed in order.
+func newS3BatchID() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return time.Now().UTC().Format(sigV4DateFormat) + "-" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,383 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an in-memory S3 bucket with path-style URLs, which checks the signature of every request.
+type fakeS3 struct {
+	bucket   string
+	pageSize int
+
+	mux          sync.Mutex
+	objects      map[string][]byte
+	contentTypes map[string]string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	s := &fakeS3{
+		bucket:       "exports",
+		pageSize:     1000,
+		objects:      map[string][]byte{},
+		contentTypes: map[string]string{},
+	}
+	server := httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *fakeS3) put(key, body string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.objects[key] = []byte(body)
+}
+
+func (s *fakeS3) get(key string) (string, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	body, ok := s.objects[key]
+	return string(body), ok
+}
+
+func (s *fakeS3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.validSignature(r) {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != s.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		s.list(w, r.URL.Query())
+	case r.Method == http.MethodGet:
+		body, ok := s.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		_, _ = w.Write(body)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		s.objects[key] = body
+		s.contentTypes[key] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"`+sha256Hex(body)[:32]+`"`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// validSignature signs a copy of the request with the credentials of the test and compares the signatures.
+func (s *fakeS3) validSignature(r *http.Request) bool {
+	signedAt, err := time.Parse(sigV4DateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+	if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		return false
+	}
+
+	signer := &sigV4Signer{
+		accessKeyID:     "minio",
+		secretAccessKey: "minio-secret",
+		region:          defaultS3Region,
+		service:         "s3",
+		now:             func() time.Time { return signedAt },
+	}
+	expected := r.Clone(context.Background())
+	expected.Header = http.Header{}
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			expected.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	signer.sign(expected, sha256Hex(body))
+	return expected.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+	if maxKeys == 0 || maxKeys > s.pageSize {
+		maxKeys = s.pageSize
+	}
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := s3ListResult{}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, s3Object{Key: key, Size: int64(len(s.objects[key]))})
+	}
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		s3ListResult
+	}{s3ListResult: result})
+	_, _ = w.Write(body)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		s3Error
+	}{s3Error: s3Error{Code: code, Message: message}})
+	_, _ = w.Write(body)
+}
+
+func newTestS3Handler(t *testing.T, server *httptest.Server, cfg config.S3Handler, dryRun bool) Handler {
+	cfg.Endpoint = server.URL
+	cfg.Bucket = "exports"
+	cfg.PathStyle = true
+	cfg.AccessKeyID = config.Secret{Value: "minio"}
+	if cfg.SecretAccessKey.Value == "" {
+		cfg.SecretAccessKey = config.Secret{Value: "minio-secret"}
+	}
+	h, err := newS3Handler("files", cfg, nil, dryRun, zerolog.Nop())
+	require.NoError(t, err)
+	return h
+}
+
+func TestS3Lister_Marker(t *testing.T) {
+	s3, server := newFakeS3(t)
+	// a page per object, so listing continues over several pages
+	s3.pageSize = 1
+	s3.put("orders/", "")
+	s3.put("orders/1.jsonl", `{"id": 1}`+"\n"+`{"id": 2}`)
+	s3.put("orders/2.jsonl", `{"id": 3}`)
+	s3.put("orders/3.jsonl", `{"id": 4}`)
+	s3.put("other/1.jsonl", `{"id": 100}`)
+
+	cfg := config.S3Handler{
+		Mode:      config.S3ModeList,
+		Prefix:    "orders/",
+		Format:    config.S3FormatJSONL,
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	fail := true
+	processedMux := sync.Mutex{}
+	var processed []string
+	sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		processedMux.Lock()
+		processed = append(processed, data["files.record"])
+		processedMux.Unlock()
+		if fail && data["files.record"] == `{"id":3}` {
+			return nil, fmt.Errorf("sink is down")
+		}
+		return nil, nil
+	}}
+	run := func(h Handler) []string {
+		processed = nil
+		err := runHandlerPipe(context.Background(), map[string]string{}, []Handler{h, sink}, nil)
+		if fail {
+			assert.ErrorContains(t, err, "sink is down")
+		} else {
+			assert.NoError(t, err)
+		}
+		sort.Strings(processed)
+		return processed
+	}
+	marker := func() string {
+		data, err := os.ReadFile(cfg.StateFile)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	h := newTestS3Handler(t, server, cfg, false)
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`, `{"id":3}`, `{"id":4}`}, run(h))
+	assert.Equal(t, `{"marker":"orders/1.jsonl"}`, marker())
+
+	// the failed object and the objects after it are read again
+	fail = false
+	assert.Equal(t, []string{`{"id":3}`, `{"id":4}`}, run(h))
+	assert.Equal(t, `{"marker":"orders/3.jsonl"}`, marker())
+
+	// the marker is loaded from the state file
+	h = newTestS3Handler(t, server, cfg, false)
+	assert.Empty(t, run(h))
+	s3.put("orders/4.jsonl", `{"id": 5}`)
+	assert.Equal(t, []string{`{"id":5}`}, run(h))
+	assert.Equal(t, `{"marker":"orders/4.jsonl"}`, marker())
+
+	// objects added with a key that sorts before the marker are never listed
+	s3.put("orders/0.jsonl", `{"id": 0}`)
+	assert.Empty(t, run(h))
+}
+
+func TestS3Lister_CSV(t *testing.T) {
+	s3, server := newFakeS3(t)
+	s3.put("customers/2024-01-01.csv", "id;name\n1;alice\n2;bob\n")
+	s3.put("customers/2024-01-02.csv", "id;name\n3;carol\n")
+
+	h := newTestS3Handler(t, server, config.S3Handler{
+		Mode:       config.S3ModeList,
+		Prefix:     "customers/",
+		Format:     config.S3FormatCSV,
+		CSV:        config.CSVFormat{Delimiter: ";"},
+		MaxObjects: 1,
+	}, true)
+
+	results, err := h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{
+		{
+			"key":    json.RawMessage(`"customers/2024-01-01.csv"`),
+			"line":   json.RawMessage(`1`),
+			"record": json.RawMessage(`{"id":"1","name":"alice"}`),
+		},
+		{
+			"key":    json.RawMessage(`"customers/2024-01-01.csv"`),
+			"line":   json.RawMessage(`2`),
+			"record": json.RawMessage(`{"id":"2","name":"bob"}`),
+		},
+	}, results)
+	name, ok := replacePlaceholders("{{ files.record.name }}",
+		map[string]string{"files.record": string(results[0]["record"])})
+	require.True(t, ok)
+	assert.Equal(t, `"alice"`, name)
+
+	// in dry run, the marker is not moved
+	for _, result := range results {
+		h.(ackHandler).Ack(context.Background(), result, nil)
+	}
+	results, err = h.Handle(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestS3Lister_Errors(t *testing.T) {
+	s3, server := newFakeS3(t)
+	s3.put("orders/1.jsonl", `{"id": 1`)
+
+	h := newTestS3Handler(t, server, config.S3Handler{
+		Mode:   config.S3ModeList,
+		Format: config.S3FormatJSONL,
+	}, false)
+	_, err := h.Handle(context.Background(), nil)
+	assert.EqualError(t, err, "failed to parse 'orders/1.jsonl' object: line 1: unexpected EOF")
+
+	h = newTestS3Handler(t, server, config.S3Handler{
+		Mode:            config.S3ModeList,
+		Format:          config.S3FormatJSONL,
+		SecretAccessKey: config.Secret{Value: "other-secret"},
+	}, false)
+	_, err = h.Handle(context.Background(), nil)
+	assert.EqualError(t, err, "failed to list objects: unexpected response code: 403: "+
+		"SignatureDoesNotMatch: The request signature does not match")
+}
+
+func TestS3Writer_Put(t *testing.T) {
+	s3, server := newFakeS3(t)
+	cfg := config.S3Handler{
+		Mode: config.S3ModePut,
+		Key:  "orders/{{ source.date }}/order {{ source.id }}.json",
+		Body: `{"id": {{ source.id }}, "total": {{ source.total }}}`,
+	}
+	data := map[string]string{"source.date": `"2024-01-01"`, "source.id": `7`, "source.total": `9.5`}
+
+	_, err := newTestS3Handler(t, server, cfg, true).Handle(context.Background(), data)
+	require.NoError(t, err)
+	_, ok := s3.get("orders/2024-01-01/order 7.json")
+	assert.False(t, ok)
+
+	results, err := newTestS3Handler(t, server, cfg, false).Handle(context.Background(), data)
+	require.NoError(t, err)
+	body, ok := s3.get("orders/2024-01-01/order 7.json")
+	require.True(t, ok)
+	assert.Equal(t, `{"id": 7, "total": 9.5}`, body)
+	assert.Equal(t, "application/json", s3.contentTypes["orders/2024-01-01/order 7.json"])
+	assert.Equal(t, []HandlerResult{{
+		"key":  json.RawMessage(`"orders/2024-01-01/order 7.json"`),
+		"etag": mustMarshal(sha256Hex([]byte(body))[:32]),
+	}}, results)
+
+	_, err = newTestS3Handler(t, server, cfg, false).Handle(context.Background(), map[string]string{})
+	assert.EqualError(t, err, "failed to replace placeholders in key: some data not found")
+}
+
+func TestS3Writer_Batch(t *testing.T) {
+	s3, server := newFakeS3(t)
+	h := newTestS3Handler(t, server, config.S3Handler{
+		Mode:      config.S3ModePut,
+		Key:       "exports/{{ source.date }}/{{ _pipe.batch_id }}.jsonl",
+		Body:      `{"id": {{ source.id }}}`,
+		BatchSize: 3,
+		BatchWait: time.Minute,
+	}, false)
+
+	// 3 records fill a batch, they are written to an object per date
+	wg := sync.WaitGroup{}
+	keys := make([]string, 3)
+	for i, date := range []string{"a", "a", "b"} {
+		wg.Add(1)
+		go func(i int, date string) {
+			defer wg.Done()
+			results, err := h.Handle(context.Background(), map[string]string{
+				"source.date": fmt.Sprintf("%q", date),
+				"source.id":   strconv.Itoa(i + 1),
+			})
+			assert.NoError(t, err)
+			require.Len(t, results, 1)
+			require.NoError(t, json.Unmarshal(results[0]["key"], &keys[i]))
+		}(i, date)
+	}
+	wg.Wait()
+
+	assert.Equal(t, keys[0], keys[1])
+	assert.True(t, strings.HasPrefix(keys[0], "exports/a/"))
+	assert.True(t, strings.HasPrefix(keys[2], "exports/b/"))
+	assert.Equal(t, strings.TrimPrefix(keys[0], "exports/a/"), strings.TrimPrefix(keys[2], "exports/b/"))
+
+	body, ok := s3.get(keys[0])
+	require.True(t, ok)
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	assert.ElementsMatch(t, []string{`{"id": 1}`, `{"id": 2}`}, lines)
+	body, ok = s3.get(keys[2])
+	require.True(t, ok)
+	assert.Equal(t, `{"id": 3}`+"\n", body)
+	assert.Equal(t, "application/x-ndjson", s3.contentTypes[keys[2]])
+}
@@ -0,0 +1,353 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	// database drivers of the sql handler
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/ncruces/go-sqlite3/driver"
+	_ "github.com/ncruces/go-sqlite3/embed"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultSQLBatchWait = 100 * time.Millisecond
+	defaultSQLTimeout   = 15 * time.Second
+)
+
+// sqlDriverNames maps the configured drivers to the names they are registered with in database/sql.
+// nolint: gochecknoglobals
+var sqlDriverNames = map[config.SQLDriver]string{
+	config.SQLDriverPostgres: "pgx",
+	config.SQLDriverMySQL:    "mysql",
+	config.SQLDriverSQLite:   "sqlite3",
+}
+
+// sqlHandler runs a query per record and emits a result per row, or executes a statement per record.
+// Statements of several records are executed in a single transaction if batching is enabled.
+type sqlHandler struct {
+	name     string
+	cfg      config.SQLHandler
+	dryRun   bool
+	logger   zerolog.Logger
+	db       *sql.DB
+	limiters []*limiter
+
+	batchMux sync.Mutex
+	batch    *sqlBatch
+}
+
+// sqlStatement is a query with its placeholders replaced by bind variables.
+type sqlStatement struct {
+	query string
+	args  []any
+}
+
+// sqlBatch collects the statements of records until it's full or the batch wait time passes.
+type sqlBatch struct {
+	statements []sqlStatement
+	full       chan struct{}
+	// done is closed once the batch is executed
+	done         chan struct{}
+	rowsAffected []int64
+	errs         []error
+}
+
+func newSQLHandler(name string, cfg config.SQLHandler, dryRun bool, logger zerolog.Logger) (*sqlHandler, error) {
+	if cfg.Mode == "" {
+		cfg.Mode = config.SQLModeQuery
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 1
+	}
+	if cfg.BatchWait == 0 {
+		cfg.BatchWait = defaultSQLBatchWait
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultSQLTimeout
+	}
+
+	driverName, ok := sqlDriverNames[cfg.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown sql driver: %s", cfg.Driver)
+	}
+	dsn, err := cfg.DSN.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dsn: %s", err)
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %s", err)
+	}
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+
+	return &sqlHandler{
+		name:   name,
+		cfg:    cfg,
+		dryRun: dryRun,
+		logger: logger,
+		db:     db,
+	}, nil
+}
+
+func (h *sqlHandler) Name() string {
+	return h.name
+}
+
+func (h *sqlHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	stmt, err := h.createStatement(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// queries are run in dry-run mode too, they only read the data the rest of the chain needs
+	if h.cfg.Mode == config.SQLModeQuery {
+		return h.query(ctx, stmt)
+	}
+
+	if h.dryRun {
+		h.logger.Info().
+			Str("query", stmt.query).
+			Interface("args", stmt.args).
+			Msg("dry run: statement recorded")
+		return []HandlerResult{{}}, nil
+	}
+
+	var rowsAffected int64
+	if h.cfg.BatchSize > 1 {
+		rowsAffected, err = h.execInBatch(ctx, stmt)
+	} else {
+		var affected []int64
+		affected, err = h.exec(ctx, []sqlStatement{stmt})
+		if err == nil {
+			rowsAffected = affected[0]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []HandlerResult{{"rows_affected": json.RawMessage(strconv.FormatInt(rowsAffected, 10))}}, nil
+}
+
+// createStatement replaces the placeholders of the query with bind variables, their values are the query arguments.
+func (h *sqlHandler) createStatement(data map[string]string) (sqlStatement, error) {
+	var args []any
+	query, ok := renderPlaceholders(h.cfg.Query, data, func(value string) string {
+		args = append(args, sqlArg(value))
+		if h.cfg.Driver == config.SQLDriverPostgres {
+			return "$" + strconv.Itoa(len(args))
+		}
+		return "?"
+	})
+	if !ok {
+		return sqlStatement{}, fmt.Errorf("failed to replace placeholders in query: some data not found")
+	}
+	return sqlStatement{query: query, args: args}, nil
+}
+
+func (h *sqlHandler) query(ctx context.Context, stmt sqlStatement) ([]HandlerResult, error) {
+	release, err := acquireLimiters(ctx, h.limiters)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	rows, err := h.db.QueryContext(ctx, stmt.query, stmt.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %s", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %s", err)
+	}
+	results := []HandlerResult{}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to read row: %s", err)
+		}
+
+		result := make(HandlerResult, len(columns))
+		for i, column := range columns {
+			result[column] = sqlValue(values[i])
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %s", err)
+	}
+	return results, nil
+}
+
+// execInBatch adds the statement to the current batch and waits until the batch is executed.
+// If the context is done first, the statement is still executed with the batch, but its result is not awaited.
+func (h *sqlHandler) execInBatch(ctx context.Context, stmt sqlStatement) (int64, error) {
+	h.batchMux.Lock()
+	b := h.batch
+	if b == nil {
+		b = &sqlBatch{full: make(chan struct{}), done: make(chan struct{})}
+		h.batch = b
+		go h.executeBatch(b)
+	}
+	i := len(b.statements)
+	b.statements = append(b.statements, stmt)
+	if len(b.statements) == h.cfg.BatchSize {
+		h.batch = nil
+		close(b.full)
+	}
+	h.batchMux.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	if b.errs[i] != nil {
+		return 0, b.errs[i]
+	}
+	return b.rowsAffected[i], nil
+}
+
+// executeBatch executes the batch once it's full or the batch wait time passed.
+// The batch is shared by several records, so it doesn't depend on the context of any of them.
+func (h *sqlHandler) executeBatch(b *sqlBatch) {
+	defer close(b.done)
+
+	timer := time.NewTimer(h.cfg.BatchWait)
+	defer timer.Stop()
+	select {
+	case <-b.full:
+	case <-timer.C:
+		h.batchMux.Lock()
+		if h.batch == b {
+			h.batch = nil
+		}
+		h.batchMux.Unlock()
+	}
+
+	b.errs = make([]error, len(b.statements))
+	var err error
+	b.rowsAffected, err = h.exec(context.Background(), b.statements)
+	if err == nil {
+		h.logger.Debug().Int("statements", len(b.statements)).Msg("batch executed")
+		return
+	}
+	if len(b.statements) == 1 {
+		b.errs[0] = err
+		return
+	}
+
+	// the statements are executed one by one, so a failed statement only fails its own record
+	h.logger.Warn().Err(err).Int("statements", len(b.statements)).Msg("batch failed, executing statements one by one")
+	b.rowsAffected = make([]int64, len(b.statements))
+	for i, stmt := range b.statements {
+		affected, err := h.exec(context.Background(), []sqlStatement{stmt})
+		if err != nil {
+			b.errs[i] = err
+			continue
+		}
+		b.rowsAffected[i] = affected[0]
+	}
+}
+
+// exec executes the statements in a transaction and returns the number of rows affected by each of them.
+func (h *sqlHandler) exec(ctx context.Context, statements []sqlStatement) ([]int64, error) {
+	release, err := acquireLimiters(ctx, h.limiters)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %s", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rowsAffected := make([]int64, 0, len(statements))
+	for _, stmt := range statements {
+		res, err := tx.ExecContext(ctx, stmt.query, stmt.args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute statement: %s", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read affected rows: %s", err)
+		}
+		rowsAffected = append(rowsAffected, affected)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %s", err)
+	}
+	return rowsAffected, nil
+}
+
+// sqlArg converts the JSON value of a placeholder into a query argument.
+// Objects and arrays are passed as JSON strings.
+func sqlArg(value string) any {
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return value
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case string, bool, nil:
+		return v
+	default:
+		return value
+	}
+}
+
+// sqlValue converts a column value into JSON. Bytes are converted into strings and times are formatted as RFC 3339.
+func sqlValue(value any) json.RawMessage {
+	switch v := value.(type) {
+	case []byte:
+		return mustMarshal(string(v))
+	case time.Time:
+		return mustMarshal(v.Format(time.RFC3339Nano))
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return mustMarshal(fmt.Sprint(value))
+	}
+	return raw
+}
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSQLHandler creates a handler on a new SQLite database with an 'orders' table.
+func newTestSQLHandler(t *testing.T, cfg config.SQLHandler) *sqlHandler {
+	cfg.Driver = config.SQLDriverSQLite
+	cfg.DSN = config.Secret{Value: "file:" + filepath.Join(t.TempDir(), "test.db")}
+	h, err := newSQLHandler("db", cfg, false, zerolog.Nop())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = h.db.Close()
+	})
+
+	_, err = h.db.Exec(`CREATE TABLE orders (id INTEGER PRIMARY KEY, customer TEXT, total REAL, paid BOOLEAN)`)
+	require.NoError(t, err)
+	return h
+}
+
+func TestSQLHandler_Query(t *testing.T) {
+	h := newTestSQLHandler(t, config.SQLHandler{
+		Query: "SELECT id, customer, total FROM orders WHERE customer = {{ source.customer }} AND total > {{ source.min }} ORDER BY id",
+	})
+	_, err := h.db.Exec(`INSERT INTO orders (id, customer, total) VALUES (1, 'alice', 10.5), (2, 'alice', 1), (3, 'bob', 20)`)
+	require.NoError(t, err)
+
+	results, err := h.Handle(context.Background(), map[string]string{
+		"source.customer": `"alice"`,
+		"source.min":      `2`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{
+		"id":       json.RawMessage(`1`),
+		"customer": json.RawMessage(`"alice"`),
+		"total":    json.RawMessage(`10.5`),
+	}}, results)
+
+	// values are bound as arguments, never interpolated into the query
+	results, err = h.Handle(context.Background(), map[string]string{
+		"source.customer": `"alice' OR '1'='1"`,
+		"source.min":      `0`,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	_, err = h.Handle(context.Background(), map[string]string{})
+	assert.EqualError(t, err, "failed to replace placeholders in query: some data not found")
+}
+
+func TestSQLHandler_Exec(t *testing.T) {
+	h := newTestSQLHandler(t, config.SQLHandler{
+		Mode: config.SQLModeExec,
+		Query: "INSERT INTO orders (id, customer, paid) VALUES ({{ source.id }}, {{ source.customer }}, {{ source.paid }}) " +
+			"ON CONFLICT (id) DO UPDATE SET customer = excluded.customer",
+	})
+
+	results, err := h.Handle(context.Background(), map[string]string{
+		"source.id":       `1`,
+		"source.customer": `"alice"`,
+		"source.paid":     `true`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{"rows_affected": json.RawMessage(`1`)}}, results)
+
+	var customer string
+	var paid bool
+	require.NoError(t, h.db.QueryRow(`SELECT customer, paid FROM orders WHERE id = 1`).Scan(&customer, &paid))
+	assert.Equal(t, "alice", customer)
+	assert.True(t, paid)
+}
+
+func TestSQLHandler_ExecInBatch(t *testing.T) {
+	h := newTestSQLHandler(t, config.SQLHandler{
+		Mode:      config.SQLModeExec,
+		Query:     "INSERT INTO orders (id, customer) VALUES ({{ source.id }}, {{ source.customer }})",
+		BatchSize: 3,
+		BatchWait: 50 * time.Millisecond,
+	})
+
+	// 3 records fill a batch, the other 2 are executed after the batch wait
+	wg := sync.WaitGroup{}
+	errs := make([]error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = h.Handle(context.Background(), map[string]string{
+				"source.id":       fmt.Sprint(i + 1),
+				"source.customer": `"alice"`,
+			})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var count int
+	require.NoError(t, h.db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&count))
+	assert.Equal(t, 5, count)
+
+	// a failed statement only fails its own record
+	h.cfg.BatchSize = 2
+	wg = sync.WaitGroup{}
+	for i, id := range []string{"6", "1"} {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			_, errs[i] = h.Handle(context.Background(), map[string]string{
+				"source.id":       id,
+				"source.customer": `"bob"`,
+			})
+		}(i, id)
+	}
+	wg.Wait()
+	assert.NoError(t, errs[0])
+	assert.ErrorContains(t, errs[1], "failed to execute statement")
+	require.NoError(t, h.db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&count))
+	assert.Equal(t, 6, count)
+}
+
+func TestSQLHandler_ExecInBatchContextDone(t *testing.T) {
+	h := newTestSQLHandler(t, config.SQLHandler{
+		Mode:      config.SQLModeExec,
+		Query:     "INSERT INTO orders (id, customer) VALUES ({{ source.id }}, {{ source.customer }})",
+		BatchSize: 10,
+		BatchWait: time.Hour,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := h.Handle(ctx, map[string]string{"source.id": `1`, "source.customer": `"alice"`})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSQLHandler_DryRun(t *testing.T) {
+	h := newTestSQLHandler(t, config.SQLHandler{
+		Mode:  config.SQLModeExec,
+		Query: "INSERT INTO orders (id, customer) VALUES ({{ source.id }}, {{ source.customer }})",
+	})
+	logs := &bytes.Buffer{}
+	h.dryRun = true
+	h.logger = zerolog.New(logs)
+
+	results, err := h.Handle(context.Background(), map[string]string{"source.id": `1`, "source.customer": `"alice"`})
+	require.NoError(t, err)
+	assert.Equal(t, []HandlerResult{{}}, results)
+	assert.Contains(t, logs.String(), `"query":"INSERT INTO orders (id, customer) VALUES (?, ?)"`)
+	assert.Contains(t, logs.String(), `"args":[1,"alice"]`)
+
+	var count int
+	require.NoError(t, h.db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestSQLArg(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected any
+	}{
+		{value: `"text"`, expected: "text"},
+		{value: `42`, expected: int64(42)},
+		{value: `4.2`, expected: 4.2},
+		{value: `true`, expected: true},
+		{value: `null`, expected: nil},
+		{value: `{"a": 1}`, expected: `{"a": 1}`},
+		{value: `not json`, expected: `not json`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sqlArg(tt.value))
+		})
+	}
+}
+
+func TestSQLHandler_PostgresBindVariables(t *testing.T) {
+	h := &sqlHandler{cfg: config.SQLHandler{
+		Driver: config.SQLDriverPostgres,
+		Query:  "SELECT * FROM orders WHERE customer = {{ source.customer }} AND total > {{ source.min }}",
+	}}
+	stmt, err := h.createStatement(map[string]string{"source.customer": `"alice"`, "source.min": `2`})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM orders WHERE customer = $1 AND total > $2", stmt.query)
+	assert.Equal(t, []any{"alice", int64(2)}, stmt.args)
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+const (
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
+)
+
+// transportKey identifies the configs that result in the same transport.
+type transportKey struct {
+	tls       config.TLS
+	proxy     config.Proxy
+	transport config.Transport
+}
+
+// transportPool shares HTTP transports, and so their connection pools, between handlers with matching configs.
+type transportPool struct {
+	transports map[transportKey]*http.Transport
+}
+
+func newTransportPool() *transportPool {
+	return &transportPool{
+		transports: map[transportKey]*http.Transport{},
+	}
+}
+
+func (p *transportPool) get(cfg config.HTTPHandler) (*http.Transport, error) {
+	key := transportKey{
+		tls:       cfg.TLS,
+		proxy:     cfg.Proxy,
+		transport: cfg.Transport,
+	}
+	if t, ok := p.transports[key]; ok {
+		return t, nil
+	}
+
+	t, err := newTransport(key)
+	if err != nil {
+		return nil, err
+	}
+	p.transports[key] = t
+	return t, nil
+}
+
+func newTransport(key transportKey) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig, err := newTLSConfig(key.tls)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS config: %s", err)
+	}
+	t.TLSClientConfig = tlsConfig
+
+	switch {
+	case key.proxy.Disabled:
+		t.Proxy = nil
+	case key.proxy.URL != "":
+		proxyURL, err := url.Parse(key.proxy.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %s", err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	keepAlive := defaultKeepAlive
+	if key.transport.KeepAlive != 0 {
+		keepAlive = key.transport.KeepAlive
+	}
+	dialer := &net.Dialer{
+		Timeout:   defaultDialTimeout,
+		KeepAlive: keepAlive,
+	}
+	t.DialContext = dialer.DialContext
+
+	if key.transport.MaxIdleConns != 0 {
+		t.MaxIdleConns = key.transport.MaxIdleConns
+	}
+	if key.transport.MaxIdleConnsPerHost != 0 {
+		t.MaxIdleConnsPerHost = key.transport.MaxIdleConnsPerHost
+	}
+	if key.transport.IdleConnTimeout != 0 {
+		t.IdleConnTimeout = key.transport.IdleConnTimeout
+	}
+	t.MaxConnsPerHost = key.transport.MaxConnsPerHost
+	t.DisableKeepAlives = key.transport.DisableKeepAlives
+
+	if key.transport.DisableHTTP2 {
+		t.ForceAttemptHTTP2 = false
+		// a non-nil empty map disables HTTP/2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return t, nil
+}
+
+func newTLSConfig(cfg config.TLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		// nolint: gosec
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tlsVersion(cfg.MinVersion),
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func tlsVersion(version string) uint16 {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10
+	case "1.1":
+		return tls.VersionTLS11
+	case "1.3":
+		return tls.VersionTLS13
+	default:
+		return tls.VersionTLS12
+	}
+}
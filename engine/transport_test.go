@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportPool_Get(t *testing.T) {
+	pool := newTransportPool()
+
+	t1, err := pool.get(config.HTTPHandler{URL: "http://a.example.com"})
+	require.NoError(t, err)
+	t2, err := pool.get(config.HTTPHandler{URL: "http://b.example.com"})
+	require.NoError(t, err)
+	t3, err := pool.get(config.HTTPHandler{Transport: config.Transport{MaxIdleConns: 10, DisableHTTP2: true}})
+	require.NoError(t, err)
+
+	assert.Same(t, t1, t2)
+	assert.NotSame(t, t1, t3)
+	assert.Equal(t, 10, t3.MaxIdleConns)
+	assert.NotNil(t, t3.TLSNextProto)
+	assert.Empty(t, t3.TLSNextProto)
+}
+
+func TestTransportPool_GetInvalidTLS(t *testing.T) {
+	_, err := newTransportPool().get(config.HTTPHandler{TLS: config.TLS{CAFile: "/not/existing/ca.pem"}})
+
+	assert.ErrorContains(t, err, "invalid TLS config: failed to read CA file")
+}
+
+func TestHTTPHandler_TLS(t *testing.T) {
+	mockServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"results":[]}`))
+		assert.NoError(t, err)
+	}))
+	defer mockServer.Close()
+
+	clientCertFile, clientKeyFile, clientCert := writeClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	mockServer.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	mockServer.StartTLS()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: mockServer.Certificate().Raw,
+	}), 0o600))
+
+	handle := func(tlsCfg config.TLS) error {
+		cfg := config.Handler{HTTPHandler: config.HTTPHandler{
+			Method: "GET",
+			URL:    mockServer.URL,
+			TLS:    tlsCfg,
+		}}
+		h, err := newHandler("test-handler", cfg, testHandlerEnv(t, zerolog.Nop()))
+		require.NoError(t, err)
+		_, err = h.Handle(context.Background(), nil)
+		return err
+	}
+
+	t.Run("Unknown CA", func(t *testing.T) {
+		err := handle(config.TLS{})
+		assert.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("No client certificate", func(t *testing.T) {
+		err := handle(config.TLS{CAFile: caFile})
+		assert.Error(t, err)
+	})
+
+	t.Run("Mutual TLS", func(t *testing.T) {
+		err := handle(config.TLS{
+			CAFile:     caFile,
+			CertFile:   clientCertFile,
+			KeyFile:    clientKeyFile,
+			MinVersion: "1.2",
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestHTTPHandler_Proxy(t *testing.T) {
+	proxyCalls := 0
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyCalls++
+		assert.Equal(t, "http://api.example.com/items", r.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"results":[{}]}`))
+		assert.NoError(t, err)
+	}))
+	defer proxyServer.Close()
+
+	cfg := config.Handler{HTTPHandler: config.HTTPHandler{
+		Method: "GET",
+		URL:    "http://api.example.com/items",
+		Proxy:  config.Proxy{URL: proxyServer.URL},
+	}}
+	h, err := newHandler("test-handler", cfg, testHandlerEnv(t, zerolog.Nop()))
+	require.NoError(t, err)
+
+	result, err := h.Handle(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, 1, proxyCalls)
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key to temporary files.
+func writeClientCertificate(t *testing.T) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "datapipe"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile, cert
+}
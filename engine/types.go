@@ -5,7 +5,3 @@ import (
 )
 
 type HandlerResult map[string]json.RawMessage
-
-type handlerResponseBody struct {
-	Results []HandlerResult `json:"results"`
-}
@@ -0,0 +1,396 @@
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultWebhookMaxBodySize = 1 << 20
+	defaultWebhookWaitTime    = 10 * time.Second
+	defaultWebhookTimeout     = 30 * time.Second
+)
+
+// webhookHandler emits the records of the requests received on its route. The embedded server is started
+// by the first run and keeps running, requests that arrive between runs wait for the next one up to the timeout.
+type webhookHandler struct {
+	name     string
+	cfg      config.WebhookHandler
+	logger   zerolog.Logger
+	requests chan *webhookRequest
+
+	startOnce sync.Once
+	startErr  error
+	addr      net.Addr
+
+	mux     sync.Mutex
+	pending map[string]*webhookRequest
+}
+
+// webhookRequest is a received request whose records are waiting to be processed.
+type webhookRequest struct {
+	id      string
+	results []HandlerResult
+	// accepted receives the number of records passed down the chain, the others were rejected
+	accepted chan int
+
+	mux       sync.Mutex
+	remaining int
+	errs      []string
+	// done is closed once the chain completed for all records
+	done chan struct{}
+}
+
+func newWebhookHandler(name string, cfg config.WebhookHandler, logger zerolog.Logger) *webhookHandler {
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.Response == "" {
+		cfg.Response = config.WebhookResponseAccepted
+	}
+	if cfg.MaxBodySize == 0 {
+		cfg.MaxBodySize = defaultWebhookMaxBodySize
+	}
+	if cfg.WaitTime == 0 {
+		cfg.WaitTime = defaultWebhookWaitTime
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultWebhookTimeout
+	}
+	return &webhookHandler{
+		name:     name,
+		cfg:      cfg,
+		logger:   logger,
+		requests: make(chan *webhookRequest),
+		pending:  map[string]*webhookRequest{},
+	}
+}
+
+func (h *webhookHandler) Name() string {
+	return h.name
+}
+
+func (h *webhookHandler) Handle(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+	var results []HandlerResult
+	err := h.HandleStream(ctx, data, func(records []HandlerResult) error {
+		results = append(results, records...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// HandleStream emits the records of the received requests, until no request arrives for the wait time.
+func (h *webhookHandler) HandleStream(
+	ctx context.Context, _ map[string]string, emit func(results []HandlerResult) error,
+) error {
+	if err := h.start(ctx); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(h.cfg.WaitTime)
+	defer timer.Stop()
+	for {
+		var req *webhookRequest
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			// no new requests
+			return nil
+		case req = <-h.requests:
+		}
+
+		h.mux.Lock()
+		h.pending[req.id] = req
+		h.mux.Unlock()
+
+		// records are emitted one by one, so a failure rejects only the records that were not passed down the chain
+		accepted := 0
+		var err error
+		for _, result := range req.results {
+			if err = emit([]HandlerResult{result}); err != nil {
+				break
+			}
+			accepted++
+		}
+		for range req.results[accepted:] {
+			h.complete(req.id, fmt.Errorf("record was rejected: %s", err))
+		}
+		req.accepted <- accepted
+		if err != nil {
+			return err
+		}
+		timer.Reset(h.cfg.WaitTime)
+	}
+}
+
+// Ack records the result of the chain for a record, the request is completed with its last record.
+func (h *webhookHandler) Ack(_ context.Context, result HandlerResult, chainErr error) {
+	var id string
+	if err := json.Unmarshal(result["request_id"], &id); err != nil {
+		h.logger.Error().Err(err).Msg("failed to complete webhook request: invalid request id")
+		return
+	}
+	h.complete(id, chainErr)
+}
+
+// complete records the outcome of a record of the request, the request is completed with its last record.
+func (h *webhookHandler) complete(id string, err error) {
+	h.mux.Lock()
+	req, ok := h.pending[id]
+	h.mux.Unlock()
+	if !ok {
+		return
+	}
+
+	req.mux.Lock()
+	defer req.mux.Unlock()
+	if err != nil {
+		req.errs = append(req.errs, err.Error())
+	}
+	req.remaining--
+	if req.remaining == 0 {
+		h.mux.Lock()
+		delete(h.pending, id)
+		h.mux.Unlock()
+		close(req.done)
+	}
+}
+
+// start starts the embedded server once, it is shut down when ctx is cancelled.
+func (h *webhookHandler) start(ctx context.Context) error {
+	h.startOnce.Do(func() {
+		listener, err := net.Listen("tcp", h.cfg.Listen)
+		if err != nil {
+			h.startErr = fmt.Errorf("failed to start webhook server: %s", err)
+			return
+		}
+		server := &http.Server{
+			Handler:           h.routes(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		h.addr = listener.Addr()
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				h.logger.Error().Err(err).Msg("webhook server failed")
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			// waiting requests are answered within the timeout
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				_ = server.Close()
+			}
+			h.logger.Info().Str("addr", h.addr.String()).Msg("webhook server stopped")
+		}()
+		h.logger.Info().Str("addr", listener.Addr().String()).Str("path", h.cfg.Path).
+			Msg("webhook server started")
+	})
+	return h.startErr
+}
+
+func (h *webhookHandler) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(h.cfg.Method+" "+h.cfg.Path, h.serveHTTP)
+	return mux
+}
+
+func (h *webhookHandler) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.cfg.MaxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeWebhookError(w, http.StatusRequestEntityTooLarge, "request body is too large")
+			return
+		}
+		writeWebhookError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	if err := h.verifySignature(r, body); err != nil {
+		h.logger.Warn().Err(err).Str("remote_addr", r.RemoteAddr).Msg("webhook request rejected")
+		writeWebhookError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	req, err := newWebhookRequest(r, body)
+	if err != nil {
+		writeWebhookError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.results) == 0 {
+		writeWebhookResponse(w, http.StatusAccepted, map[string]any{"accepted": 0})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.Timeout)
+	defer cancel()
+	select {
+	case h.requests <- req:
+	case <-ctx.Done():
+		writeWebhookError(w, http.StatusServiceUnavailable, "pipeline is not accepting records")
+		return
+	}
+	accepted := <-req.accepted
+	if accepted == 0 {
+		writeWebhookError(w, http.StatusServiceUnavailable, "pipeline is not accepting records")
+		return
+	}
+
+	if h.cfg.Response == config.WebhookResponseAccepted {
+		body := map[string]any{"accepted": accepted}
+		if rejected := len(req.results) - accepted; rejected > 0 {
+			// the accepted records are processed, retrying the whole request would duplicate them
+			body["rejected"] = rejected
+		}
+		writeWebhookResponse(w, http.StatusAccepted, body)
+		return
+	}
+
+	select {
+	case <-req.done:
+	case <-ctx.Done():
+		writeWebhookError(w, http.StatusGatewayTimeout, "timed out waiting for the records to be processed")
+		return
+	}
+	req.mux.Lock()
+	errs := req.errs
+	req.mux.Unlock()
+	if len(errs) > 0 {
+		writeWebhookError(w, http.StatusInternalServerError, strings.Join(errs, "; "))
+		return
+	}
+	writeWebhookResponse(w, http.StatusOK, map[string]any{"processed": len(req.results)})
+}
+
+func (h *webhookHandler) verifySignature(r *http.Request, body []byte) error {
+	cfg := h.cfg.Signature
+	if cfg.Type == "" {
+		return nil
+	}
+
+	signature := r.Header.Get(cfg.Header)
+	if signature == "" {
+		return fmt.Errorf("'%s' header is missing", cfg.Header)
+	}
+	if !strings.HasPrefix(signature, cfg.Prefix) {
+		return fmt.Errorf("signature does not start with '%s'", cfg.Prefix)
+	}
+	signature = strings.TrimPrefix(signature, cfg.Prefix)
+
+	secret, err := cfg.Secret.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read secret: %s", err)
+	}
+
+	switch cfg.Type {
+	case config.WebhookSignatureSharedSecret:
+		if subtle.ConstantTimeCompare([]byte(signature), []byte(secret)) != 1 {
+			return fmt.Errorf("secret does not match")
+		}
+	case config.WebhookSignatureHMACSHA256:
+		actual, err := hex.DecodeString(signature)
+		if err != nil {
+			return fmt.Errorf("signature is not hex encoded")
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if !hmac.Equal(actual, mac.Sum(nil)) {
+			return fmt.Errorf("signature does not match")
+		}
+	default:
+		return fmt.Errorf("unknown signature type: %s", cfg.Type)
+	}
+	return nil
+}
+
+// newWebhookRequest parses the body, a JSON object or an array of objects, into a result per record.
+func newWebhookRequest(r *http.Request, body []byte) (*webhookRequest, error) {
+	var records []json.RawMessage
+	switch jsonKind(body) {
+	case '{':
+		records = []json.RawMessage{body}
+	case '[':
+		if err := json.Unmarshal(body, &records); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("body must be a JSON object or an array of objects")
+	}
+	for _, record := range records {
+		if jsonKind(record) != '{' {
+			return nil, fmt.Errorf("body must be a JSON object or an array of objects")
+		}
+		if !json.Valid(record) {
+			return nil, fmt.Errorf("invalid JSON body")
+		}
+	}
+
+	id, err := newRequestID()
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(r.Header))
+	for key := range r.Header {
+		headers[key] = r.Header.Get(key)
+	}
+	query := make(map[string]string, len(r.URL.Query()))
+	for key := range r.URL.Query() {
+		query[key] = r.URL.Query().Get(key)
+	}
+
+	req := &webhookRequest{
+		id:        id,
+		accepted:  make(chan int, 1),
+		remaining: len(records),
+		done:      make(chan struct{}),
+	}
+	for _, record := range records {
+		req.results = append(req.results, HandlerResult{
+			"body":       record,
+			"headers":    mustMarshal(headers),
+			"query":      mustMarshal(query),
+			"request_id": mustMarshal(id),
+		})
+	}
+	return req, nil
+}
+
+func newRequestID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate request id: %s", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func writeWebhookError(w http.ResponseWriter, status int, msg string) {
+	writeWebhookResponse(w, status, map[string]any{"error": msg})
+}
+
+func writeWebhookResponse(w http.ResponseWriter, status int, body map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runWebhookPipe serves the routes of the webhook handler and runs it with the sink until the test ends.
+func runWebhookPipe(t *testing.T, cfg config.WebhookHandler, sink Handler) *httptest.Server {
+	cfg.Path = "/hooks/orders"
+	if cfg.WaitTime == 0 {
+		cfg.WaitTime = time.Minute
+	}
+	h := newWebhookHandler("hook", cfg, zerolog.Nop())
+	// the embedded server is replaced by the test server
+	h.startOnce.Do(func() {})
+	server := httptest.NewServer(h.routes())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = runHandlerPipe(ctx, nil, []Handler{h, sink}, nil)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		server.Close()
+	})
+	return server
+}
+
+func postWebhook(t *testing.T, url, body string, headers map[string]string) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, url+"/hooks/orders?source=shop", strings.NewReader(body))
+	require.NoError(t, err)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, strings.TrimSpace(string(respBody))
+}
+
+func TestWebhookHandler_Accepted(t *testing.T) {
+	received := make(chan map[string]string, 2)
+	sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		received <- data
+		return nil, nil
+	}}
+	server := runWebhookPipe(t, config.WebhookHandler{}, sink)
+
+	status, body := postWebhook(t, server.URL, `[{"id": 1}, {"id": 2}]`, map[string]string{"X-Source": "shop"})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, `{"accepted":2}`, body)
+
+	ids := map[string]bool{}
+	fieldIDs := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		data := <-received
+		ids[data["hook.body"]] = true
+		id, ok := replacePlaceholders("{{ hook.body.id }}", data)
+		require.True(t, ok)
+		fieldIDs[id] = true
+		assert.Equal(t, "shop", jsonStringField(t, data["hook.headers"], "X-Source"))
+		assert.Equal(t, "shop", jsonStringField(t, data["hook.query"], "source"))
+		assert.NotEmpty(t, data["hook.request_id"])
+	}
+	assert.Equal(t, map[string]bool{`{"id": 1}`: true, `{"id": 2}`: true}, ids)
+	assert.Equal(t, map[string]bool{"1": true, "2": true}, fieldIDs)
+}
+
+func TestWebhookHandler_Result(t *testing.T) {
+	sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		if strings.Contains(data["hook.body"], "fail") {
+			return nil, fmt.Errorf("sink is down")
+		}
+		return nil, nil
+	}}
+	server := runWebhookPipe(t, config.WebhookHandler{Response: config.WebhookResponseResult}, sink)
+
+	status, body := postWebhook(t, server.URL, `{"id": 1}`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"processed":1}`, body)
+
+	status, body = postWebhook(t, server.URL, `[{"id": 2}, {"id": "fail"}]`, nil)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, body, "sink is down")
+}
+
+func TestWebhookHandler_InvalidRequests(t *testing.T) {
+	sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+		return nil, nil
+	}}
+	server := runWebhookPipe(t, config.WebhookHandler{MaxBodySize: 32}, sink)
+
+	status, body := postWebhook(t, server.URL, `"not an object"`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, `{"error":"body must be a JSON object or an array of objects"}`, body)
+
+	status, _ = postWebhook(t, server.URL, `{"id": 1`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = postWebhook(t, server.URL, `{"description": "longer than the max body size"}`, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	resp, err := http.Get(server.URL + "/hooks/orders")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestWebhookHandler_Signature(t *testing.T) {
+	body := `{"id": 1}`
+	mac := hmac.New(sha256.New, []byte("webhook-secret"))
+	mac.Write([]byte(body))
+	validHMAC := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		signature config.WebhookSignature
+		header    string
+		expected  int
+	}{
+		{
+			name: "ValidSharedSecret",
+			signature: config.WebhookSignature{
+				Type: config.WebhookSignatureSharedSecret, Header: "X-Token", Secret: config.Secret{Value: "webhook-secret"},
+			},
+			header:   "webhook-secret",
+			expected: http.StatusAccepted,
+		},
+		{
+			name: "InvalidSharedSecret",
+			signature: config.WebhookSignature{
+				Type: config.WebhookSignatureSharedSecret, Header: "X-Token", Secret: config.Secret{Value: "webhook-secret"},
+			},
+			header:   "other-secret",
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name: "ValidHMAC",
+			signature: config.WebhookSignature{
+				Type:   config.WebhookSignatureHMACSHA256,
+				Header: "X-Token",
+				Secret: config.Secret{Value: "webhook-secret"},
+				Prefix: "sha256=",
+			},
+			header:   validHMAC,
+			expected: http.StatusAccepted,
+		},
+		{
+			name: "InvalidHMAC",
+			signature: config.WebhookSignature{
+				Type:   config.WebhookSignatureHMACSHA256,
+				Header: "X-Token",
+				Secret: config.Secret{Value: "other-secret"},
+				Prefix: "sha256=",
+			},
+			header:   validHMAC,
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name: "MissingSignature",
+			signature: config.WebhookSignature{
+				Type: config.WebhookSignatureSharedSecret, Header: "X-Token", Secret: config.Secret{Value: "webhook-secret"},
+			},
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &mockHandler{handle: func(ctx context.Context, data map[string]string) ([]HandlerResult, error) {
+				return nil, nil
+			}}
+			server := runWebhookPipe(t, config.WebhookHandler{Signature: tt.signature}, sink)
+
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["X-Token"] = tt.header
+			}
+			status, _ := postWebhook(t, server.URL, body, headers)
+			assert.Equal(t, tt.expected, status)
+		})
+	}
+}
+
+func TestWebhookHandler_NotRunning(t *testing.T) {
+	h := newWebhookHandler("hook", config.WebhookHandler{Path: "/hooks/orders", Timeout: 10 * time.Millisecond},
+		zerolog.Nop())
+	server := httptest.NewServer(h.routes())
+	defer server.Close()
+
+	status, body := postWebhook(t, server.URL, `{"id": 1}`, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, `{"error":"pipeline is not accepting records"}`, body)
+}
+
+func TestWebhookHandler_PartiallyAccepted(t *testing.T) {
+	tests := []struct {
+		name           string
+		response       config.WebhookResponse
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Accepted",
+			response:       config.WebhookResponseAccepted,
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   `{"accepted":1,"rejected":1}`,
+		},
+		{
+			name:           "Result",
+			response:       config.WebhookResponseResult,
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"record was rejected: records stopped"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newWebhookHandler("hook", config.WebhookHandler{Path: "/hooks/orders", Response: tt.response},
+				zerolog.Nop())
+			h.startOnce.Do(func() {})
+			server := httptest.NewServer(h.routes())
+			defer server.Close()
+
+			// the pipeline stops accepting records after the first one
+			emitted := 0
+			done := make(chan error, 1)
+			go func() {
+				done <- h.HandleStream(context.Background(), nil, func(results []HandlerResult) error {
+					if emitted > 0 {
+						return errRecordsStopped
+					}
+					emitted++
+					h.Ack(context.Background(), results[0], nil)
+					return nil
+				})
+			}()
+
+			status, body := postWebhook(t, server.URL, `[{"id": 1}, {"id": 2}]`, nil)
+			assert.Equal(t, tt.expectedStatus, status)
+			assert.Equal(t, tt.expectedBody, body)
+			assert.ErrorIs(t, <-done, errRecordsStopped)
+		})
+	}
+}
+
+func TestWebhookHandler_ShutdownOnCancel(t *testing.T) {
+	h := newWebhookHandler("hook", config.WebhookHandler{Listen: "127.0.0.1:0", Path: "/hooks/orders"},
+		zerolog.Nop())
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, h.start(ctx))
+	url := "http://" + h.addr.String()
+
+	status, _ := postWebhook(t, url, `not json`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		resp, err := http.Post(url+"/hooks/orders", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			return true
+		}
+		resp.Body.Close()
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
+
+func jsonStringField(t *testing.T, raw, key string) string {
+	var values map[string]string
+	require.NoError(t, json.Unmarshal([]byte(raw), &values))
+	return values[key]
+}
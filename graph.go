@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jaxmef/datapipe/config"
+)
+
+type graphFormat string
+
+const (
+	graphFormatDOT     graphFormat = "dot"
+	graphFormatMermaid graphFormat = "mermaid"
+)
+
+// renderGraph renders the handler chain in the given format.
+func renderGraph(handlers config.HandlerMap, format graphFormat) (string, error) {
+	switch format {
+	case graphFormatDOT:
+		return renderDOT(handlers), nil
+	case graphFormatMermaid:
+		return renderMermaid(handlers), nil
+	default:
+		return "", fmt.Errorf("unknown graph format: '%s'", format)
+	}
+}
+
+func renderDOT(handlers config.HandlerMap) string {
+	sb := strings.Builder{}
+	sb.WriteString("digraph datapipe {\n")
+	sb.WriteString("  rankdir=LR;\n")
+	for _, handlerItem := range handlers {
+		fmt.Fprintf(&sb, "  %q [label=%q];\n", handlerItem.Name, handlerItem.Name+"\n"+handlerLabel(handlerItem.Handler))
+	}
+	for i := 1; i < len(handlers); i++ {
+		fmt.Fprintf(&sb, "  %q -> %q;\n", handlers[i-1].Name, handlers[i].Name)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func renderMermaid(handlers config.HandlerMap) string {
+	sb := strings.Builder{}
+	sb.WriteString("flowchart LR\n")
+	for i, handlerItem := range handlers {
+		label := strings.ReplaceAll(handlerItem.Name+"<br>"+handlerLabel(handlerItem.Handler), `"`, "#quot;")
+		fmt.Fprintf(&sb, "  h%d[\"%s\"]\n", i, label)
+	}
+	for i := 1; i < len(handlers); i++ {
+		fmt.Fprintf(&sb, "  h%d --> h%d\n", i-1, i)
+	}
+	return sb.String()
+}
+
+func handlerLabel(h config.Handler) string {
+	switch h.Type {
+	case config.HandlerTypeHTTP, "":
+		return fmt.Sprintf("http %s %s", h.HTTPHandler.Method, h.HTTPHandler.URL)
+	case config.HandlerTypeFilter:
+		return "filter " + h.FilterHandler.Expression
+	case config.HandlerTypeGRPC:
+		return fmt.Sprintf("grpc %s %s", h.GRPCHandler.Target, h.GRPCHandler.Method)
+	case config.HandlerTypeKafka:
+		return fmt.Sprintf("kafka %s %s", h.KafkaHandler.Mode, h.KafkaHandler.Topic)
+	case config.HandlerTypeAMQP:
+		if h.AMQPHandler.Mode == config.AMQPModeConsume {
+			return "amqp consume " + h.AMQPHandler.Queue
+		}
+		return fmt.Sprintf("amqp publish %s %s", h.AMQPHandler.Exchange, h.AMQPHandler.RoutingKey)
+	case config.HandlerTypeSQL:
+		mode := h.SQLHandler.Mode
+		if mode == "" {
+			mode = config.SQLModeQuery
+		}
+		return fmt.Sprintf("sql %s %s", h.SQLHandler.Driver, mode)
+	case config.HandlerTypeRedis:
+		return fmt.Sprintf("redis %s %s", h.RedisHandler.Command, h.RedisHandler.Key)
+	case config.HandlerTypeS3:
+		if h.S3Handler.Mode == config.S3ModeList {
+			return fmt.Sprintf("s3 list %s/%s", h.S3Handler.Bucket, h.S3Handler.Prefix)
+		}
+		return fmt.Sprintf("s3 put %s/%s", h.S3Handler.Bucket, h.S3Handler.Key)
+	case config.HandlerTypeGraphQL:
+		return fmt.Sprintf("graphql %s %s", h.GraphQLHandler.URL, h.GraphQLHandler.ResultsPath)
+	case config.HandlerTypeEmail:
+		if h.EmailHandler.Digest != nil {
+			return "email digest " + h.EmailHandler.Subject
+		}
+		return "email " + h.EmailHandler.Subject
+	case config.HandlerTypeWebhook:
+		method := h.WebhookHandler.Method
+		if method == "" {
+			method = http.MethodPost
+		}
+		return fmt.Sprintf("webhook %s %s", method, h.WebhookHandler.Path)
+	default:
+		return string(h.Type)
+	}
+}
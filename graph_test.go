@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testHandlers() config.HandlerMap {
+	return config.HandlerMap{
+		{
+			Name: "data-source",
+			Handler: config.Handler{
+				HTTPHandler: config.HTTPHandler{Method: "GET", URL: "http://example.com"},
+			},
+		},
+		{
+			Name: "filter",
+			Handler: config.Handler{
+				Type:          config.HandlerTypeFilter,
+				FilterHandler: config.FilterHandler{Expression: `{{ data-source.status }} == "new"`},
+			},
+		},
+	}
+}
+
+func TestRenderGraph_DOT(t *testing.T) {
+	graph, err := renderGraph(testHandlers(), graphFormatDOT)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `digraph datapipe {
+  rankdir=LR;
+  "data-source" [label="data-source\nhttp GET http://example.com"];
+  "filter" [label="filter\nfilter {{ data-source.status }} == \"new\""];
+  "data-source" -> "filter";
+}
+`, graph)
+}
+
+func TestRenderGraph_Mermaid(t *testing.T) {
+	graph, err := renderGraph(testHandlers(), graphFormatMermaid)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `flowchart LR
+  h0["data-source<br>http GET http://example.com"]
+  h1["filter<br>filter {{ data-source.status }} == #quot;new#quot;"]
+  h0 --> h1
+`, graph)
+}
+
+func TestRenderGraph_UnknownFormat(t *testing.T) {
+	_, err := renderGraph(testHandlers(), "svg")
+
+	assert.EqualError(t, err, "unknown graph format: 'svg'")
+}
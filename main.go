@@ -1,16 +1,8 @@
 package main
 
 import (
-	"context"
-	"log"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-
-	"github.com/jaxmef/datapipe/config"
-	"github.com/jaxmef/datapipe/engine"
-
-	"github.com/rs/zerolog"
 )
 
 const (
@@ -18,41 +10,59 @@ const (
 	DefaultConfigFilePath = "./config.yaml"
 )
 
-func main() {
-	configFilePath := os.Getenv(ConfigFilePathEnvVar)
-	if configFilePath == "" {
-		configFilePath = DefaultConfigFilePath
-	}
+const (
+	exitCodeOK    = 0
+	exitCodeError = 1
+	exitCodeUsage = 2
+)
 
-	cfg := config.NewConfig()
-	err := cfg.ParseFromYamlFile(configFilePath)
-	if err != nil {
-		log.Fatalf("failed to parse config: %s", err)
-	}
+// version is set at build time with -ldflags "-X main.version=<version>".
+// nolint: gochecknoglobals
+var version = "dev"
 
-	err = cfg.Validate()
-	if err != nil {
-		log.Fatalf("config validation failed: %s", err)
-	}
+const usage = `Usage: datapipe <command> [flags]
 
-	logger := zerolog.New(os.Stderr).Level(cfg.Engine.Log.Level.ToZerolog())
-	for k, v := range cfg.Engine.Log.StaticFields {
-		logger = logger.With().Str(k, v).Logger()
-	}
+Commands:
+  run       run the data pipe (default command)
+  validate  validate the config file
+  test      run the pipeline against the mocks and expectations of a test spec
+  graph     print the handler chain as a Graphviz DOT or Mermaid graph
+  version   print the version
+
+Run 'datapipe <command> -h' for the command flags.
+`
 
-	dp, err := engine.NewDataPipe(*cfg, logger)
-	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to create data pipe")
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+func runCommand(args []string) int {
+	command := "run"
+	if len(args) > 0 && !isFlag(args[0]) {
+		command, args = args[0], args[1:]
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		stop := make(chan os.Signal, 1)
-		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-		<-stop
-		logger.Info().Msg("shutting down")
-		cancel()
-	}()
+	switch command {
+	case "run":
+		return runCmd(args)
+	case "validate":
+		return validateCmd(args)
+	case "test":
+		return testCmd(args)
+	case "graph":
+		return graphCmd(args)
+	case "version":
+		fmt.Println(version)
+		return exitCodeOK
+	case "help":
+		fmt.Print(usage)
+		return exitCodeOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", command, usage)
+		return exitCodeUsage
+	}
+}
 
-	dp.Run(ctx)
+func isFlag(arg string) bool {
+	return len(arg) > 0 && arg[0] == '-'
 }
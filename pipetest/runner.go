@@ -0,0 +1,334 @@
+// Package pipetest runs pipeline configs against mocked handlers, so they can be tested without the real services.
+package pipetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jaxmef/datapipe/config"
+	"github.com/jaxmef/datapipe/engine"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const defaultMockResponseBody = `{"results":[{}]}`
+
+// Result is the outcome of a single test case.
+type Result struct {
+	Name       string
+	Mismatches []string
+}
+
+func (r Result) Passed() bool {
+	return len(r.Mismatches) == 0
+}
+
+// Run runs every test case of the spec against the config.
+func Run(ctx context.Context, cfg config.Config, spec Spec) ([]Result, error) {
+	results := make([]Result, 0, len(spec.Tests))
+	for _, tc := range spec.Tests {
+		result, err := runTestCase(ctx, cfg, tc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run '%s' test: %s", tc.Name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runTestCase(ctx context.Context, cfg config.Config, tc TestCase) (Result, error) {
+	transports := map[string]*mockTransport{}
+	handlers, err := engine.NewHandlers(cfg, func(handlerName string) http.RoundTripper {
+		transports[handlerName] = &mockTransport{mocks: tc.Mocks[handlerName]}
+		return transports[handlerName]
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	for _, handlerName := range sortedKeys(tc.Mocks) {
+		if _, ok := transports[handlerName]; !ok {
+			return Result{}, fmt.Errorf("mocks are defined for '%s', which is not a handler that sends HTTP requests",
+				handlerName)
+		}
+	}
+
+	filters := map[string]*observedFilter{}
+	for i, handlerItem := range *cfg.Handlers {
+		if handlerItem.Handler.Type == config.HandlerTypeFilter {
+			filters[handlerItem.Name] = &observedFilter{Handler: handlers[i]}
+			handlers[i] = filters[handlerItem.Name]
+		}
+	}
+
+	result := Result{Name: tc.Name}
+	jobErr := engine.RunHandlers(ctx, handlers)
+	switch {
+	case jobErr != nil && tc.Expect.ErrorContains == "":
+		result.Mismatches = append(result.Mismatches, fmt.Sprintf("unexpected job error: %s", jobErr))
+	case jobErr == nil && tc.Expect.ErrorContains != "":
+		result.Mismatches = append(result.Mismatches, fmt.Sprintf(
+			"expected job error containing '%s', got no error", tc.Expect.ErrorContains,
+		))
+	case jobErr != nil && !strings.Contains(jobErr.Error(), tc.Expect.ErrorContains):
+		result.Mismatches = append(result.Mismatches, fmt.Sprintf(
+			"expected job error containing '%s', got: %s", tc.Expect.ErrorContains, jobErr,
+		))
+	}
+
+	for _, handlerName := range sortedKeys(tc.Expect.Requests) {
+		transport, ok := transports[handlerName]
+		if !ok {
+			return Result{}, fmt.Errorf("requests are expected from '%s', which is not a handler that sends HTTP requests",
+				handlerName)
+		}
+		if diff := diffRequests(tc.Expect.Requests[handlerName], transport.recordedRequests()); diff != "" {
+			result.Mismatches = append(result.Mismatches, fmt.Sprintf(
+				"unexpected requests sent by '%s' handler:\n%s", handlerName, diff,
+			))
+		}
+	}
+
+	for _, filterName := range sortedKeys(tc.Expect.Filters) {
+		filter, ok := filters[filterName]
+		if !ok {
+			return Result{}, fmt.Errorf("outcome is expected from '%s', which is not a filter handler", filterName)
+		}
+		expected := tc.Expect.Filters[filterName]
+		actual := FilterOutcome{Passed: int(filter.passed.Load()), Dropped: int(filter.dropped.Load())}
+		if expected != actual {
+			result.Mismatches = append(result.Mismatches, fmt.Sprintf(
+				"unexpected outcome of '%s' filter: expected %d passed and %d dropped, got %d passed and %d dropped",
+				filterName, expected.Passed, expected.Dropped, actual.Passed, actual.Dropped,
+			))
+		}
+	}
+
+	return result, nil
+}
+
+// recordedRequest is a request sent by an HTTP handler.
+type recordedRequest struct {
+	method  string
+	url     string
+	headers http.Header
+	body    string
+}
+
+// mockTransport answers the requests of an HTTP handler with the first matching mock and records them.
+// Handlers without mocks get a single empty result.
+type mockTransport struct {
+	mocks []Mock
+
+	mux      sync.Mutex
+	requests []recordedRequest
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %s", err)
+		}
+	}
+
+	recorded := recordedRequest{
+		method:  req.Method,
+		url:     req.URL.String(),
+		headers: req.Header.Clone(),
+		body:    string(body),
+	}
+
+	t.mux.Lock()
+	t.requests = append(t.requests, recorded)
+	t.mux.Unlock()
+
+	if len(t.mocks) == 0 {
+		return MockResponse{}.toHTTPResponse(req), nil
+	}
+	for _, mock := range t.mocks {
+		if mock.Request.matches(recorded) {
+			return mock.Response.toHTTPResponse(req), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no mock matches request %s %s", recorded.method, recorded.url)
+}
+
+func (t *mockTransport) recordedRequests() []recordedRequest {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return append([]recordedRequest(nil), t.requests...)
+}
+
+func (m RequestMatcher) matches(req recordedRequest) bool {
+	if m.Method != "" && !strings.EqualFold(m.Method, req.method) {
+		return false
+	}
+	if m.URL != "" && m.URL != req.url {
+		return false
+	}
+	for key, value := range m.Headers {
+		if req.headers.Get(key) != value {
+			return false
+		}
+	}
+	if m.Body != "" && normalizeBody(m.Body) != normalizeBody(req.body) {
+		return false
+	}
+	return true
+}
+
+func (r MockResponse) toHTTPResponse(req *http.Request) *http.Response {
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := r.Body
+	if body == "" {
+		body = defaultMockResponseBody
+	}
+
+	header := http.Header{}
+	for key, value := range r.Headers {
+		header.Set(key, value)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// observedFilter counts the records a filter handler passes and drops.
+type observedFilter struct {
+	engine.Handler
+
+	passed  atomic.Int64
+	dropped atomic.Int64
+}
+
+func (f *observedFilter) Handle(ctx context.Context, data map[string]string) ([]engine.HandlerResult, error) {
+	results, err := f.Handler.Handle(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		f.dropped.Add(1)
+	} else {
+		f.passed.Add(1)
+	}
+	return results, nil
+}
+
+// diffRequests returns a unified diff between the expected and the actual requests, or an empty string if they match.
+// Requests are compared regardless of their order, as records are processed concurrently.
+func diffRequests(expected []ExpectedRequest, actual []recordedRequest) string {
+	// headers listed by any expected request are rendered for all requests
+	headerKeys := map[string]struct{}{}
+	for _, req := range expected {
+		for key := range req.Headers {
+			headerKeys[http.CanonicalHeaderKey(key)] = struct{}{}
+		}
+	}
+
+	expectedLines := make([]string, 0, len(expected))
+	for _, req := range expected {
+		headers := http.Header{}
+		for key, value := range req.Headers {
+			headers.Set(key, value)
+		}
+		expectedLines = append(expectedLines, renderRequest(req.Method, req.URL, headers, req.Body, headerKeys))
+	}
+
+	actualLines := make([]string, 0, len(actual))
+	for _, req := range actual {
+		actualLines = append(actualLines, renderRequest(req.method, req.url, req.headers, req.body, headerKeys))
+	}
+
+	sort.Strings(expectedLines)
+	sort.Strings(actualLines)
+
+	expectedText := strings.Join(expectedLines, "\n")
+	actualText := strings.Join(actualLines, "\n")
+	if expectedText == actualText {
+		return ""
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(expectedText),
+		B:        splitLines(actualText),
+		FromFile: "expected",
+		ToFile:   "actual",
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Sprintf("failed to build diff: %s", err)
+	}
+	return strings.TrimSuffix(diff, "\n")
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func renderRequest(method, url string, headers http.Header, body string, headerKeys map[string]struct{}) string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%s %s\n", strings.ToUpper(method), url)
+	for _, key := range sortedKeys(headerKeys) {
+		fmt.Fprintf(&sb, "%s: %s\n", key, headers.Get(key))
+	}
+	if body := normalizeBody(body); body != "" {
+		sb.WriteString(body + "\n")
+	}
+	return sb.String()
+}
+
+// normalizeBody indents JSON bodies with sorted keys, so they can be compared regardless of formatting.
+func normalizeBody(body string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return strings.TrimSpace(body)
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return strings.TrimSpace(body)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
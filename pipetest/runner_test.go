@@ -0,0 +1,268 @@
+package pipetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jaxmef/datapipe/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() config.Config {
+	return config.Config{
+		Handlers: &config.HandlerMap{
+			{
+				Name: "data-source",
+				Handler: config.Handler{HTTPHandler: config.HTTPHandler{
+					Method: "GET",
+					URL:    "http://source.local/items",
+				}},
+			},
+			{
+				Name: "status-filter",
+				Handler: config.Handler{
+					Type:          config.HandlerTypeFilter,
+					FilterHandler: config.FilterHandler{Expression: `{{ data-source.status }} == "new"`},
+				},
+			},
+			{
+				Name: "data-sink",
+				Handler: config.Handler{HTTPHandler: config.HTTPHandler{
+					Method:  "POST",
+					URL:     "http://sink.local/items/{{ data-source.id }}",
+					Headers: map[string]string{"Content-Type": "application/json"},
+					Body:    `{"title": {{ data-source.title }}}`,
+				}},
+			},
+		},
+	}
+}
+
+func sourceMocks() map[string][]Mock {
+	return map[string][]Mock{
+		"data-source": {
+			{
+				Request: RequestMatcher{Method: "GET", URL: "http://source.local/items"},
+				Response: MockResponse{Body: `{"results":[
+					{"id":1,"status":"new","title":"first"},
+					{"id":2,"status":"old","title":"second"},
+					{"id":3,"status":"new","title":"third"}
+				]}`},
+			},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Passed", func(t *testing.T) {
+		results, err := Run(context.Background(), testConfig(), Spec{Tests: []TestCase{{
+			Name:  "new items are saved",
+			Mocks: sourceMocks(),
+			Expect: Expectations{
+				Requests: map[string][]ExpectedRequest{
+					"data-sink": {
+						{
+							Method:  "POST",
+							URL:     "http://sink.local/items/3",
+							Headers: map[string]string{"Content-Type": "application/json"},
+							Body:    `{"title":"third"}`,
+						},
+						{
+							Method:  "POST",
+							URL:     "http://sink.local/items/1",
+							Headers: map[string]string{"Content-Type": "application/json"},
+							Body:    `{ "title": "first" }`,
+						},
+					},
+				},
+				Filters: map[string]FilterOutcome{
+					"status-filter": {Passed: 2, Dropped: 1},
+				},
+			},
+		}}})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(results))
+		assert.Equal(t, "new items are saved", results[0].Name)
+		assert.True(t, results[0].Passed(), results[0].Mismatches)
+	})
+
+	t.Run("Mismatches", func(t *testing.T) {
+		results, err := Run(context.Background(), testConfig(), Spec{Tests: []TestCase{{
+			Name:  "new items are saved",
+			Mocks: sourceMocks(),
+			Expect: Expectations{
+				Requests: map[string][]ExpectedRequest{
+					"data-sink": {
+						{Method: "POST", URL: "http://sink.local/items/1", Body: `{"title":"first"}`},
+						{Method: "POST", URL: "http://sink.local/items/3", Body: `{"title":"3rd"}`},
+					},
+				},
+				Filters: map[string]FilterOutcome{
+					"status-filter": {Passed: 3},
+				},
+			},
+		}}})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(results))
+		assert.False(t, results[0].Passed())
+		assert.Equal(t, []string{
+			`unexpected requests sent by 'data-sink' handler:
+--- expected
++++ actual
+@@ -5,5 +5,5 @@
+ 
+ POST http://sink.local/items/3
+ {
+-  "title": "3rd"
++  "title": "third"
+ }`,
+			"unexpected outcome of 'status-filter' filter: expected 3 passed and 0 dropped, got 2 passed and 1 dropped",
+		}, results[0].Mismatches)
+	})
+
+	t.Run("Unmatched request", func(t *testing.T) {
+		mocks := sourceMocks()
+		mocks["data-source"][0].Request.URL = "http://source.local/other"
+
+		results, err := Run(context.Background(), testConfig(), Spec{Tests: []TestCase{{
+			Name:  "unmatched",
+			Mocks: mocks,
+		}}})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(results))
+		require.Equal(t, 1, len(results[0].Mismatches))
+		assert.Contains(t, results[0].Mismatches[0], "no mock matches request GET http://source.local/items")
+	})
+
+	t.Run("Expected error", func(t *testing.T) {
+		mocks := sourceMocks()
+		mocks["data-source"][0].Response.Status = 500
+
+		results, err := Run(context.Background(), testConfig(), Spec{Tests: []TestCase{{
+			Name:   "source is down",
+			Mocks:  mocks,
+			Expect: Expectations{ErrorContains: "unexpected response code: got 500"},
+		}}})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(results))
+		assert.True(t, results[0].Passed(), results[0].Mismatches)
+	})
+
+	t.Run("Unknown filter", func(t *testing.T) {
+		_, err := Run(context.Background(), testConfig(), Spec{Tests: []TestCase{{
+			Name:   "unknown filter",
+			Mocks:  sourceMocks(),
+			Expect: Expectations{Filters: map[string]FilterOutcome{"data-sink": {}}},
+		}}})
+		assert.EqualError(
+			t, err,
+			"failed to run 'unknown filter' test: outcome is expected from 'data-sink', which is not a filter handler",
+		)
+	})
+	t.Run("Mocks of a handler that can't be mocked", func(t *testing.T) {
+		mocks := sourceMocks()
+		mocks["status-filter"] = []Mock{{Response: MockResponse{Body: `{"results":[]}`}}}
+
+		_, err := Run(context.Background(), testConfig(), Spec{Tests: []TestCase{{
+			Name:  "filter mock",
+			Mocks: mocks,
+		}}})
+		assert.EqualError(
+			t, err,
+			"failed to run 'filter mock' test: mocks are defined for 'status-filter', "+
+				"which is not a handler that sends HTTP requests",
+		)
+	})
+
+	t.Run("Mocks of an unknown handler", func(t *testing.T) {
+		mocks := sourceMocks()
+		mocks["data-sorce"] = mocks["data-source"]
+
+		_, err := Run(context.Background(), testConfig(), Spec{Tests: []TestCase{{
+			Name:  "typo",
+			Mocks: mocks,
+		}}})
+		assert.EqualError(
+			t, err,
+			"failed to run 'typo' test: mocks are defined for 'data-sorce', which is not a handler that sends HTTP requests",
+		)
+	})
+	t.Run("GraphQL handler", func(t *testing.T) {
+		cfg := config.Config{Handlers: &config.HandlerMap{
+			{
+				Name: "orders",
+				Handler: config.Handler{
+					Type: config.HandlerTypeGraphQL,
+					GraphQLHandler: config.GraphQLHandler{
+						URL:         "http://api.local/graphql",
+						Query:       "query { orders { id } }",
+						ResultsPath: "orders",
+					},
+				},
+			},
+			{
+				Name: "data-sink",
+				Handler: config.Handler{HTTPHandler: config.HTTPHandler{
+					Method: "POST",
+					URL:    "http://sink.local/orders/{{ orders.id }}",
+				}},
+			},
+		}}
+
+		results, err := Run(context.Background(), cfg, Spec{Tests: []TestCase{{
+			Name: "orders are saved",
+			Mocks: map[string][]Mock{
+				"orders": {{Response: MockResponse{Body: `{"data":{"orders":[{"id":1}]}}`}}},
+			},
+			Expect: Expectations{Requests: map[string][]ExpectedRequest{
+				"orders": {{
+					Method: "POST",
+					URL:    "http://api.local/graphql",
+					Body:   `{"query":"query { orders { id } }"}`,
+				}},
+				"data-sink": {{Method: "POST", URL: "http://sink.local/orders/1"}},
+			}},
+		}}})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(results))
+		assert.True(t, results[0].Passed(), results[0].Mismatches)
+	})
+	t.Run("S3 handler", func(t *testing.T) {
+		cfg := testConfig()
+		(*cfg.Handlers)[2] = config.HandlerMapItem{
+			Name: "archive",
+			Handler: config.Handler{
+				Type: config.HandlerTypeS3,
+				S3Handler: config.S3Handler{
+					Endpoint:        "http://s3.local",
+					Bucket:          "archive",
+					PathStyle:       true,
+					AccessKeyID:     config.Secret{Value: "key"},
+					SecretAccessKey: config.Secret{Value: "secret"},
+					Mode:            config.S3ModePut,
+					Key:             "items/{{ data-source.id }}.json",
+					Body:            `{"title": {{ data-source.title }}}`,
+				},
+			},
+		}
+
+		results, err := Run(context.Background(), cfg, Spec{Tests: []TestCase{{
+			Name: "new items are archived",
+			Mocks: map[string][]Mock{
+				"data-source": sourceMocks()["data-source"],
+				"archive":     {{Response: MockResponse{Headers: map[string]string{"ETag": `"abc"`}}}},
+			},
+			Expect: Expectations{Requests: map[string][]ExpectedRequest{
+				"archive": {
+					{Method: "PUT", URL: "http://s3.local/archive/items/1.json", Body: `{"title":"first"}`},
+					{Method: "PUT", URL: "http://s3.local/archive/items/3.json", Body: `{"title":"third"}`},
+				},
+			}},
+		}}})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(results))
+		assert.True(t, results[0].Passed(), results[0].Mismatches)
+	})
+}
@@ -0,0 +1,79 @@
+package pipetest
+
+import (
+	"fmt"
+	"os"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Spec is a set of test cases for a pipeline config.
+type Spec struct {
+	Tests []TestCase `yaml:"tests"`
+}
+
+// TestCase runs a single job with mocked handlers and checks its outcome.
+type TestCase struct {
+	Name string `yaml:"name"`
+	// Mocks are the responses of HTTP handlers keyed by the handler name.
+	// The first mock matching the request is used, handlers without mocks respond with a single empty result.
+	Mocks  map[string][]Mock `yaml:"mocks"`
+	Expect Expectations      `yaml:"expect"`
+}
+
+type Mock struct {
+	Request  RequestMatcher `yaml:"request"`
+	Response MockResponse   `yaml:"response"`
+}
+
+// RequestMatcher matches a rendered request. Empty fields match any value,
+// headers match if the request has all the listed headers.
+// JSON bodies are compared regardless of formatting and key order.
+type RequestMatcher struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+type MockResponse struct {
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+type Expectations struct {
+	// ErrorContains is the expected job error. The job is expected to succeed if it is empty.
+	ErrorContains string `yaml:"error_contains"`
+	// Requests are the requests each HTTP handler is expected to send, in any order.
+	Requests map[string][]ExpectedRequest `yaml:"requests"`
+	// Filters are the expected outcomes of filter handlers.
+	Filters map[string]FilterOutcome `yaml:"filters"`
+}
+
+// ExpectedRequest is compared by method, URL and body, headers are compared only if they are listed.
+type ExpectedRequest struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+type FilterOutcome struct {
+	Passed  int `yaml:"passed"`
+	Dropped int `yaml:"dropped"`
+}
+
+func (s *Spec) ParseFromYamlFile(filePath string) error {
+	rawYamlData, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read test spec file: %s", err)
+	}
+
+	err = yaml.Unmarshal(rawYamlData, s)
+	if err != nil {
+		return fmt.Errorf("failed to parse yaml: %s", err)
+	}
+
+	return nil
+}